@@ -5,22 +5,69 @@ const (
 	PodVlanmanNetworkAnnotation = "vlanman.dialo.ai/network"
 	// Annotation in pod that selects IP
 	PodVlanmanIPPoolAnnotation = "vlanman.dialo.ai/pool"
+	// Annotation in pod that ties it to its VlanIPAllocation
+	PodVlanmanAllocationAnnotation = "vlanman.dialo.ai/allocation"
+	// Annotation in pod requesting exact addresses from its pool, one per address family separated by a comma
+	PodVlanmanIPAnnotation = "vlanman.dialo.ai/ip"
+	// Annotation in pod that keeps its addresses across restarts if it belongs to a StatefulSet
+	PodVlanmanStickyAnnotation = "vlanman.dialo.ai/sticky"
+	// Annotation in pod that names the claim holding its addresses, the addresses survive pod deletion
+	PodVlanmanClaimAnnotation = "vlanman.dialo.ai/claim"
+	// Label identifying the StatefulSet of a sticky VlanIPAllocation
+	AllocationStatefulSetLabelKey = "vlanman.dialo.ai/statefulset"
+	// Label identifying the StatefulSet ordinal of a sticky VlanIPAllocation
+	AllocationOrdinalLabelKey = "vlanman.dialo.ai/ordinal"
+	// Label identifying the claim name of a sticky VlanIPAllocation
+	AllocationClaimLabelKey = "vlanman.dialo.ai/claim"
+	// Label identifying the network of a VlanIPAllocation
+	AllocationNetworkLabelKey = "vlanman.dialo.ai/network"
+	// Label identifying the pool of a VlanIPAllocation
+	AllocationPoolLabelKey = "vlanman.dialo.ai/pool"
 	// Label identifying a manager pod
 	ManagerSetLabelKey = "vlanman.dialo.ai/manager"
+	// Annotation in manager DaemonSet with the hash of its rendered labels and spec, compared to the hash
+	// of the desired DaemonSet to update it only when something changed
+	ManagerSpecHashAnnotation = "vlanman.dialo.ai/spec-hash"
+	// Annotation in manager pod recording how the parent interface of its vlan interface was selected, empty for the default route interface
+	ManagerParentInterfaceAnnotation = "vlanman.dialo.ai/parent-interface"
+	// Label set by the DaemonSet controller on manager pods, the template generation they were created from.
+	// It's compared to the generation annotation of the DaemonSet to find outdated pods.
+	ManagerTemplateGenerationLabelKey = "pod-template-generation"
+	// Annotation in manager pod replaced during a rollout, the pod moves its vlan interface to the host netns
+	// when it terminates so that the interface job of its replacement reuses it
+	ManagerHandoverAnnotation = "vlanman.dialo.ai/handover"
+	// Annotation in manager pod with the name of the job creating its vlan interface, removed when the interface is up
+	ManagerInterfaceJobAnnotation = "vlanman.dialo.ai/interface-job-name"
+	// Finalizer of a VlanNetwork, removed after its managers, their service and interface jobs are deleted
+	NetworkFinalizer = "vlanman.dialo.ai/cleanup"
+	// Finalizer of a VlanIPAllocation, removed once the release delay of its network passed
+	AllocationFinalizer = "vlanman.dialo.ai/release"
+	// Label identifying the network of an interface job and its pod
+	InterfaceJobNetworkLabelKey = "vlanman.dialo.ai/interface-job"
+	// Label identifying the network of a teardown job and its pod
+	TeardownJobNetworkLabelKey = "vlanman.dialo.ai/teardown-job"
 	// Label identifying a worker pod that should have access to vlan
 	WorkerPodLabelKey = "vlanman.dialo.ai/worker"
+	// Prefix of the per network label of a worker pod, the network name is the label name
+	WorkerPodNetworkLabelPrefix = "worker.vlanman.dialo.ai/"
 	// Lease name for IPAM
 	LeaseName = "vlanman-ipam-lease"
 	// Lease name for leader election among manager pods
 	LeaderElectionLeaseName = "vlanman-leader-election"
 	// Manager pod name prefix
 	ManagerSetNamePrefix = "vlan-manager"
-	// Wait for daemon timeout
+	// Seconds a manager pod can go without an IP before it's reported as timed out
 	WaitForDaemonTimeout = 30
+	// Seconds after which a network is reconciled again while an action waits for the cluster
+	ActionRequeueSeconds = 2
 	// Service name suffix
 	ServiceNameSuffix = "service"
 	// Job name prefix
 	JobNamePrefix = "create-vlan-job"
+	// Teardown job name prefix
+	TeardownJobNamePrefix = "delete-vlan-job"
+	// Seconds a teardown job can run, a node that can't run it doesn't block the deletion of the network
+	TeardownJobDeadlineSeconds = 120
 	// Manager container name
 	ManagerContainerName = "vlan-manager"
 	// Manager optional ip monitor container name
@@ -42,7 +89,50 @@ const (
 	// ManagerPodAPIPortName is the port on which manager pod is listening
 	ManagerPodAPIPortName = "api"
 	// PodMonitorName is the name that will be given to the pod monitor if monitoring is enabled
-	PodMonitorName                     = "vlanman-pod-monitor"
-	ReconcilerPendingIPsTimeoutSeconds = 35
-	UpdateStatusMaxRetries             = 5
+	PodMonitorName = "vlanman-pod-monitor"
+	// AllocationBindTimeoutSeconds is how long an allocation can stay pending
+	// before it's deleted, e.g. when the pod creation was rejected after the mutating webhook
+	AllocationBindTimeoutSeconds = 35
+	// AllocationGCIntervalSeconds is how often allocations of pods that are gone or finished
+	// are looked for, in case their pod events were missed
+	AllocationGCIntervalSeconds = 300
+	// AddressQuarantineSeconds is how long an address found in use on the vlan isn't allocated
+	AddressQuarantineSeconds = 3600
+	UpdateStatusMaxRetries   = 5
+)
+
+// Reasons of events recorded by the controller, the webhook and the managers
+const (
+	// A pool has no free addresses left
+	EventReasonPoolExhausted = "PoolExhausted"
+	// Addresses couldn't be allocated for a pod
+	EventReasonAllocationFailed = "AllocationFailed"
+	// A VlanIPAllocation couldn't be bound to its pod
+	EventReasonBindFailed = "AllocationBindFailed"
+	// A manager DaemonSet or pod didn't become ready in time
+	EventReasonManagerTimeout = "ManagerTimeout"
+	// An interface job failed to create the vlan interface
+	EventReasonInterfaceJobFailed = "InterfaceJobFailed"
+	// A manager pod was recreated because the parent interface of its node changed
+	EventReasonParentChanged = "ParentInterfaceChanged"
+	// A manager pod created from an older spec was recreated
+	EventReasonManagerUpdated = "ManagerUpdated"
+	// Reconciling the network failed
+	EventReasonReconcileFailed = "ReconcileFailed"
+	// The vlan interface is down on some nodes
+	EventReasonInterfacesDown = "InterfacesDown"
+	// The network became ready
+	EventReasonReady = "Ready"
+	// The vlan interface of a manager went down
+	EventReasonLinkDown = "LinkDown"
+	// The vlan interface of a manager came up
+	EventReasonLinkUp = "LinkUp"
+	// A manager pod became the leader holding the gateway addresses
+	EventReasonLeaderElected = "LeaderElected"
+	// The interfaces of a deleted network were removed from a node
+	EventReasonTeardownComplete = "TeardownComplete"
+	// The interfaces of a deleted network couldn't be removed from a node
+	EventReasonTeardownFailed = "TeardownFailed"
+	// A worker pod found its address in use by another host on the vlan
+	EventReasonAddressConflict = "AddressConflict"
 )
@@ -0,0 +1,75 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type AllocationPhase string
+
+const (
+	// Allocation was created by the mutating webhook, but the pod doesn't exist yet
+	AllocationPending AllocationPhase = "Pending"
+	// Allocation is owned by the pod and will be garbage collected with it
+	AllocationBound AllocationPhase = "Bound"
+	// Allocation is sticky and its pod is gone, the addresses are kept for the next pod with the same claim
+	AllocationReserved AllocationPhase = "Reserved"
+	// Allocation was deleted, its addresses are kept until the release delay of the network passed
+	AllocationReleased AllocationPhase = "Released"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Namespaced,shortName=vlanip
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Network",type="string",JSONPath=".spec.network"
+// +kubebuilder:printcolumn:name="Pool",type="string",JSONPath=".spec.pool"
+// +kubebuilder:printcolumn:name="Addresses",type="string",JSONPath=".spec.addresses"
+// +kubebuilder:printcolumn:name="Pod",type="string",JSONPath=".status.podName"
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+
+// VlanIPAllocation records addresses from a VlanNetwork pool held by a pod.
+// It's the source of truth for IPAM, an address is free if no allocation holds it.
+// Sticky allocations (StatefulSet pods in sticky pools and pods with a claim annotation)
+// are not owned by the pod, so they survive its deletion.
+// Allocations of networks with a release delay have a finalizer that keeps their addresses
+// taken for the delay after they're deleted.
+type VlanIPAllocation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VlanIPAllocationSpec   `json:"spec,omitempty"`
+	Status VlanIPAllocationStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type VlanIPAllocationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []VlanIPAllocation `json:"items"`
+}
+
+type VlanIPAllocationSpec struct {
+	// Network is the name of the VlanNetwork the addresses belong to
+	// +kubebuilder:validation:MinLength=1
+	Network string `json:"network"`
+	// Pool is the name of the pool in the network the addresses belong to
+	// +kubebuilder:validation:MinLength=1
+	Pool string `json:"pool"`
+	// Addresses allocated to the pod, with prefix length. Dual-stack pods hold one address per family.
+	// +kubebuilder:validation:MinItems=1
+	Addresses []string `json:"addresses"`
+}
+
+type VlanIPAllocationStatus struct {
+	// Phase is Pending until the controller binds the allocation to its pod
+	// +optional
+	Phase AllocationPhase `json:"phase,omitempty"`
+	// PodName is the name of the pod holding the addresses
+	// +optional
+	PodName string `json:"podName,omitempty"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VlanIPAllocation{}, &VlanIPAllocationList{})
+}
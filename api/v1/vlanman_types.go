@@ -14,11 +14,40 @@ const (
 	StateDown ConnectionState = "Down"
 )
 
+// Condition types of a VlanNetwork
+const (
+	// The managers are available and every vlan interface is up
+	ConditionReady = "Ready"
+	// Every node selected by the manager affinity runs an available manager pod
+	ConditionManagersAvailable = "ManagersAvailable"
+	// A pool has no free addresses left
+	ConditionPoolExhausted = "PoolExhausted"
+	// The vlan interface is down on some nodes
+	ConditionDegraded = "Degraded"
+	// Manager pods created from an older spec are being replaced one at a time
+	ConditionProgressing = "Progressing"
+)
+
+// Condition reasons of a VlanNetwork
+const (
+	ReasonReady               = "Ready"
+	ReasonManagersAvailable   = "ManagersAvailable"
+	ReasonManagersUnavailable = "ManagersUnavailable"
+	ReasonManagersNotFound    = "ManagersNotFound"
+	ReasonAddressesAvailable  = "AddressesAvailable"
+	ReasonPoolExhausted       = "PoolExhausted"
+	ReasonInterfacesUp        = "InterfacesUp"
+	ReasonInterfacesDown      = "InterfacesDown"
+	ReasonRollingManagers     = "RollingManagers"
+	ReasonManagersUpdated     = "ManagersUpdated"
+)
+
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 // +kubebuilder:object:root=true
 // +kubebuilder:resource:scope=Cluster,shortName=vlan
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="State",type="string",JSONPath=".status.shortState"
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
 
 type VlanNetwork struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -37,7 +66,9 @@ type VlanNetworkList struct {
 }
 
 type Gateway struct {
-	// +kubebuilder:validation:Pattern=`^((25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.){3}(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)(\/(3[0-2]|2[0-9]|1[0-9]|[0-9]))?$`
+	// Address is the IPv4 or IPv6 address of the gateway, optionally with a prefix length.
+	// For dual-stack networks specify one gateway per address family.
+	// +kubebuilder:validation:XValidation:rule="isIP(self) || isCIDR(self)",message="address must be an IPv4 or IPv6 address, optionally with a prefix length"
 	Address string  `json:"address"`
 	Routes  []Route `json:"routes"`
 }
@@ -49,31 +80,136 @@ type VlanNetworkSpec struct {
 	// +kubebuilder:validation:Minimum=1
 	// +kubebuilder:validation:Maximum=4094
 	VlanID int `json:"vlanId"`
+	// ServiceVlanID is the outer service tag (S-tag) of an 802.1ad (QinQ) network, VlanID is then
+	// the inner customer tag (C-tag). Networks without it use a single tag.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=4094
+	// +optional
+	ServiceVlanID int `json:"serviceVlanId,omitempty"`
+	// ServiceVlanProtocol is the protocol of the outer tag of a QinQ network
+	// +kubebuilder:validation:Enum="802.1ad";"802.1Q"
+	// +kubebuilder:default="802.1ad"
+	// +optional
+	ServiceVlanProtocol VlanProtocol `json:"serviceVlanProtocol,omitempty"`
 	// ManagerAffinity defines node affinity rules for the VLAN manager pods
 	// +optional
 	ManagerAffinity *corev1.Affinity `json:"managerAffinity,omitempty"`
 	// Pools defines the IP address pools available for allocation in this VLAN network
 	// +kubebuilder:validation:MinItems=1
 	Pools []VlanNetworkPool `json:"pools"`
-	// Mappings defines the node-to-interface mappings for this VLAN network
+	// Mappings defines the node-to-interface mappings for this VLAN network.
+	// A mapping with a matching NodeName always wins, otherwise the matching NodeSelector mapping
+	// with the highest Priority is used, the first one listed on ties.
+	// Nodes without a matching mapping use the interface with the default route.
+	// The parent interface is the link matching all of interfaceName, macAddress, pciAddress, driver and altName
+	// that are set, the interface with the default route if none are set.
 	// +optional
 	Mappings []IPMapping `json:"mappings"`
+	// Attachment selects the driver and mode of the interfaces created on top of the vlan interface
+	// for worker pods and the gateway. ipvlan interfaces share the MAC address of the parent,
+	// which helps with switches limiting the number of MAC addresses per port.
+	// macvlan-passthru allows a single interface per node, so it can't be used with gateways.
+	// +kubebuilder:validation:Enum=macvlan-bridge;macvlan-private;macvlan-vepa;macvlan-passthru;ipvlan-l2;ipvlan-l3
+	// +kubebuilder:default=macvlan-bridge
+	// +optional
+	Attachment AttachmentMode `json:"attachment,omitempty"`
+	// MTU of the vlan interface and of the interfaces of worker pods and the gateway.
+	// It can't be larger than the MTU of the parent interface, defaults to the MTU of the parent.
+	// +kubebuilder:validation:Minimum=68
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	MTU int `json:"mtu,omitempty"`
+	// InterfaceName is the name of the macvlan interface inside worker pods, defaults to "macvlan<vlanId>".
+	// Pods attached to more than one network need a different name for every network.
+	// +kubebuilder:validation:MaxLength=15
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_.-]+$`
+	// +optional
+	InterfaceName string `json:"interfaceName,omitempty"`
+	// AddressReleaseDelaySeconds is how long an address stays taken after its pod is deleted or finished,
+	// so that ARP and neighbor caches upstream expire before it's handed out again
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	AddressReleaseDelaySeconds int `json:"addressReleaseDelaySeconds,omitempty"`
+}
+
+type VlanProtocol string
+
+const (
+	VlanProtocol8021AD VlanProtocol = "802.1ad"
+	VlanProtocol8021Q  VlanProtocol = "802.1Q"
+)
+
+// OrDefault returns the protocol, or 802.1ad if it's not set
+func (p VlanProtocol) OrDefault() VlanProtocol {
+	if p == "" {
+		return VlanProtocol8021AD
+	}
+	return p
 }
 
+type AttachmentMode string
+
+const (
+	AttachmentMacvlanBridge   AttachmentMode = "macvlan-bridge"
+	AttachmentMacvlanPrivate  AttachmentMode = "macvlan-private"
+	AttachmentMacvlanVepa     AttachmentMode = "macvlan-vepa"
+	AttachmentMacvlanPassthru AttachmentMode = "macvlan-passthru"
+	AttachmentIPvlanL2        AttachmentMode = "ipvlan-l2"
+	AttachmentIPvlanL3        AttachmentMode = "ipvlan-l3"
+)
+
+// OrDefault returns the mode, or macvlan-bridge for networks created before the field existed
+func (m AttachmentMode) OrDefault() AttachmentMode {
+	if m == "" {
+		return AttachmentMacvlanBridge
+	}
+	return m
+}
+
+// +kubebuilder:validation:XValidation:rule="has(self.nodeName) != has(self.nodeSelector)",message="exactly one of nodeName and nodeSelector has to be set"
 type IPMapping struct {
 	// NodeName specifies the name of the Kubernetes node
 	// +kubebuilder:validation:MinLength=1
-	NodeName string `json:"nodeName"`
+	// +optional
+	NodeName string `json:"nodeName,omitempty"`
+	// NodeSelector selects the nodes by their labels, for node groups whose names aren't known in advance
+	// +optional
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+	// Priority decides between NodeSelector mappings matching the same node, higher wins
+	// +optional
+	Priority int `json:"priority,omitempty"`
 	// Interface specifies the network interface name on the node
 	// +kubebuilder:validation:MinLength=1
-	Interface string `json:"interfaceName"`
+	// +optional
+	Interface string `json:"interfaceName,omitempty"`
+	// MACAddress selects the parent interface by its MAC address
+	// +kubebuilder:validation:Pattern=`^([0-9a-fA-F]{2}:){5}[0-9a-fA-F]{2}$`
+	// +optional
+	MACAddress string `json:"macAddress,omitempty"`
+	// PCIAddress selects the parent interface by the PCI bus ID of its device, e.g. 0000:3b:00.0
+	// +kubebuilder:validation:Pattern=`^[0-9a-fA-F]{4}:[0-9a-fA-F]{2}:[0-9a-fA-F]{2}\.[0-7]$`
+	// +optional
+	PCIAddress string `json:"pciAddress,omitempty"`
+	// Driver selects the parent interface by the kernel driver of its device, e.g. mlx5_core
+	// +optional
+	Driver string `json:"driver,omitempty"`
+	// AltName selects the parent interface by one of its alternative names
+	// +optional
+	AltName string `json:"altName,omitempty"`
+	// MTU overrides the MTU of the network on this node
+	// +kubebuilder:validation:Minimum=68
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	MTU int `json:"mtu,omitempty"`
 }
 
 type Route struct {
-	// Destination specifies the target subnet for the route, in CIDR format. For example: "10.0.0.0/24", you can omit the subnet mask, in that case '/32' will be chosen. 10.0.0.0 -> 10.0.0.0/32
-	// +kubebuilder:validation:Pattern=`^((25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.){3}(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)(\/(3[0-2]|2[0-9]|1[0-9]|[0-9]))?$`
+	// Destination specifies the target subnet for the route, in CIDR format. For example: "10.0.0.0/24" or "fd00::/64", you can omit the subnet mask, in that case a host prefix will be chosen. 10.0.0.0 -> 10.0.0.0/32, fd00::1 -> fd00::1/128
+	// +kubebuilder:validation:XValidation:rule="isIP(self) || isCIDR(self)",message="dest must be an IPv4 or IPv6 address, optionally with a prefix length"
 	Destination string `json:"dest"`
 	// Via specifies the next-hop IP address for the route. If omitted, the route is assumed to be directly connected.
+	// It has to be of the same address family as Destination.
+	// +kubebuilder:validation:XValidation:rule="isIP(self)",message="via must be an IPv4 or IPv6 address"
 	// +optional
 	Via *string `json:"via"`
 	// Source determines how the source IP is selected for this route. Allowed values: "self": use an IP assigned from the current VLAN pool, "none": no source IP (use default behavior)
@@ -89,21 +225,132 @@ type VlanNetworkPool struct {
 	// +optional
 	Description string  `json:"description"`
 	Routes      []Route `json:"routes"`
-	// Addresses contains the list of IP addresses or CIDR blocks in this pool
+	// Addresses contains the list of IP addresses, subnets or ranges in this pool.
+	// An entry can be a single address with an optional prefix length ("10.0.0.1/24"),
+	// a subnet with host bits set to zero which expands to all usable addresses in it ("10.0.10.0/26")
+	// or an inclusive range with an optional prefix length ("10.0.10.20-10.0.10.80/24").
+	// IPv4 and IPv6 addresses can be mixed, in which case every pod gets one address
+	// of each family (a dual-stack pair).
 	// +kubebuilder:validation:MinItems=1
 	Addresses []string `json:"addresses"`
+	// Exclude contains addresses, subnets or ranges that will never be allocated from this pool
+	// +optional
+	Exclude []string `json:"exclude,omitempty"`
+	// Sticky makes StatefulSet pods keep their addresses across restarts. The addresses
+	// are keyed by StatefulSet name and ordinal and released when the StatefulSet is scaled down.
+	// Pods can opt in individually with the 'vlanman.dialo.ai/sticky' annotation.
+	// +optional
+	Sticky bool `json:"sticky,omitempty"`
 	// Name is the unique identifier for this IP pool
 	// +kubebuilder:validation:MinLength=1
 	Name string `json:"name"`
 }
 
 type VlanNetworkStatus struct {
-	// FreeIPs contains available IP addresses grouped by pool name
-	FreeIPs map[string][]string `json:"freeIPs"`
-	// PendingIPs contains IP addresses that are pending allocation, grouped by pool and request
-	PendingIPs map[string]map[string]string `json:"pendingIPs"`
-	State      map[string]ConnectionState   `json:"status"`
-	ShortState string                       `json:"shortState"`
+	// FreeIPCount contains the number of available IP addresses grouped by pool name.
+	// Allocated addresses are tracked by VlanIPAllocation objects.
+	FreeIPCount map[string]int64           `json:"freeIPCount"`
+	State       map[string]ConnectionState `json:"status"`
+	ShortState  string                     `json:"shortState"`
+	// ObservedGeneration is the generation of the spec the status was computed for
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions are Ready, ManagersAvailable, PoolExhausted, Degraded and Progressing
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Nodes contains the state of the vlan interface grouped by node name
+	// +optional
+	Nodes map[string]NodeAttachment `json:"nodes,omitempty"`
+	// QuarantinedAddresses are pool addresses that worker pods found in use by another host on the vlan.
+	// They aren't allocated until AddressQuarantineSeconds passed since the conflict was last detected.
+	// +listType=map
+	// +listMapKey=address
+	// +optional
+	QuarantinedAddresses []QuarantinedAddress `json:"quarantinedAddresses,omitempty"`
+}
+
+// QuarantinedAddress is an address a worker pod couldn't configure because another host on the vlan uses it
+type QuarantinedAddress struct {
+	Address string `json:"address"`
+	// MACAddress of the host using the address, it isn't known for conflicts found by IPv6 DAD
+	// +optional
+	MACAddress string `json:"macAddress,omitempty"`
+	// Pod is the worker pod that found the conflict, as namespace/name
+	// +optional
+	Pod string `json:"pod,omitempty"`
+	// LastDetected is the last time the conflict was found
+	LastDetected metav1.Time `json:"lastDetected"`
+}
+
+// NodeAttachment is the state of the vlan interface on a node
+type NodeAttachment struct {
+	// Pod is the name of the manager pod on the node
+	// +optional
+	Pod string `json:"pod,omitempty"`
+	// State of the vlan interface, reported by the manager pod
+	// +optional
+	State ConnectionState `json:"state,omitempty"`
+	// Parent is the link the vlan interface was created on
+	// +optional
+	Parent *ParentInterface `json:"parent,omitempty"`
+	// LinkIndex is the index of the vlan interface in the netns of the manager pod
+	// +optional
+	LinkIndex int `json:"linkIndex,omitempty"`
+	// LastTransitionTime is the last time State changed
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// LastError is the reason the vlan interface last went down
+	// +optional
+	LastError string `json:"lastError,omitempty"`
+}
+
+// SetNodeState records the state of the vlan interface on the node, the transition time
+// only changes with the state. A non-empty reason is kept as the last error.
+func (s *VlanNetworkStatus) SetNodeState(node, pod string, state ConnectionState, linkIndex int, reason string) {
+	if s.Nodes == nil {
+		s.Nodes = map[string]NodeAttachment{}
+	}
+	n := s.Nodes[node]
+	if n.State != state || n.Pod != pod {
+		n.LastTransitionTime = metav1.Now()
+	}
+	n.Pod = pod
+	n.State = state
+	if linkIndex != 0 {
+		n.LinkIndex = linkIndex
+	}
+	if reason != "" {
+		n.LastError = reason
+	}
+	s.Nodes[node] = n
+}
+
+// Quarantine records a conflict on the address, an address that's already quarantined is detected again
+func (s *VlanNetworkStatus) Quarantine(address, mac, pod string) {
+	q := QuarantinedAddress{
+		Address:      address,
+		MACAddress:   mac,
+		Pod:          pod,
+		LastDetected: metav1.Now(),
+	}
+	for i := range s.QuarantinedAddresses {
+		if s.QuarantinedAddresses[i].Address == address {
+			s.QuarantinedAddresses[i] = q
+			return
+		}
+	}
+	s.QuarantinedAddresses = append(s.QuarantinedAddresses, q)
+}
+
+// ParentInterface is the link the vlan interface was created on,
+// it's reported by the interface job in its termination message
+type ParentInterface struct {
+	Name       string `json:"name"`
+	MACAddress string `json:"macAddress,omitempty"`
+	PCIAddress string `json:"pciAddress,omitempty"`
+	Driver     string `json:"driver,omitempty"`
 }
 
 func (s *VlanNetworkStatus) UpdateShortState() {
@@ -3,40 +3,112 @@ package main
 import (
 	"fmt"
 	"log/slog"
-	"net"
 	"os"
 	"strconv"
 	"strings"
 
 	ip "github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
 )
 
-func findDefaultInterface() (ip.Link, error) {
-	_, dflt, err := net.ParseCIDR("0.0.0.0/0")
+// renameInNetns renames a link that was moved to the netns of the process and sets it up,
+// links moved between namespaces are down so they can be renamed
+func renameInNetns(pid int, name, newName string) error {
+	ns, err := netns.GetFromPid(pid)
 	if err != nil {
-		return nil, fmt.Errorf("Error parsing CIDR: %s", err.Error())
+		return fmt.Errorf("Couldn't get netns of pid %d: %w", pid, err)
 	}
-	links, err := ip.LinkList()
+	defer ns.Close()
+	handle, err := ip.NewHandleAt(ns)
 	if err != nil {
-		return nil, fmt.Errorf("Error listing links: %s", err.Error())
+		return fmt.Errorf("Couldn't open netlink handle in netns of pid %d: %w", pid, err)
+	}
+	defer handle.Close()
+
+	link, err := handle.LinkByName(name)
+	if err != nil {
+		return err
 	}
-	for _, l := range links {
-		routes, err := ip.RouteList(l, ip.FAMILY_V4)
+	if name != newName {
+		err = handle.LinkSetName(link, newName)
 		if err != nil {
-			return nil, fmt.Errorf("Error listing routes for device %s: %s", l.Attrs().Name, err.Error())
-		}
-		if len(routes) == 0 {
-			continue
+			return err
 		}
-		for _, r := range routes {
-			if r.Dst.String() == dflt.String() {
-				return l, nil
+	}
+	return handle.LinkSetUp(link)
+}
+
+func isDefaultRoute(r ip.Route) bool {
+	if r.Dst == nil {
+		return true
+	}
+	ones, _ := r.Dst.Mask.Size()
+	return ones == 0
+}
+
+// findDefaultInterface looks for the link holding the IPv4 default route,
+// falling back to the IPv6 one on IPv6-only nodes
+func findDefaultInterface() (ip.Link, error) {
+	links, err := ip.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("Error listing links: %s", err.Error())
+	}
+	for _, family := range []int{ip.FAMILY_V4, ip.FAMILY_V6} {
+		for _, l := range links {
+			routes, err := ip.RouteList(l, family)
+			if err != nil {
+				return nil, fmt.Errorf("Error listing routes for device %s: %s", l.Attrs().Name, err.Error())
+			}
+			for _, r := range routes {
+				if isDefaultRoute(r) {
+					return l, nil
+				}
 			}
 		}
 	}
 	return nil, fmt.Errorf("Default route not found")
 }
 
+// ensureServiceVlan returns the outer link of a QinQ network, creating it on the parent if needed.
+// The outer link stays in the host netns and is shared by all networks with the same service tag.
+func ensureServiceVlan(parent ip.Link, id int, protocol string) (ip.Link, error) {
+	proto := ip.VLAN_PROTOCOL_8021AD
+	if protocol == "802.1Q" {
+		proto = ip.VLAN_PROTOCOL_8021Q
+	}
+	name := "svlan" + strconv.Itoa(id)
+
+	existing, err := ip.LinkByName(name)
+	if err != nil {
+		attrs := ip.NewLinkAttrs()
+		attrs.Name = name
+		attrs.ParentIndex = parent.Attrs().Index
+		err = ip.LinkAdd(&ip.Vlan{
+			LinkAttrs:    attrs,
+			VlanId:       id,
+			VlanProtocol: proto,
+		})
+		if err != nil && !strings.Contains(err.Error(), "file exists") {
+			return nil, fmt.Errorf("Couldn't create service vlan interface %s: %w", name, err)
+		}
+		// another job might have created it in the meantime
+		existing, err = ip.LinkByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't get service vlan interface %s: %w", name, err)
+		}
+	}
+
+	svlan, ok := existing.(*ip.Vlan)
+	if !ok || svlan.VlanId != id || svlan.VlanProtocol != proto || svlan.ParentIndex != parent.Attrs().Index {
+		return nil, fmt.Errorf("Interface %s exists but isn't a %s vlan with ID %d on %s", name, proto, id, parent.Attrs().Name)
+	}
+	err = ip.LinkSetUp(svlan)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't set service vlan interface %s up: %w", name, err)
+	}
+	return svlan, nil
+}
+
 func main() {
 	h := slog.NewJSONHandler(os.Stdout, nil)
 	log := slog.New(h)
@@ -47,6 +119,9 @@ func main() {
 		log.Error("Couldn't parse ID to int", "ID", envID, "error", err)
 		os.Exit(1)
 	}
+	if os.Getenv("TEARDOWN") == "true" {
+		teardown(log, int(ID))
+	}
 
 	envPID := os.Getenv("PID")
 	PID, err := strconv.ParseInt(envPID, 10, 64)
@@ -56,34 +131,84 @@ func main() {
 	}
 	var dflt ip.Link
 
-	name := os.Getenv("INTERFACE")
-	if name == "" {
-		log.Info("Interface env var not set, finding by route")
+	criteria, err := criteriaFromEnv()
+	if err != nil {
+		log.Error("Couldn't parse parent interface criteria", "error", err)
+		os.Exit(1)
+	}
+	if criteria.empty() {
+		log.Info("Interface env vars not set, finding by route")
 		dflt, err = findDefaultInterface()
 		if err != nil {
-			log.Error("Couldn't find default interface and env var is empty", "msg", err)
+			log.Error("Couldn't find default interface and env vars are empty", "msg", err)
 			os.Exit(1)
 		}
 	} else {
-		log.Info("Interface env var set, finding by name")
-		dflt, err = ip.LinkByName(name)
+		log.Info("Interface env vars set, finding by criteria", "criteria", fmt.Sprintf("%+v", criteria))
+		dflt, err = findParent(criteria)
 		if err != nil {
-			log.Error("Couldn't find interface from env var by name", "name", name, "msg", err)
+			log.Error("Couldn't find interface from env vars", "msg", err)
 			os.Exit(1)
 		}
 	}
 	log.Info("Found interface", "name", dflt.Attrs().Name)
 
 	attrs := ip.NewLinkAttrs()
-	attrs.Name = "vlan" + envID
-	log.Info("Setting parent index", "to", dflt.Attrs().Index, "from", dflt.Attrs().Name)
-	attrs.ParentIndex = dflt.Attrs().Index
+	if envMTU := os.Getenv("MTU"); envMTU != "" && envMTU != "0" {
+		MTU, err := strconv.Atoi(envMTU)
+		if err != nil {
+			log.Error("Couldn't parse MTU to int", "MTU", envMTU, "error", err)
+			os.Exit(1)
+		}
+		// a vlan interface can't send frames larger than its parent
+		if MTU > dflt.Attrs().MTU {
+			log.Error("MTU of the network is larger than the MTU of the parent interface", "MTU", MTU, "parent", dflt.Attrs().Name, "parentMTU", dflt.Attrs().MTU)
+			os.Exit(1)
+		}
+		attrs.MTU = MTU
+	}
+	// the name of the interface in the manager's netns
+	finalName := "vlan" + envID
+	attrs.Name = finalName
+
+	parent := dflt
+	if serviceID := os.Getenv("SERVICE_ID"); serviceID != "" && serviceID != "0" {
+		SID, err := strconv.Atoi(serviceID)
+		if err != nil {
+			log.Error("Couldn't parse SERVICE_ID to int", "SERVICE_ID", serviceID, "error", err)
+			os.Exit(1)
+		}
+		parent, err = ensureServiceVlan(dflt, SID, os.Getenv("SERVICE_PROTOCOL"))
+		if err != nil {
+			log.Error("Couldn't set up service vlan interface", "error", err)
+			os.Exit(1)
+		}
+		log.Info("Using service vlan interface", "name", parent.Attrs().Name)
+		// networks with different service tags can share the customer tag,
+		// so the name in the host netns includes both
+		attrs.Name = fmt.Sprintf("vlan%d.%s", SID, envID)
+	}
+
+	log.Info("Setting parent index", "to", parent.Attrs().Index, "from", parent.Attrs().Name)
+	attrs.ParentIndex = parent.Attrs().Index
 	vlan := ip.Vlan{
 		LinkAttrs: attrs,
 		VlanId:    int(ID),
 	}
 
 	link, err := ip.LinkByName(attrs.Name)
+	if err == nil && adoptable(link, vlan) {
+		// handed over by the previous manager pod of the node during a rollout,
+		// the interfaces of worker pods are on top of it
+		log.Info("Reusing existing vlan interface", "name", attrs.Name)
+		err = adopt(link, attrs.MTU, int(PID), finalName)
+		if err != nil {
+			log.Error("Couldn't reuse existing vlan interface", "name", attrs.Name, "error", err)
+			os.Exit(1)
+		}
+		log.Info("Operation successful")
+		reportAndExit(log, dflt)
+	}
 	if err == nil {
 		err = ip.LinkDel(link)
 		if err != nil {
@@ -96,7 +221,7 @@ func main() {
 	if err != nil {
 		if strings.Contains(err.Error(), "file exists") {
 			log.Info("Interface already exists")
-			os.Exit(0)
+			reportAndExit(log, dflt)
 		}
 		log.Error("Couldn't create vlan interface", "name", attrs.Name, "error", err)
 		os.Exit(1)
@@ -120,6 +245,47 @@ func main() {
 		os.Exit(1)
 	}
 
+	if attrs.Name != finalName {
+		err = renameInNetns(int(PID), attrs.Name, finalName)
+		if err != nil {
+			log.Error("Couldn't rename link in netns", "pid", PID, "link", attrs.Name, "name", finalName, "error", err)
+			os.Exit(1)
+		}
+	}
+
 	log.Info("Operation successful")
+	reportAndExit(log, dflt)
+}
+
+// adoptable checks whether an existing link in the host netns is the vlan interface that would be created
+func adoptable(link ip.Link, desired ip.Vlan) bool {
+	existing, ok := link.(*ip.Vlan)
+	return ok && existing.VlanId == desired.VlanId && existing.ParentIndex == desired.ParentIndex
+}
+
+// adopt moves an existing vlan interface to the netns of the manager, its MTU is updated if it's set.
+// It's not deleted on failure, worker pods might still use it.
+func adopt(link ip.Link, mtu, pid int, finalName string) error {
+	if mtu != 0 && link.Attrs().MTU != mtu {
+		err := ip.LinkSetMTU(link, mtu)
+		if err != nil {
+			return fmt.Errorf("Couldn't set MTU: %w", err)
+		}
+	}
+	name := link.Attrs().Name
+	err := ip.LinkSetNsPid(link, pid)
+	if err != nil {
+		return fmt.Errorf("Couldn't move link to netns of pid %d: %w", pid, err)
+	}
+	// links are down after moving between netns, so the worker pods on top of it
+	// are disconnected until it's set up again
+	return renameInNetns(pid, name, finalName)
+}
+
+func reportAndExit(log *slog.Logger, parent ip.Link) {
+	err := reportParent(parent)
+	if err != nil {
+		log.Error("Couldn't report parent interface", "error", err)
+	}
 	os.Exit(0)
 }
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	vlanmanv1 "dialo.ai/vlanman/api/v1"
+	ip "github.com/vishvananda/netlink"
+)
+
+// parentCriteria identifies the parent interface, a link has to match all criteria that are set
+type parentCriteria struct {
+	Name    string
+	MAC     net.HardwareAddr
+	PCI     string
+	Driver  string
+	AltName string
+}
+
+func criteriaFromEnv() (parentCriteria, error) {
+	c := parentCriteria{
+		Name:    os.Getenv("INTERFACE"),
+		PCI:     strings.ToLower(os.Getenv("INTERFACE_PCI")),
+		Driver:  os.Getenv("INTERFACE_DRIVER"),
+		AltName: os.Getenv("INTERFACE_ALTNAME"),
+	}
+	if mac := os.Getenv("INTERFACE_MAC"); mac != "" {
+		hw, err := net.ParseMAC(mac)
+		if err != nil {
+			return c, fmt.Errorf("Invalid MAC address %s: %w", mac, err)
+		}
+		c.MAC = hw
+	}
+	return c, nil
+}
+
+func (c parentCriteria) empty() bool {
+	return c.Name == "" && c.MAC == nil && c.PCI == "" && c.Driver == "" && c.AltName == ""
+}
+
+func (c parentCriteria) matches(l ip.Link) bool {
+	attrs := l.Attrs()
+	if c.Name != "" && attrs.Name != c.Name {
+		return false
+	}
+	// enslaved links might have the MAC address of their master, the permanent one is checked as well
+	if c.MAC != nil && attrs.HardwareAddr.String() != c.MAC.String() && attrs.PermHWAddr.String() != c.MAC.String() {
+		return false
+	}
+	if c.PCI != "" && linkPCIAddress(attrs) != c.PCI {
+		return false
+	}
+	if c.Driver != "" && linkDriver(attrs.Name) != c.Driver {
+		return false
+	}
+	if c.AltName != "" && !slices.Contains(attrs.AltNames, c.AltName) {
+		return false
+	}
+	return true
+}
+
+// findParent returns the link matching the criteria. Vlans, macvlans and enslaved links share
+// the MAC address and device of their parent or master, they're skipped if the match is ambiguous.
+func findParent(c parentCriteria) (ip.Link, error) {
+	links, err := ip.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("Error listing links: %s", err.Error())
+	}
+	matching := slices.DeleteFunc(links, func(l ip.Link) bool {
+		return !c.matches(l)
+	})
+	if len(matching) > 1 {
+		matching = slices.DeleteFunc(matching, func(l ip.Link) bool {
+			return l.Attrs().MasterIndex != 0 || slices.Contains([]string{"vlan", "macvlan", "ipvlan", "veth"}, l.Type())
+		})
+	}
+	switch len(matching) {
+	case 0:
+		return nil, fmt.Errorf("No link matches %+v", c)
+	case 1:
+		return matching[0], nil
+	}
+	names := []string{}
+	for _, l := range matching {
+		names = append(names, l.Attrs().Name)
+	}
+	return nil, fmt.Errorf("More than one link matches %+v: %s", c, strings.Join(names, ", "))
+}
+
+// linkPCIAddress returns the PCI bus ID of the link's device, empty for virtual links.
+// Older kernels don't report the parent device over netlink, sysfs is used then.
+func linkPCIAddress(attrs *ip.LinkAttrs) string {
+	if attrs.ParentDevBus == "pci" {
+		return attrs.ParentDev
+	}
+	dev, err := filepath.EvalSymlinks(filepath.Join("/sys/class/net", attrs.Name, "device"))
+	if err != nil || !strings.Contains(dev, "/pci") {
+		return ""
+	}
+	return filepath.Base(dev)
+}
+
+// linkDriver returns the name of the kernel driver of the link's device, empty for virtual links
+func linkDriver(name string) string {
+	driver, err := filepath.EvalSymlinks(filepath.Join("/sys/class/net", name, "device", "driver"))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(driver)
+}
+
+// reportParent writes the parent interface to the termination message,
+// the controller copies it to the status of the network
+func reportParent(l ip.Link) error {
+	attrs := l.Attrs()
+	parent := vlanmanv1.ParentInterface{
+		Name:       attrs.Name,
+		PCIAddress: linkPCIAddress(attrs),
+		Driver:     linkDriver(attrs.Name),
+	}
+	if attrs.HardwareAddr != nil {
+		parent.MACAddress = attrs.HardwareAddr.String()
+	}
+	msg, err := json.Marshal(parent)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile("/dev/termination-log", msg, 0o644)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	ip "github.com/vishvananda/netlink"
+)
+
+// teardown removes the links of a deleted network left in the host netns, e.g. by an interface job
+// that failed to move its link. Links moved to the netns of a manager pod were removed with it.
+// Routes and addresses are removed before their link, the removed items are reported in the termination message.
+func teardown(log *slog.Logger, id int) {
+	names := []string{"vlan" + strconv.Itoa(id), "macvlangw" + strconv.Itoa(id)}
+	serviceID, err := strconv.Atoi(os.Getenv("SERVICE_ID"))
+	if err != nil {
+		serviceID = 0
+	}
+	if serviceID != 0 {
+		names = append(names, fmt.Sprintf("vlan%d.%d", serviceID, id))
+	}
+
+	removed := []string{}
+	for _, name := range names {
+		link, err := ip.LinkByName(name)
+		if err != nil {
+			var notFound ip.LinkNotFoundError
+			if errors.As(err, &notFound) {
+				continue
+			}
+			log.Error("Couldn't get link", "name", name, "error", err)
+			os.Exit(1)
+		}
+		addrs, routes, err := removeLink(link)
+		if err != nil {
+			log.Error("Couldn't remove link", "name", name, "error", err)
+			os.Exit(1)
+		}
+		log.Info("Removed link", "name", name, "addresses", addrs, "routes", routes)
+		removed = append(removed, fmt.Sprintf("%s (%d addresses, %d routes)", name, addrs, routes))
+	}
+
+	if serviceID != 0 && os.Getenv("REMOVE_SERVICE_VLAN") == "true" {
+		name, err := removeServiceVlan(serviceID)
+		if err != nil {
+			log.Error("Couldn't remove service vlan interface", "error", err)
+			os.Exit(1)
+		}
+		if name != "" {
+			removed = append(removed, name)
+		}
+	}
+
+	msg := "nothing was left"
+	if len(removed) != 0 {
+		msg = strings.Join(removed, ", ")
+	}
+	log.Info("Teardown successful", "removed", msg)
+	err = os.WriteFile("/dev/termination-log", []byte(msg), 0o644)
+	if err != nil {
+		log.Error("Couldn't report teardown result", "error", err)
+	}
+	os.Exit(0)
+}
+
+// removeLink removes the routes and addresses of the link and then the link,
+// returns the number of addresses and routes removed
+func removeLink(link ip.Link) (int, int, error) {
+	routes, err := ip.RouteList(link, ip.FAMILY_ALL)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Couldn't list routes: %w", err)
+	}
+	for _, r := range routes {
+		err = ip.RouteDel(&r)
+		if err != nil {
+			return 0, 0, fmt.Errorf("Couldn't delete route to %s: %w", r.Dst, err)
+		}
+	}
+	addrs, err := ip.AddrList(link, ip.FAMILY_ALL)
+	if err != nil {
+		return 0, len(routes), fmt.Errorf("Couldn't list addresses: %w", err)
+	}
+	for _, a := range addrs {
+		err = ip.AddrDel(link, &a)
+		if err != nil {
+			return 0, len(routes), fmt.Errorf("Couldn't delete address %s: %w", a.IPNet, err)
+		}
+	}
+	err = ip.LinkDel(link)
+	if err != nil {
+		return len(addrs), len(routes), err
+	}
+	return len(addrs), len(routes), nil
+}
+
+// removeServiceVlan removes the outer link of a QinQ network unless links in the host netns still use it,
+// returns its name if it was removed
+func removeServiceVlan(id int) (string, error) {
+	name := "svlan" + strconv.Itoa(id)
+	svlan, err := ip.LinkByName(name)
+	if err != nil {
+		var notFound ip.LinkNotFoundError
+		if errors.As(err, &notFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	links, err := ip.LinkList()
+	if err != nil {
+		return "", fmt.Errorf("Error listing links: %w", err)
+	}
+	for _, l := range links {
+		if l.Attrs().ParentIndex == svlan.Attrs().Index {
+			return "", nil
+		}
+	}
+	err = ip.LinkDel(svlan)
+	if err != nil {
+		return "", err
+	}
+	return name, nil
+}
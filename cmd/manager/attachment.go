@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+
+	vlanmanv1 "dialo.ai/vlanman/api/v1"
+	ip "github.com/vishvananda/netlink"
+)
+
+// newAttachmentLink returns the link created on top of the vlan interface
+// for worker pods and the gateway, depending on the network's attachment mode
+func newAttachmentLink(attrs ip.LinkAttrs, mode vlanmanv1.AttachmentMode) (ip.Link, error) {
+	switch mode.OrDefault() {
+	case vlanmanv1.AttachmentMacvlanBridge:
+		return &ip.Macvlan{LinkAttrs: attrs, Mode: ip.MACVLAN_MODE_BRIDGE}, nil
+	case vlanmanv1.AttachmentMacvlanPrivate:
+		return &ip.Macvlan{LinkAttrs: attrs, Mode: ip.MACVLAN_MODE_PRIVATE}, nil
+	case vlanmanv1.AttachmentMacvlanVepa:
+		return &ip.Macvlan{LinkAttrs: attrs, Mode: ip.MACVLAN_MODE_VEPA}, nil
+	case vlanmanv1.AttachmentMacvlanPassthru:
+		return &ip.Macvlan{LinkAttrs: attrs, Mode: ip.MACVLAN_MODE_PASSTHRU}, nil
+	case vlanmanv1.AttachmentIPvlanL2:
+		return &ip.IPVlan{LinkAttrs: attrs, Mode: ip.IPVLAN_MODE_L2}, nil
+	case vlanmanv1.AttachmentIPvlanL3:
+		return &ip.IPVlan{LinkAttrs: attrs, Mode: ip.IPVLAN_MODE_L3}, nil
+	}
+	return nil, fmt.Errorf("Unknown attachment mode '%s'", mode)
+}
+
+// attachmentAlias marks links created for worker pods, so only those are replaced
+// when a worker requests its link again
+func attachmentAlias() string {
+	return "vlanman/" + envs.ownerNetName
+}
+
+// sameAttachment checks whether an existing link has the driver and mode of the desired one
+func sameAttachment(existing, desired ip.Link) bool {
+	switch d := desired.(type) {
+	case *ip.Macvlan:
+		e, ok := existing.(*ip.Macvlan)
+		return ok && e.Mode == d.Mode
+	case *ip.IPVlan:
+		e, ok := existing.(*ip.IPVlan)
+		return ok && e.Mode == d.Mode
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	vlanmanv1 "dialo.ai/vlanman/api/v1"
+	"dialo.ai/vlanman/pkg/comms"
+	errs "dialo.ai/vlanman/pkg/errors"
+	"dialo.ai/vlanman/pkg/ipam"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// conflict quarantines an address a worker pod found in use by another host on the vlan,
+// workers can't update the status of the network themselves. Only addresses the pod holds are quarantined.
+func conflict(k8sclient client.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r == nil || r.Body == nil {
+			logger.Error("Request / body is nil", "error", errs.ErrNilUnrecoverable)
+			w.WriteHeader(400)
+			return
+		}
+		out, err := io.ReadAll(r.Body)
+		if err != nil {
+			logger.Error("Couldn't read request body", "error", errs.NewParsingError("request body", err))
+			w.WriteHeader(400)
+			return
+		}
+		req := comms.AddressConflictRequest{}
+		err = json.Unmarshal(out, &req)
+		if err != nil || req.Address == "" {
+			logger.Error("Couldn't unmarshal request body", "error", errs.NewParsingError("request body", err))
+			w.WriteHeader(400)
+			return
+		}
+		addr, err := netip.ParseAddr(req.Address)
+		if err != nil {
+			logger.Error("Couldn't parse conflicting address", "error", errs.NewParsingError("conflicting address", err))
+			w.WriteHeader(400)
+			return
+		}
+		pod := req.Namespace + "/" + req.Pod
+		logger.Info("Worker pod found its address in use", "address", req.Address, "mac", req.MAC, "pod", pod)
+
+		ctx := r.Context()
+		err = heldAddress(ctx, k8sclient, req, addr.Unmap())
+		if errors.Is(err, errs.ErrK8sClient) {
+			logger.Error("Couldn't check the conflicting address", "err", err, "address", req.Address, "pod", pod)
+			w.WriteHeader(500)
+			return
+		}
+		if err != nil {
+			logger.Error("Rejected address conflict report", "err", err, "address", req.Address, "pod", pod)
+			w.WriteHeader(403)
+			return
+		}
+
+		err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			net := vlanmanv1.VlanNetwork{}
+			err := k8sclient.Get(ctx, types.NamespacedName{Name: envs.ownerNetName}, &net)
+			if err != nil {
+				return err
+			}
+			net.Status.Quarantine(req.Address, req.MAC, pod)
+			return k8sclient.Status().Update(ctx, &net)
+		})
+		if err != nil {
+			logger.Error("Failed to quarantine address", "err", err, "address", req.Address, "name", envs.ownerNetName)
+			w.WriteHeader(500)
+			return
+		}
+
+		holder := "another host"
+		if req.MAC != "" {
+			holder = req.MAC
+		}
+		events.Network(ctx, corev1.EventTypeWarning, vlanmanv1.EventReasonAddressConflict,
+			"Address %s of pod %s is used by %s on the vlan, it's quarantined", req.Address, pod, holder)
+		w.WriteHeader(200)
+	}
+}
+
+// heldAddress checks that the address is in a pool of the network and held by an allocation of
+// the reporting pod, so pods can't get addresses of other pods or hosts quarantined
+func heldAddress(ctx context.Context, k8sclient client.Client, req comms.AddressConflictRequest, addr netip.Addr) error {
+	net := vlanmanv1.VlanNetwork{}
+	err := k8sclient.Get(ctx, types.NamespacedName{Name: envs.ownerNetName}, &net)
+	if err != nil {
+		return errs.NewClientRequestError("Get VlanNetwork", err)
+	}
+	inPool := slices.ContainsFunc(net.Spec.Pools, func(p vlanmanv1.VlanNetworkPool) bool {
+		pool, err := ipam.NewPool(p)
+		return err == nil && pool.Contains(addr)
+	})
+	if !inPool {
+		return fmt.Errorf("Address %s is not in the pools of network %s", addr, net.Name)
+	}
+
+	pod := corev1.Pod{}
+	err = k8sclient.Get(ctx, types.NamespacedName{Name: req.Pod, Namespace: req.Namespace}, &pod)
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("Pod %s/%s doesn't exist", req.Namespace, req.Pod)
+	}
+	if err != nil {
+		return errs.NewClientRequestError("Get pod reporting an address conflict", err)
+	}
+	names, ok := pod.Annotations[vlanmanv1.PodVlanmanAllocationAnnotation]
+	if !ok {
+		return fmt.Errorf("Pod %s/%s has no allocations", req.Namespace, req.Pod)
+	}
+	for name := range strings.SplitSeq(names, ",") {
+		alloc := vlanmanv1.VlanIPAllocation{}
+		err = k8sclient.Get(ctx, types.NamespacedName{Name: name, Namespace: req.Namespace}, &alloc)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return errs.NewClientRequestError("Get VlanIPAllocation of pod reporting an address conflict", err)
+		}
+		if alloc.Spec.Network == net.Name && ipam.ParseAddrs(alloc.Spec.Addresses...)[addr] {
+			return nil
+		}
+	}
+	return fmt.Errorf("Pod %s/%s doesn't hold address %s in network %s", req.Namespace, req.Pod, addr, net.Name)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	vlanmanv1 "dialo.ai/vlanman/api/v1"
+	u "dialo.ai/vlanman/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// EventRecorder records events on the network, the manager pod
+// and the worker pods of the network on the same node
+type EventRecorder struct {
+	client   client.Client
+	recorder record.EventRecorder
+	env      Envs
+	podName  string
+}
+
+func NewEventRecorder(config *rest.Config, k8sclient client.Client, e Envs, podName string) (*EventRecorder, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating clientset for events: %w", err)
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(k8sclient.Scheme(), corev1.EventSource{
+		Component: vlanmanv1.ManagerContainerName,
+		Host:      e.nodeName,
+	})
+	return &EventRecorder{
+		client:   k8sclient,
+		recorder: recorder,
+		env:      e,
+		podName:  podName,
+	}, nil
+}
+
+// Network records an event on the network, it's fetched to get its UID
+func (r *EventRecorder) Network(ctx context.Context, eventType, reason, msgFmt string, args ...any) {
+	if r == nil {
+		return
+	}
+	net := vlanmanv1.VlanNetwork{}
+	err := r.client.Get(ctx, types.NamespacedName{Name: r.env.ownerNetName}, &net)
+	if err != nil {
+		logger.Error("Couldn't get network for event", "err", err, "reason", reason)
+		return
+	}
+	r.recorder.Eventf(&net, eventType, reason, msgFmt, args...)
+}
+
+// Pods records an event on the manager pod and on the worker pods
+// of the network running on the node, they're affected by the vlan interface
+func (r *EventRecorder) Pods(ctx context.Context, eventType, reason, msgFmt string, args ...any) {
+	if r == nil {
+		return
+	}
+	manager := corev1.Pod{}
+	err := r.client.Get(ctx, types.NamespacedName{Name: r.podName, Namespace: r.env.namespace}, &manager)
+	if err != nil {
+		logger.Error("Couldn't get manager pod for event", "err", err, "reason", reason)
+	} else {
+		r.recorder.Eventf(&manager, eventType, reason, msgFmt, args...)
+	}
+
+	if r.env.nodeName == "" {
+		return
+	}
+	workers := corev1.PodList{}
+	err = r.client.List(ctx, &workers,
+		client.MatchingLabels{u.WorkerNetworkLabelKey(r.env.ownerNetName): "true"},
+		client.MatchingFields{"spec.nodeName": r.env.nodeName},
+	)
+	if err != nil {
+		logger.Error("Couldn't list worker pods for event", "err", err, "reason", reason)
+		return
+	}
+	for _, w := range workers.Items {
+		r.recorder.Eventf(&w, eventType, reason, msgFmt, args...)
+	}
+}
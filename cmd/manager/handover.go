@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	vlanmanv1 "dialo.ai/vlanman/api/v1"
+	ip "github.com/vishvananda/netlink"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// handOver moves the vlan interface to the host netns when the controller replaces the pod during a rollout.
+// The interfaces of worker pods are created on top of it and would be removed with the netns of the pod,
+// the interface job of the replacement moves it to its netns again. The gateway link stays and is removed
+// with the netns, the leader is replaced last.
+func handOver(k8sclient client.Client, podName string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pod := corev1.Pod{}
+	err := k8sclient.Get(ctx, types.NamespacedName{Name: podName, Namespace: envs.namespace}, &pod)
+	if err != nil {
+		logger.Error("Couldn't get manager pod, not handing over the vlan interface", "err", err)
+		return
+	}
+	if pod.Annotations[vlanmanv1.ManagerHandoverAnnotation] != "true" {
+		return
+	}
+
+	name := fmt.Sprintf("vlan%d", envs.vlanID)
+	// the name in the host netns includes the service tag, see the interface job
+	hostName := name
+	if envs.serviceVlanID != 0 {
+		hostName = fmt.Sprintf("vlan%d.%d", envs.serviceVlanID, envs.vlanID)
+	}
+	handingOver.Store(true)
+	err = moveToHost(name, hostName)
+	if err != nil {
+		logger.Error("Couldn't hand over the vlan interface", "name", name, "err", err)
+		return
+	}
+	logger.Info("Handed over the vlan interface", "name", hostName)
+}
+
+// moveToHost moves the link to the netns of PID 1, the manager runs in the host PID namespace.
+// Moving by PID doesn't open the netns of PID 1, that would need ptrace access to it. The link
+// is down after moving, the interface job of the replacement sets it up again when it adopts it.
+func moveToHost(name, hostName string) error {
+	link, err := ip.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	if hostName != name {
+		// links have to be down to be renamed
+		err = ip.LinkSetDown(link)
+		if err != nil {
+			return err
+		}
+		err = ip.LinkSetName(link, hostName)
+		if err != nil {
+			return err
+		}
+	}
+	err = ip.LinkSetNsPid(link, 1)
+	if err != nil {
+		return fmt.Errorf("Couldn't move link to host netns: %w", err)
+	}
+	return nil
+}
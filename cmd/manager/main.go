@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"flag"
@@ -12,10 +11,10 @@ import (
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 
 	"syscall"
@@ -24,15 +23,20 @@ import (
 	vlanmanv1 "dialo.ai/vlanman/api/v1"
 	"dialo.ai/vlanman/pkg/comms"
 	errs "dialo.ai/vlanman/pkg/errors"
+	u "dialo.ai/vlanman/pkg/utils"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/procfs"
 	ip "github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/leaderelection"
 	rl "k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -91,7 +95,12 @@ func macvlan(w http.ResponseWriter, r *http.Request) {
 		writeError("Couldn't unmarshal request body", errs.NewParsingError("request body", err))
 		return
 	}
-	logger.Info("Received request for macvlan", "nsid", mvr.NsID)
+	logger.Info("Received request for macvlan", "nsid", mvr.NsID, "name", mvr.Name)
+
+	// requests are handled one at a time, so workers initialising
+	// concurrently on the same node can't race on the vlan interface
+	macvlanMu.Lock()
+	defer macvlanMu.Unlock()
 
 	linkName := "vlan" + strconv.FormatInt(int64(vlanID), 10)
 	logger.Info("Looking for link by name", "name", linkName)
@@ -102,91 +111,102 @@ func macvlan(w http.ResponseWriter, r *http.Request) {
 	}
 	logger.Info("Found link by name", "name", linkName)
 
+	finalName := mvr.Name
+	if finalName == "" {
+		finalName = u.DefaultInterfaceName(vlanID)
+	}
+
+	logger.Info("Looking for nsid", "nsid", mvr.NsID)
+	nsFile, PID, err := openNetNs(uint64(mvr.NsID))
+	if err != nil {
+		writeError("Failed to find nsid", err)
+		return
+	}
+	defer nsFile.Close()
+	logger.Info("Found PID", "PID", PID)
+
+	podHandle, err := ip.NewHandleAt(netns.NsHandle(nsFile.Fd()))
+	if err != nil {
+		writeError("Couldn't open netlink handle in pod netns", err)
+		return
+	}
+	defer podHandle.Close()
+
+	// the temporary name is derived from the pod's netns so it's unique per pod,
+	// an existing link with that name is a leftover from an earlier request of the same pod
 	attrs := ip.NewLinkAttrs()
-	attrs.Name = "macvlan" + strconv.FormatInt(int64(vlanID), 10)
+	attrs.Name = fmt.Sprintf("mvt%08x", uint32(mvr.NsID))
 	attrs.ParentIndex = vlan.Attrs().Index
-	macvlan := ip.Macvlan{
-		LinkAttrs: attrs,
-		Mode:      ip.MACVLAN_MODE_BRIDGE,
+	attrs.MTU = vlan.Attrs().MTU
+	attrs.Alias = attachmentAlias()
+	macvlan, err := newAttachmentLink(attrs, envs.attachment)
+	if err != nil {
+		writeError("Couldn't create attachment link", err)
+		return
 	}
-	logger.Info("Looking for link by name", "name", attrs.Name)
-	link, err := ip.LinkByName(attrs.Name)
-	if err == nil {
-		logger.Info("Link found, deleting", "name", attrs.Name)
-		err = ip.LinkDel(link)
-		if err != nil {
-			writeError("Found existing link but error deleting", &errs.UnrecoverableError{Context: "Cleaning up (deleting link)", Err: err})
+	if link, err := ip.LinkByName(attrs.Name); err == nil {
+		logger.Info("Leftover link found, deleting", "name", attrs.Name)
+		if err = ip.LinkDel(link); err != nil {
+			writeError("Found leftover link but error deleting", &errs.UnrecoverableError{Context: "Cleaning up (deleting link)", Err: err})
 			return
 		}
-		logger.Info("Link deleted successfully", "name", attrs.Name)
+	}
+	// an interface with the final name in the pod is from an earlier run of the worker,
+	// links that weren't created by the manager, like the pod's primary interface, are kept
+	for _, name := range []string{attrs.Name, finalName} {
+		if link, err := podHandle.LinkByName(name); err == nil {
+			if link.Attrs().Alias != attrs.Alias {
+				writeError(fmt.Sprintf("Link '%s' in pod netns wasn't created for network %s", name, envs.ownerNetName), errs.ErrUnrecoverable)
+				return
+			}
+			logger.Info("Link found in pod netns, deleting", "name", name, "netns", PID)
+			if err = podHandle.LinkDel(link); err != nil {
+				writeError("Found existing link in pod netns but error deleting", &errs.UnrecoverableError{Context: "Cleaning up (deleting link)", Err: err})
+				return
+			}
+		}
 	}
 
 	logger.Info("Adding new link", "name", attrs.Name)
-	err = ip.LinkAdd(&macvlan)
+	err = ip.LinkAdd(macvlan)
 	if err != nil {
 		writeError(fmt.Sprintf("Couldn't create macvlan interface '%s'", attrs.Name), err)
 		return
 	}
 
-	logger.Info("Setting link state to up", "link", attrs.Name)
-	err = ip.LinkSetUp(&macvlan)
+	logger.Info("Setting netns of link", "link", attrs.Name, "netns", PID)
+	err = ip.LinkSetNsFd(macvlan, int(nsFile.Fd()))
 	if err != nil {
-		logger.Error("Couldn't set macvlan interface up", "msg", err, "link", attrs.Name)
-		if err = ip.LinkDel(&macvlan); err != nil {
-			logger.Error("Couldn't clean up macvlan interface after failure", "name", attrs.Name)
-			writeError(fmt.Sprintf("Couldn't set macvlan interface '%s' up. Cleanup failed.", attrs.Name), err)
-			return
+		if delErr := ip.LinkDel(macvlan); delErr != nil {
+			logger.Error("Couldn't clean up macvlan interface after failure", "name", attrs.Name, "err", delErr)
 		}
-		writeError(fmt.Sprintf("Couldn't set macvlan interface '%s' up. Cleaned up successfully.", attrs.Name), err)
+		writeError(fmt.Sprintf("Error setting netns of link '%s'", attrs.Name), err)
 		return
 	}
+	logger.Info("Set NetNS successfully")
 
-	logger.Info("Looking for nsid", "nsid", mvr.NsID)
-	cmd := exec.Command("bash", "-c", fmt.Sprintf("lsns | grep %d | awk '{print $4}'", mvr.NsID))
-	PIDs, err := cmd.Output()
+	link, err := podHandle.LinkByName(attrs.Name)
 	if err != nil {
-		logger.Error("Failed to find nsid", "nsid", mvr.NsID)
-		exErr, ok := err.(*exec.ExitError)
-		if !ok {
-			writeError("Unknown exit error while getting PID of NsID", err)
-			return
-		}
-		writeError(fmt.Sprintf("Error checking PID of NsID: %s", string(exErr.Stderr)), exErr)
+		writeError(fmt.Sprintf("Couldn't find link '%s' in pod netns", attrs.Name), err)
 		return
 	}
-	var PID string
-	if idx := bytes.IndexByte(PIDs, '\n'); idx >= 0 {
-		PID = string(PIDs[:idx])
-	} else {
-		PID = strings.TrimSpace(string(PIDs))
+	logger.Info("Renaming link in pod netns", "link", attrs.Name, "name", finalName)
+	err = podHandle.LinkSetName(link, finalName)
+	if err == nil {
+		err = podHandle.LinkSetUp(link)
 	}
-	logger.Info("Found PID", "PID", PID)
-
-	logger.Info("Setting netns of link", "link", attrs.Name, "netns", PID)
-	args := []string{"link", "set", attrs.Name, "netns", strings.TrimSpace(string(PID))}
-	cmd = exec.Command("ip", args...)
-	out, err = cmd.Output()
 	if err != nil {
-		exErr, ok := err.(*exec.ExitError)
-		commandSlice := append([]string{"ip"}, args...)
-		logger.Error("Error executing command",
-			"command", strings.Join(commandSlice, " "),
-			"output", out,
-			"err", err,
-			"exErr", exErr,
-		)
-		if !ok {
-			writeError("Unknown exit error while setting ns", err)
-			return
-		}
-		if !strings.Contains(string(exErr.Stderr), "File exists") {
-			writeError(fmt.Sprintf("Error setting ns: %s", string(exErr.Stderr)), exErr)
-			return
+		if delErr := podHandle.LinkDel(link); delErr != nil {
+			logger.Error("Couldn't clean up macvlan interface in pod netns after failure", "name", attrs.Name, "err", delErr)
 		}
+		writeError(fmt.Sprintf("Couldn't rename macvlan interface '%s' to '%s' and set it up", attrs.Name, finalName), err)
+		return
 	}
-	logger.Info("Set NetNS successfully")
+
 	resp := comms.MacvlanResponse{
-		Id: envs.vlanID,
+		Id:   envs.vlanID,
+		Name: finalName,
+		MTU:  attrs.MTU,
 	}
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(200)
@@ -210,7 +230,13 @@ func addIPAddress(ctx context.Context) {
 		os.Exit(1)
 	}
 	for _, ipnet := range gatewayIPNets {
-		err = ip.AddrAdd(link, &ip.Addr{IPNet: &ipnet})
+		addr := ip.Addr{IPNet: &ipnet}
+		if ipnet.IP.To4() == nil {
+			// the address moves between nodes on leader change,
+			// don't wait for duplicate address detection to finish
+			addr.Flags = unix.IFA_F_NODAD
+		}
+		err = ip.AddrAdd(link, &addr)
 		if err != nil && !isFileExistsErr(err) {
 			logger.Error("Failed to add IP address to VLAN when becoming leader", "msg", &errs.UnrecoverableError{
 				Context: "Error adding ip address to vlan on becoming leader in callback",
@@ -220,6 +246,7 @@ func addIPAddress(ctx context.Context) {
 		}
 	}
 	setupRoutes()
+	events.Network(ctx, corev1.EventTypeNormal, vlanmanv1.EventReasonLeaderElected, "Manager on node %s holds the gateway addresses", envs.nodeName)
 }
 func removeIPAddress() {
 	link, err := ip.LinkByName("macvlangw" + strconv.FormatInt(int64(envs.vlanID), 10))
@@ -248,11 +275,14 @@ func logNewLeader(identity string) {
 }
 
 var (
+	macvlanMu        sync.Mutex
 	vlanWatcher      *VlanWatcher = nil
-	gatewayLink      *ip.Macvlan
+	gatewayLink      ip.Link
 	vlanID           int
 	gatewayIPNets    []net.IPNet
 	remoteRoutes     string
+	events           *EventRecorder
+	handingOver      atomic.Bool
 	leaderChanges    atomic.Int64
 	lastLeaderChange atomic.Value
 	localRoutes      string
@@ -268,31 +298,31 @@ func setupRoutes() error {
 
 	for _, gw := range envs.Gateways {
 		for _, r := range gw.Routes {
-			_, ipnet, err := net.ParseCIDR(r.Destination)
+			ipnet, err := u.ParseNetwork(r.Destination)
 			if err != nil {
 				return err
 			}
 
-			route := ip.Route{}
+			route := ip.Route{
+				LinkIndex: link.Attrs().Index,
+				Dst:       ipnet,
+			}
 			if r.Via != nil {
-				_, gwIpnet, err := net.ParseCIDR(*r.Via)
+				gwIP := net.ParseIP(*r.Via)
+				if gwIP == nil {
+					return fmt.Errorf("Invalid next hop address '%s' of route to %s", *r.Via, r.Destination)
+				}
+				route.Gw = gwIP
+			}
+			if r.Source == "self" {
+				src, err := u.ParseAddress(gw.Address)
 				if err != nil {
 					return err
 				}
-
-				route = ip.Route{
-					LinkIndex: link.Attrs().Index,
-					Dst:       ipnet,
-					Gw:        gwIpnet.IP,
-				}
-			} else {
-				route = ip.Route{
-					LinkIndex: link.Attrs().Index,
-					Dst:       ipnet,
+				if (src.IP.To4() == nil) != (ipnet.IP.To4() == nil) {
+					return fmt.Errorf("Gateway address %s and route destination %s are of different address families", gw.Address, r.Destination)
 				}
-			}
-			if r.Source == "self" {
-				route.Src = net.ParseIP(gw.Address)
+				route.Src = src.IP
 			}
 			err = ip.RouteAdd(&route)
 			if err != nil {
@@ -312,27 +342,28 @@ func setupRoutes() error {
 
 // }
 
-func begin() context.Context {
+// begin runs onTerminate when the pod is terminated, before exiting
+func begin(onTerminate func()) {
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
 
-	//TODO add kv's to ctx
-	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
 		sig := <-ch
 		logger.Info("Received termination signal", "signal", sig)
-		cancel()
+		onTerminate()
 		os.Exit(1)
 	}()
-	return ctx
 }
 
 type Envs struct {
-	ownerNetName string
-	namespace    string
-	vlanID       int
-	lockName     string
-	Gateways     []vlanmanv1.Gateway
+	ownerNetName  string
+	namespace     string
+	vlanID        int
+	serviceVlanID int
+	lockName      string
+	nodeName      string
+	attachment    vlanmanv1.AttachmentMode
+	Gateways      []vlanmanv1.Gateway
 }
 
 func getEnvs() Envs {
@@ -363,6 +394,7 @@ func getEnvs() Envs {
 	}
 	vlanID = int(vlanID64)
 
+	serviceVlanID, _ := strconv.Atoi(os.Getenv("SERVICE_VLAN_ID"))
 	namespace := os.Getenv("NAMESPACE")
 	lockName := os.Getenv("LOCK_NAME")
 	gatewaysJSON := os.Getenv("GATEWAYS")
@@ -381,8 +413,12 @@ func getEnvs() Envs {
 		ownerNetName: ownerNetName,
 		namespace:    namespace,
 		lockName:     lockName,
+		nodeName:     os.Getenv("NODE_NAME"),
 		Gateways:     gateways,
 		vlanID:       vlanID,
+		attachment:   vlanmanv1.AttachmentMode(os.Getenv("ATTACHMENT")).OrDefault(),
+		// 0 for networks without a service tag
+		serviceVlanID: serviceVlanID,
 	}
 }
 
@@ -403,12 +439,16 @@ func interfaceSetup(ctx context.Context, e Envs, k8sclient client.Client) {
 	if err != nil {
 		panic(fmt.Sprintf("Couldn't get hostname: %s", err))
 	}
-	downgrade := func() {
-		downgradeStatus(k8sclient, ctx, logger, envs.ownerNetName, hostname)
+	report := func(state vlanmanv1.ConnectionState, linkIndex int, reason string) {
+		if handingOver.Load() {
+			// the interface was moved to the host netns on purpose
+			return
+		}
+		reportStatus(k8sclient, ctx, logger, envs.ownerNetName, hostname, e.nodeName, state, linkIndex, reason)
 	}
 
 	go func() {
-		err = vlanWatcher.Watch(downgrade, logger)
+		err = vlanWatcher.Watch(report, logger)
 		if err != nil {
 			logger.Error("Error creating vlan watcher", "msg", &errs.UnrecoverableError{Context: "Couldn't create a vlan watcher", Err: err})
 			os.Exit(1)
@@ -431,45 +471,57 @@ func interfaceSetup(ctx context.Context, e Envs, k8sclient client.Client) {
 
 	gatewayIPNets = []net.IPNet{}
 	for _, gw := range e.Gateways {
-		gwAddr, gwSubnetStr, found := strings.Cut(gw.Address, "/")
-		if !found {
-			gwSubnetStr = "32"
+		gwIPNet, err := u.ParseAddress(gw.Address)
+		if err != nil {
+			logger.Error("Couldn't parse gateway address", "address", gw.Address, "msg", err)
+			os.Exit(1)
 		}
-		gwSubnet, _ := strconv.ParseInt(gwSubnetStr, 10, 64)
-		gatewayIPNets = append(gatewayIPNets, net.IPNet{
-			IP:   net.ParseIP(gwAddr),
-			Mask: net.CIDRMask(int(gwSubnet), 32),
-		})
+		gatewayIPNets = append(gatewayIPNets, *gwIPNet)
 	}
 
 	attrs := ip.NewLinkAttrs()
 	attrs.Name = "macvlangw" + strconv.FormatInt(int64(e.vlanID), 10)
 	attrs.ParentIndex = vlanWatcher.Link.Attrs().Index
-	macvlan := ip.Macvlan{
-		LinkAttrs: attrs,
-		Mode:      ip.MACVLAN_MODE_BRIDGE,
-	}
-	err = ip.LinkAdd(&macvlan)
+	attrs.MTU = vlanWatcher.Link.Attrs().MTU
+	macvlan, err := newAttachmentLink(attrs, e.attachment)
 	if err != nil {
-		alreadyExists, err := ip.LinkByName(attrs.Name)
+		logger.Error("Couldn't create gateway link", "msg", err)
+		os.Exit(1)
+	}
+	existing, err := ip.LinkByName(attrs.Name)
+	if err == nil && !sameAttachment(existing, macvlan) {
+		// the attachment mode of the network changed
+		logger.Info("Existing gateway link is of a different type or mode, recreating", "link", attrs.Name, "type", existing.Type())
+		err = ip.LinkDel(existing)
 		if err != nil {
-			logger.Error("Failed to create macvlan and there is no existing link with that name", "msg", err)
+			logger.Error("Failed to delete gateway link of wrong type", "msg", err)
 			os.Exit(1)
 		}
-		mvlan, ok := alreadyExists.(*ip.Macvlan)
-		if !ok {
-			logger.Error("Existing macvlan is of wrong type", "macvlan", mvlan)
+		existing = nil
+	}
+	if existing == nil {
+		err = ip.LinkAdd(macvlan)
+		if err != nil {
+			logger.Error("Failed to create gateway link", "msg", err)
 			os.Exit(1)
 		}
-
-		macvlan = *mvlan
+	} else {
+		macvlan = existing
+		if existing.Attrs().MTU != attrs.MTU {
+			logger.Info("Setting MTU of existing gateway link", "link", attrs.Name, "mtu", attrs.MTU)
+			err = ip.LinkSetMTU(existing, attrs.MTU)
+			if err != nil {
+				logger.Error("Failed to set MTU of gateway link", "msg", err)
+				os.Exit(1)
+			}
+		}
 	}
-	err = ip.LinkSetUp(&macvlan)
+	err = ip.LinkSetUp(macvlan)
 	if err != nil {
 		logger.Error("Failed to set macvlan link up", "msg", err)
 		os.Exit(1)
 	}
-	gatewayLink = &macvlan
+	gatewayLink = macvlan
 
 	cfg := ctrl.GetConfigOrDie()
 	l, err := rl.NewFromKubeconfig(
@@ -524,6 +576,7 @@ func createK8sClient() (k8sclient client.Client, ctx context.Context, ctxCancel
 	config, err := rest.InClusterConfig()
 	scheme := runtime.NewScheme()
 	_ = vlanmanv1.AddToScheme(scheme)
+	_ = corev1.AddToScheme(scheme)
 
 	if err != nil {
 		err = fmt.Errorf("Error creating config for k8s client: %s", err)
@@ -539,28 +592,43 @@ func createK8sClient() (k8sclient client.Client, ctx context.Context, ctxCancel
 	return
 }
 
-func downgradeStatus(k8sclient client.Client, ctx context.Context, logger slog.Logger, ownerName, hostname string) {
-	connection := vlanmanv1.VlanNetwork{}
-	err := k8sclient.Get(ctx, types.NamespacedName{Name: ownerName, Namespace: ""}, &connection)
+// reportStatus records the state of the vlan interface of the node in the status of the network.
+// Only going down is recorded in the per pod state, the controller sets it up after creating the interface.
+func reportStatus(k8sclient client.Client, ctx context.Context, logger slog.Logger, ownerName, hostname, nodeName string, state vlanmanv1.ConnectionState, linkIndex int, reason string) {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		connection := vlanmanv1.VlanNetwork{}
+		err := k8sclient.Get(ctx, types.NamespacedName{Name: ownerName, Namespace: ""}, &connection)
+		if err != nil {
+			return err
+		}
+
+		if state == vlanmanv1.StateDown {
+			if connection.Status.State == nil {
+				connection.Status.State = make(map[string]vlanmanv1.ConnectionState)
+			}
+			connection.Status.State[hostname] = vlanmanv1.StateDown
+			connection.Status.UpdateShortState()
+		}
+		if nodeName != "" {
+			connection.Status.SetNodeState(nodeName, hostname, state, linkIndex, reason)
+		}
+		return k8sclient.Status().Update(ctx, &connection)
+	})
 	if err != nil {
-		logger.Error("Unable to get vlan connection CR for status update", "err", err, "name", ownerName)
-		return
+		logger.Error("Failed to update vlan network status", "err", err, "name", ownerName)
 	}
 
-	if connection.Status.State == nil {
-		connection.Status.State = make(map[string]vlanmanv1.ConnectionState)
-	}
-	connection.Status.State[hostname] = vlanmanv1.StateDown
-	connection.Status.UpdateShortState()
-	err = k8sclient.Status().Update(ctx, &connection)
-	if err != nil {
-		logger.Error("Failed to update vlan connections tatus", "err", err)
+	if state == vlanmanv1.StateDown {
+		events.Network(ctx, corev1.EventTypeWarning, vlanmanv1.EventReasonLinkDown, "Vlan interface is down in manager pod %s on node %s: %s", hostname, nodeName, reason)
+		events.Pods(ctx, corev1.EventTypeWarning, vlanmanv1.EventReasonLinkDown, "Vlan interface of network %s is down on node %s: %s", ownerName, nodeName, reason)
+		return
 	}
+	events.Network(ctx, corev1.EventTypeNormal, vlanmanv1.EventReasonLinkUp, "Vlan interface is up in manager pod %s on node %s", hostname, nodeName)
+	events.Pods(ctx, corev1.EventTypeNormal, vlanmanv1.EventReasonLinkUp, "Vlan interface of network %s is up on node %s", ownerName, nodeName)
 }
 
 func main() {
 	logger = *slog.New(slog.NewJSONHandler(os.Stdout, nil))
-	ctx := begin()
 
 	k8sclient, ctx, _, err := createK8sClient()
 	if err != nil {
@@ -570,12 +638,24 @@ func main() {
 	envs = getEnvs()
 	vlanWatcher = NewWatcher(envs.vlanID)
 
+	hostname, err := os.Hostname()
+	if err != nil {
+		panic(fmt.Sprintf("Couldn't get hostname: %s", err))
+	}
+	begin(func() { handOver(k8sclient, hostname) })
+	events, err = NewEventRecorder(ctrl.GetConfigOrDie(), k8sclient, envs, hostname)
+	if err != nil {
+		// events are informational, the manager works without them
+		logger.Error("Couldn't create event recorder", "err", err)
+	}
+
 	go interfaceSetup(ctx, envs, k8sclient)
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/pid", pid)
 	mux.HandleFunc("/ready", ready)
 	mux.HandleFunc("/macvlan", macvlan)
+	mux.HandleFunc("/conflict", conflict(k8sclient))
 
 	// TODO: enable and disable from helm values
 	isMonitoringOn := true
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	u "dialo.ai/vlanman/pkg/utils"
+)
+
+// openNetNs finds a process in the network namespace with the given inode
+// and opens the namespace, so links can be moved into it with LinkSetNsFd.
+// The manager runs in the host PID namespace, so every process is visible in /proc.
+func openNetNs(inode uint64) (*os.File, int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, 0, fmt.Errorf("Couldn't read /proc: %w", err)
+	}
+	for _, ent := range entries {
+		pid, err := strconv.Atoi(ent.Name())
+		if err != nil || !ent.IsDir() {
+			continue
+		}
+		// processes come and go, errors only mean this one is gone
+		ino, err := u.NetNsInode(ent.Name())
+		if err != nil || ino != inode {
+			continue
+		}
+		f, err := os.Open(filepath.Join("/proc", ent.Name(), "ns", "net"))
+		if err != nil {
+			continue
+		}
+		return f, pid, nil
+	}
+	return nil, 0, fmt.Errorf("No process found in network namespace %d", inode)
+}
@@ -1,13 +1,16 @@
 package main
 
 import (
+	"fmt"
 	"log/slog"
-	"os"
+	"net"
 	"strconv"
 	"sync/atomic"
 	"time"
 
+	vlanmanv1 "dialo.ai/vlanman/api/v1"
 	ip "github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
 type VlanWatcher struct {
@@ -17,48 +20,108 @@ type VlanWatcher struct {
 	Link   ip.Link
 }
 
-// Can't use something like fsnotify here
-// because kernel doesn't send notifications
-// for virtual filesystems.
+// The kernel doesn't send inotify events for /sys/class/net,
+// so link changes are received from a netlink subscription.
 func NewWatcher(id int) *VlanWatcher {
 	return &VlanWatcher{
 		ID: id,
 	}
 }
 
-func (v *VlanWatcher) Watch(downgrade func(), logger slog.Logger) error {
-	ifaceName := "vlan" + strconv.FormatInt(int64(v.ID), 10)
+func (v *VlanWatcher) ifaceName() string {
+	return "vlan" + strconv.FormatInt(int64(v.ID), 10)
+}
+
+// reportFunc records the state of the vlan interface in the status of the network,
+// reason is set when the interface went down
+type reportFunc func(state vlanmanv1.ConnectionState, linkIndex int, reason string)
+
+// Watch follows link updates of the vlan interface. The interface is reported down when it's
+// removed or loses carrier, so the controller recreates it, and up when it gets carrier.
+// An interface that was set down is set up again. Returns an error only if the first
+// subscription fails, later failures are retried.
+func (v *VlanWatcher) Watch(report reportFunc, logger slog.Logger) error {
+	updates, done, err := v.subscribe(logger)
+	if err != nil {
+		return err
+	}
+
+	// links that exist are listed by the subscription, a missing
+	// interface has to be checked separately
+	if _, err := ip.LinkByName(v.ifaceName()); err != nil {
+		logger.Info("Interface doesn't exist, downgrading", "interface", v.ifaceName(), "err", err)
+		report(vlanmanv1.StateDown, 0, "Interface doesn't exist")
+	}
+
 	for {
-		time.Sleep(time.Second / 2)
-		ents, err := os.ReadDir("/sys/class/net")
-		if err != nil {
-			logger.Info("Reading dir failed", "err", err)
-			return err
+		for update := range updates {
+			v.handleUpdate(update, report, logger)
 		}
-		exists := false
-		for _, ent := range ents {
-			if ent.Name() == ifaceName {
-				exists = true
-				v.Exists.Store(true)
-				link, err := ip.LinkByName(ifaceName)
-				if err != nil {
-					logger.Info("couldn't get link by name", "err", err)
-					break
-				}
-				err = ip.LinkSetUp(link)
-				if err != nil {
-					logger.Info("Couldnt set link up", "err", err)
-					break
-				}
-				v.UP.Store(true)
-				v.Link = link
+		close(done)
+
+		logger.Info("Link subscription closed, subscribing again")
+		for {
+			time.Sleep(time.Second)
+			updates, done, err = v.subscribe(logger)
+			if err == nil {
+				break
 			}
+			logger.Error("Couldn't subscribe to link updates", "err", err)
 		}
-		if !exists {
-			logger.Info("Interface doesn't exist, updating and downgrading")
-			downgrade()
-			v.Exists.Store(false)
-			v.UP.Store(false)
+	}
+}
+
+func (v *VlanWatcher) subscribe(logger slog.Logger) (chan ip.LinkUpdate, chan struct{}, error) {
+	updates := make(chan ip.LinkUpdate)
+	done := make(chan struct{})
+	err := ip.LinkSubscribeWithOptions(updates, done, ip.LinkSubscribeOptions{
+		ListExisting: true,
+		ErrorCallback: func(err error) {
+			logger.Error("Error receiving link updates", "err", err)
+		},
+	})
+	if err != nil {
+		close(done)
+		return nil, nil, fmt.Errorf("Couldn't subscribe to link updates: %w", err)
+	}
+	return updates, done, nil
+}
+
+func (v *VlanWatcher) handleUpdate(update ip.LinkUpdate, report reportFunc, logger slog.Logger) {
+	attrs := update.Attrs()
+	if attrs == nil || attrs.Name != v.ifaceName() {
+		return
+	}
+
+	if update.Header.Type == unix.RTM_DELLINK {
+		logger.Info("Interface was removed, downgrading", "interface", attrs.Name)
+		v.Exists.Store(false)
+		v.UP.Store(false)
+		report(vlanmanv1.StateDown, 0, "Interface was removed")
+		return
+	}
+
+	v.Link = update.Link
+	v.Exists.Store(true)
+
+	if attrs.Flags&net.FlagUp == 0 {
+		logger.Info("Interface is down, setting it up", "interface", attrs.Name)
+		v.UP.Store(false)
+		err := ip.LinkSetUp(update.Link)
+		if err != nil {
+			logger.Info("Couldn't set link up", "err", err)
 		}
+		return
+	}
+
+	hasCarrier := attrs.RawFlags&unix.IFF_LOWER_UP != 0
+	wasUp := v.UP.Swap(hasCarrier)
+	if wasUp && !hasCarrier {
+		logger.Info("Interface lost carrier, downgrading", "interface", attrs.Name, "operState", attrs.OperState.String())
+		report(vlanmanv1.StateDown, attrs.Index, "Interface lost carrier, operstate "+attrs.OperState.String())
+	}
+	if !wasUp && hasCarrier {
+		logger.Info("Interface is up", "interface", attrs.Name)
+		report(vlanmanv1.StateUp, attrs.Index, "")
 	}
 }
@@ -13,10 +13,12 @@ import (
 	corewhv1 "dialo.ai/vlanman/internal/webhook/corev1"
 	vlanmanwhv1 "dialo.ai/vlanman/internal/webhook/v1"
 	promv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
@@ -49,6 +51,12 @@ func main() {
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), manager.Options{
 		Scheme:        scheme,
 		WebhookServer: whServer,
+		Client: client.Options{
+			Cache: &client.CacheOptions{
+				// gateway leases are read only during rollouts, caching would watch every lease in the cluster
+				DisableFor: []client.Object{&coordinationv1.Lease{}},
+			},
+		},
 	})
 	if err != nil || mgr == nil {
 		logger.Error(err, "Creating manager failed")
@@ -98,10 +106,11 @@ func main() {
 
 	logger.Info("Creating controller")
 	if err = (&controller.VlanmanReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-		Config: mgr.GetConfig(),
-		Env:    e,
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Config:   mgr.GetConfig(),
+		Recorder: mgr.GetEventRecorderFor("vlanman-controller"),
+		Env:      e,
 	}).SetupWithManager(mgr); err != nil {
 		logger.Error(err, "Creating controller failed")
 		os.Exit(1)
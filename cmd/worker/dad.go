@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	ip "github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Timing of ARP probing from RFC 5227
+const (
+	probeWait    = time.Second
+	probeNum     = 3
+	probeMin     = time.Second
+	probeMax     = 2 * time.Second
+	announceWait = 2 * time.Second
+	// how long the kernel gets to finish IPv6 duplicate address detection
+	dadTimeout = 10 * time.Second
+)
+
+const (
+	arpRequest = 1
+	arpReply   = 2
+)
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// arpPacket builds an ARP packet for Ethernet and IPv4, the link layer header is added by the kernel
+func arpPacket(op uint16, sha net.HardwareAddr, spa net.IP, tha net.HardwareAddr, tpa net.IP) []byte {
+	b := make([]byte, 28)
+	binary.BigEndian.PutUint16(b[0:], 1)
+	binary.BigEndian.PutUint16(b[2:], unix.ETH_P_IP)
+	b[4] = 6
+	b[5] = 4
+	binary.BigEndian.PutUint16(b[6:], op)
+	copy(b[8:14], sha)
+	copy(b[14:18], spa.To4())
+	copy(b[18:24], tha)
+	copy(b[24:28], tpa.To4())
+	return b
+}
+
+// arpConflict checks whether the packet shows another host using the address, either as the sender
+// of a request or reply, or as the sender of a probe for it. Returns the MAC address of the host.
+func arpConflict(packet []byte, own net.HardwareAddr, addr net.IP) (net.HardwareAddr, bool) {
+	if len(packet) < 28 || binary.BigEndian.Uint16(packet[2:]) != unix.ETH_P_IP || packet[4] != 6 || packet[5] != 4 {
+		return nil, false
+	}
+	op := binary.BigEndian.Uint16(packet[6:])
+	if op != arpRequest && op != arpReply {
+		return nil, false
+	}
+	sha := net.HardwareAddr(bytes.Clone(packet[8:14]))
+	spa := net.IP(packet[14:18])
+	tpa := net.IP(packet[24:28])
+	if bytes.Equal(sha, own) {
+		return nil, false
+	}
+	if spa.Equal(addr.To4()) {
+		return sha, true
+	}
+	if op == arpRequest && spa.Equal(net.IPv4zero.To4()) && tpa.Equal(addr.To4()) {
+		return sha, true
+	}
+	return nil, false
+}
+
+// probeIPv4 probes the address with ARP as described in RFC 5227 before it's configured on the link,
+// returns the MAC address of a host using it. Links that don't use ARP aren't probed.
+func probeIPv4(link ip.Link, addr net.IP) (net.HardwareAddr, error) {
+	attrs := link.Attrs()
+	if attrs.RawFlags&unix.IFF_NOARP != 0 {
+		log.Info("Link doesn't use ARP, skipping address probing", "link", attrs.Name)
+		return nil, nil
+	}
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM, int(htons(unix.ETH_P_ARP)))
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't open ARP socket: %w", err)
+	}
+	defer unix.Close(fd)
+	err = unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ARP), Ifindex: attrs.Index})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't bind ARP socket to link %s: %w", attrs.Name, err)
+	}
+	broadcast := &unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ARP),
+		Ifindex:  attrs.Index,
+		Halen:    6,
+		Addr:     [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+	probe := arpPacket(arpRequest, attrs.HardwareAddr, net.IPv4zero, make(net.HardwareAddr, 6), addr)
+
+	// probes are sent after a random delay and at random intervals, so hosts
+	// probing at the same time don't keep colliding
+	start := time.Now()
+	probes := []time.Time{start.Add(rand.N(probeWait))}
+	for len(probes) < probeNum {
+		probes = append(probes, probes[len(probes)-1].Add(probeMin+rand.N(probeMax-probeMin)))
+	}
+	end := probes[len(probes)-1].Add(announceWait)
+
+	buf := make([]byte, 1500)
+	for sent := 0; ; {
+		now := time.Now()
+		if sent < len(probes) && !now.Before(probes[sent]) {
+			err = unix.Sendto(fd, probe, 0, broadcast)
+			if err != nil {
+				return nil, fmt.Errorf("Couldn't send ARP probe: %w", err)
+			}
+			sent++
+			continue
+		}
+		if !now.Before(end) {
+			return nil, nil
+		}
+		next := end
+		if sent < len(probes) {
+			next = probes[sent]
+		}
+		tv := unix.NsecToTimeval(max(next.Sub(now), time.Millisecond).Nanoseconds())
+		err = unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't set ARP socket timeout: %w", err)
+		}
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't receive ARP packets: %w", err)
+		}
+		if mac, ok := arpConflict(buf[:n], attrs.HardwareAddr, addr); ok {
+			return mac, nil
+		}
+	}
+}
+
+// announceIPv4 announces the configured address with a gratuitous ARP request,
+// so hosts that cached a previous holder of the address update it
+func announceIPv4(link ip.Link, addr net.IP) error {
+	attrs := link.Attrs()
+	if attrs.RawFlags&unix.IFF_NOARP != 0 {
+		return nil
+	}
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM, int(htons(unix.ETH_P_ARP)))
+	if err != nil {
+		return fmt.Errorf("Couldn't open ARP socket: %w", err)
+	}
+	defer unix.Close(fd)
+	announcement := arpPacket(arpRequest, attrs.HardwareAddr, addr, make(net.HardwareAddr, 6), addr)
+	return unix.Sendto(fd, announcement, 0, &unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ARP),
+		Ifindex:  attrs.Index,
+		Halen:    6,
+		Addr:     [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	})
+}
+
+// waitForDAD waits until the kernel finished duplicate address detection of the IPv6 address,
+// returns true if another host uses it. Addresses of links with DAD disabled are never tentative.
+func waitForDAD(link ip.Link, addr *net.IPNet) (bool, error) {
+	deadline := time.Now().Add(dadTimeout)
+	for {
+		addrs, err := ip.AddrList(link, ip.FAMILY_V6)
+		if err != nil {
+			return false, fmt.Errorf("Couldn't list addresses of link %s: %w", link.Attrs().Name, err)
+		}
+		found := false
+		for _, a := range addrs {
+			if !a.IP.Equal(addr.IP) {
+				continue
+			}
+			found = true
+			if a.Flags&unix.IFA_F_DADFAILED != 0 {
+				return true, nil
+			}
+			if a.Flags&unix.IFA_F_TENTATIVE == 0 {
+				return false, nil
+			}
+		}
+		if !found {
+			return false, fmt.Errorf("Address %s disappeared from link %s during DAD", addr.IP, link.Attrs().Name)
+		}
+		if time.Now().After(deadline) {
+			return false, fmt.Errorf("Duplicate address detection of %s didn't finish in %s", addr.IP, dadTimeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -8,13 +8,12 @@ import (
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
-	"strconv"
 	"strings"
 
 	vlanmanv1 "dialo.ai/vlanman/api/v1"
 	"dialo.ai/vlanman/pkg/comms"
 	errs "dialo.ai/vlanman/pkg/errors"
+	u "dialo.ai/vlanman/pkg/utils"
 	"github.com/go-logr/logr"
 	ip "github.com/vishvananda/netlink"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
@@ -31,32 +30,55 @@ func isAlreadyExists(e error) bool {
 	return strings.Contains(e.Error(), "file exists")
 }
 
+// addressConflict reports the address to the manager, which quarantines it in the status of the network,
+// and fails the worker. The MAC address of the host using it isn't known for IPv6. The address stays in the
+// environment of the init container, so it fails again on every restart until the pod is deleted.
+func addressConflict(managerURL string, addr net.IP, mac net.HardwareAddr) {
+	conflictErr := &errs.AddressConflictError{Address: addr.String()}
+	if mac != nil {
+		conflictErr.MAC = mac.String()
+	}
+	payload, err := json.Marshal(comms.AddressConflictRequest{
+		Address:   conflictErr.Address,
+		MAC:       conflictErr.MAC,
+		Pod:       os.Getenv("POD_NAME"),
+		Namespace: os.Getenv("POD_NAMESPACE"),
+	})
+	if err == nil {
+		var resp *http.Response
+		resp, err = http.Post(managerURL+"/conflict", "application/json", bytes.NewReader(payload))
+		if err == nil {
+			// closed right away, deferred calls don't run when the worker exits with fatal
+			resp.Body.Close()
+			if resp.StatusCode != 200 {
+				err = fmt.Errorf("Status code of response is %d, check logs of manager pod on the same node", resp.StatusCode)
+			}
+		}
+	}
+	if err != nil {
+		log.Error(err, "Couldn't report address conflict to manager", "address", conflictErr.Address)
+	}
+	// shown by kubectl describe
+	if err = os.WriteFile("/dev/termination-log", []byte(conflictErr.Error()), 0o644); err != nil {
+		log.Error(err, "Couldn't write termination message")
+	}
+	fatal(conflictErr)
+}
+
 func main() {
 	networkName := os.Getenv("VLAN_NETWORK")
-	url := fmt.Sprintf("http://%s-service.vlanman-system:61410/macvlan", networkName)
+	managerURL := fmt.Sprintf("http://%s-service.vlanman-system:61410", networkName)
 
-	cmd := exec.Command("bash", "-c", "readlink /proc/$$/ns/net | grep -o '[0-9]\\+'")
-	nsidStr, err := cmd.Output()
+	nsid, err := u.NetNsInode("self")
 	if err != nil {
-		exErr, ok := err.(*exec.ExitError)
-		if !ok {
-			fatal(&errs.UnrecoverableError{
-				Context: "Couldn't extract nsid, couldn't determine type of error",
-				Err:     err,
-			})
-		}
 		fatal(&errs.UnrecoverableError{
 			Context: "Couldn't extract nsid",
-			Err:     exErr,
+			Err:     err,
 		})
-
-	}
-	nsid, err := strconv.ParseInt(strings.TrimSpace(string(nsidStr)), 10, 64)
-	if err != nil {
-		fatal(errs.NewParsingError("nsid", err))
 	}
 	data := comms.MacvlanRequest{
-		NsID: nsid,
+		NsID: int64(nsid),
+		Name: os.Getenv("INTERFACE_NAME"),
 	}
 	payload, err := json.Marshal(data)
 	if err != nil {
@@ -65,7 +87,7 @@ func main() {
 			Err:     errs.NewParsingError("marshaling macvlan request", err),
 		})
 	}
-	resp, err := http.Post(url, "application/json", bytes.NewReader(payload))
+	resp, err := http.Post(managerURL+"/macvlan", "application/json", bytes.NewReader(payload))
 	if err != nil {
 		fatal(&errs.RequestError{
 			Action: fmt.Sprintf("Request macvlan from manager (%s), with PID %d", networkName, os.Getpid()),
@@ -102,7 +124,11 @@ func main() {
 		})
 	}
 
-	linkName := "macvlan" + strconv.FormatInt(int64(mvrd.Id), 10)
+	linkName := mvrd.Name
+	if linkName == "" {
+		// managers that don't rename the interface
+		linkName = u.DefaultInterfaceName(mvrd.Id)
+	}
 	link, err := ip.LinkByName(linkName)
 	if err != nil {
 		fatal(&errs.UnrecoverableError{
@@ -110,6 +136,15 @@ func main() {
 			Err:     err,
 		})
 	}
+	if mvrd.MTU != 0 && link.Attrs().MTU != mvrd.MTU {
+		err = ip.LinkSetMTU(link, mvrd.MTU)
+		if err != nil {
+			fatal(&errs.UnrecoverableError{
+				Context: fmt.Sprintf("Couldn't set MTU of link '%s' to %d", linkName, mvrd.MTU),
+				Err:     err,
+			})
+		}
+	}
 	err = ip.LinkSetUp(link)
 	if err != nil {
 		fatal(&errs.UnrecoverableError{
@@ -118,21 +153,66 @@ func main() {
 		})
 	}
 
-	sn := os.Getenv("MACVLAN_SUBNET")
-	snInt, err := strconv.ParseInt(sn, 10, 64)
-	if err != nil {
-		snInt = 32
-	}
-	ipnet := net.IPNet{
-		IP:   net.ParseIP(os.Getenv("MACVLAN_IP")),
-		Mask: net.CIDRMask(int(snInt), 32),
+	addrs := []*net.IPNet{}
+	for _, env := range [][2]string{{"MACVLAN_IP", "MACVLAN_SUBNET"}, {"MACVLAN_IP6", "MACVLAN_SUBNET6"}} {
+		address := os.Getenv(env[0])
+		if address == "" {
+			continue
+		}
+		if sn := os.Getenv(env[1]); sn != "" {
+			address += "/" + sn
+		}
+		ipnet, err := u.ParseAddress(address)
+		if err != nil {
+			fatal(errs.NewParsingError(env[0], err))
+		}
+		if ipnet.IP.To4() != nil {
+			mac, err := probeIPv4(link, ipnet.IP)
+			if err != nil {
+				fatal(&errs.UnrecoverableError{
+					Context: fmt.Sprintf("Failed to probe IP address %s with ARP", ipnet.IP),
+					Err:     err,
+				})
+			}
+			if mac != nil {
+				addressConflict(managerURL, ipnet.IP, mac)
+			}
+		}
+		addr := ip.Addr{IPNet: ipnet}
+		err = ip.AddrAdd(link, &addr)
+		if err != nil && !isAlreadyExists(err) {
+			fatal(&errs.UnrecoverableError{
+				Context: fmt.Sprintf("Failed to add IP address %s to macvlan", ipnet),
+				Err:     err,
+			})
+		}
+		if ipnet.IP.To4() != nil {
+			err = announceIPv4(link, ipnet.IP)
+			if err != nil {
+				log.Error(err, "Couldn't announce address", "address", ipnet.IP.String())
+			}
+		} else {
+			// routes using the address as a source can't be added while it's tentative
+			duplicate, err := waitForDAD(link, ipnet)
+			if err != nil {
+				fatal(&errs.UnrecoverableError{
+					Context: fmt.Sprintf("Failed duplicate address detection of %s", ipnet.IP),
+					Err:     err,
+				})
+			}
+			if duplicate {
+				if err = ip.AddrDel(link, &addr); err != nil {
+					log.Error(err, "Couldn't remove duplicate address", "address", ipnet.IP.String())
+				}
+				addressConflict(managerURL, ipnet.IP, nil)
+			}
+		}
+		addrs = append(addrs, ipnet)
 	}
-	addr := ip.Addr{IPNet: &ipnet}
-	err = ip.AddrAdd(link, &addr)
-	if err != nil && !isAlreadyExists(err) {
+	if len(addrs) == 0 {
 		fatal(&errs.UnrecoverableError{
-			Context: "Failed to add IP address to macvlan",
-			Err:     err,
+			Context: "MACVLAN_IP env var is not set",
+			Err:     errs.ErrNilUnrecoverable,
 		})
 	}
 	routesJSON := os.Getenv("ROUTES")
@@ -147,12 +227,7 @@ func main() {
 	}
 
 	for _, r := range routes {
-		addr, maskStr, found := strings.Cut(r.Destination, "/")
-		if !found {
-			maskStr = "32"
-		}
-		mask, _ := strconv.ParseInt(maskStr, 10, 64)
-
+		dst, err := u.ParseNetwork(r.Destination)
 		if err != nil {
 			fatal(&errs.ParsingError{
 				Source: "CIDR of route",
@@ -162,10 +237,7 @@ func main() {
 
 		route := ip.Route{
 			LinkIndex: link.Attrs().Index,
-			Dst: &net.IPNet{
-				IP:   net.ParseIP(addr),
-				Mask: net.CIDRMask(int(mask), 32),
-			},
+			Dst:       dst,
 		}
 		if r.Via != nil {
 			route.Gw = net.ParseIP(*r.Via)
@@ -174,7 +246,15 @@ func main() {
 			route.Scope = ip.SCOPE_LINK
 		}
 		if r.Source == "self" {
-			route.Src = ipnet.IP
+			for _, a := range addrs {
+				if (a.IP.To4() == nil) == (dst.IP.To4() == nil) {
+					route.Src = a.IP
+					break
+				}
+			}
+			if route.Src == nil {
+				log.Info("No address of the same family as route destination, skipping source", "dest", r.Destination)
+			}
 		}
 
 		err = ip.RouteAdd(&route)
@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"reflect"
 	"time"
 
 	vlanmanv1 "dialo.ai/vlanman/api/v1"
@@ -18,70 +17,53 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
-type DaemonSetTimeoutError struct {
-	Name           string
-	Ready          int
-	Total          int
-	CleanupSuccess bool
-	CleanupErr     error
+type DaemonPodTimeoutError struct {
+	Name    string
+	Timeout time.Duration
 }
 
 var ErrDaemonPodTimeout = errors.New("Daemon pod timeout error")
 
-func (e *DaemonSetTimeoutError) Error() string {
-	var cleanupMsg string
-	if e.CleanupSuccess {
-		cleanupMsg = "Cleaned up successfully."
-	} else {
-		cleanupMsg = fmt.Sprintf("Cleanup failed: %s", e.CleanupErr.Error())
-	}
-	return fmt.Sprintf("Timeout waiting for '%s' daemonset to become available after %d tries (%d Ready, %d Total). %s",
-		e.Name,
-		vlanmanv1.WaitForDaemonTimeout,
-		e.Ready,
-		e.Total,
-		cleanupMsg,
-	)
+func (e *DaemonPodTimeoutError) Error() string {
+	return fmt.Sprintf("Daemon '%s' didn't get assigned IP within %s", e.Name, e.Timeout)
 }
 
-func (e *DaemonSetTimeoutError) Unwrap() error {
-	return ErrDaemonSetTimeout
+func (e *DaemonPodTimeoutError) Unwrap() error {
+	return ErrDaemonPodTimeout
 }
 
-type DaemonPodTimeoutError struct {
-	Name           string
-	CleanupSuccess bool
-	CleanupErr     error
+type InterfaceJobFailedError struct {
+	Job string
+	Pod string
 }
 
-var ErrDaemonSetTimeout = errors.New("Daemonset timeout error")
+var ErrInterfaceJobFailed = errors.New("Interface job failed")
 
-func (e *DaemonPodTimeoutError) Error() string {
-	var cleanupMsg string
-	if e.CleanupSuccess {
-		cleanupMsg = "Cleaned up successfully."
-	} else {
-		cleanupMsg = fmt.Sprintf("Cleanup failed: %s", e.CleanupErr.Error())
-	}
-	return fmt.Sprintf("Timeout waiting for daemon '%s' to get assigned IP after %d tries. %s",
-		e.Name,
-		vlanmanv1.WaitForDaemonTimeout,
-		cleanupMsg,
-	)
+func (e *InterfaceJobFailedError) Error() string {
+	return fmt.Sprintf("Job '%s' creating the vlan interface of '%s' failed, it will be created again", e.Job, e.Pod)
 }
 
-func (e *DaemonPodTimeoutError) Unwrap() error {
-	return ErrDaemonPodTimeout
+func (e *InterfaceJobFailedError) Unwrap() error {
+	return ErrInterfaceJobFailed
 }
 
+// Action is a step towards the desired state. Actions don't wait for the cluster to catch up,
+// an action that's waiting returns the duration after which the network should be reconciled again.
+// Changes to DaemonSets, Jobs and manager pods trigger reconciliation as well.
 type Action interface {
-	Do(context.Context, *VlanmanReconciler) error
+	Do(context.Context, *VlanmanReconciler) (*time.Duration, error)
+}
+
+func requeue() *time.Duration {
+	d := time.Second * vlanmanv1.ActionRequeueSeconds
+	return &d
 }
 
 type CreateManagerAction struct {
@@ -89,242 +71,34 @@ type CreateManagerAction struct {
 	Manager      ManagerSet
 }
 
-func (a *CreateManagerAction) Do(ctx context.Context, r *VlanmanReconciler) error {
+func (a *CreateManagerAction) Do(ctx context.Context, r *VlanmanReconciler) (*time.Duration, error) {
 	daemonSet, err := daemonSetFromManager(a.Manager, r.Env)
 	if err != nil {
-		return &errs.UnrecoverableError{
+		return nil, &errs.UnrecoverableError{
 			Context: "Error creating a daemonset from manager specification",
 			Err:     err,
 		}
 	}
 	svc := serviceForManagerSet(a.Manager, r.Env.NamespaceName)
-	return a.execute(ctx, r, daemonSet, svc)
+	return nil, a.execute(ctx, r, daemonSet, svc)
 }
 
+// execute only creates the daemonset and the service, vlan interfaces are created
+// by SpawnInterfaceAction for each manager pod once the daemonset schedules it
 func (a *CreateManagerAction) execute(ctx context.Context, r *VlanmanReconciler, daemonSet appsv1.DaemonSet, svc corev1.Service) error {
-	log := log.FromContext(ctx)
-	err := r.Client.Create(ctx, &daemonSet)
+	err := r.setNetworkOwner(ctx, a.Manager.OwnerNetworkName, &daemonSet, &svc)
 	if err != nil {
+		return err
+	}
+	err = r.Client.Create(ctx, &daemonSet)
+	if err != nil && !apierrors.IsAlreadyExists(err) {
 		return &errs.ClientRequestError{
 			Action: "Create daemonset",
 			Err:    err,
 		}
 	}
-	err = r.Client.Get(ctx, types.NamespacedName{Name: daemonSet.Name, Namespace: daemonSet.Namespace}, &daemonSet)
-	timeout := 1
-	for ((err != nil && apierrors.IsNotFound(err)) ||
-		reflect.DeepEqual(daemonSet.Status, appsv1.DaemonSetStatus{})) &&
-		timeout <= vlanmanv1.WaitForDaemonTimeout {
-
-		log.Info("Waiting for daemonset")
-		time.Sleep(time.Second / 2)
-
-		err = r.Client.Get(ctx, types.NamespacedName{Name: daemonSet.Name, Namespace: daemonSet.Namespace}, &daemonSet)
-		timeout += 1
-	}
-
-	if err != nil {
-		return errs.NewClientRequestError("GetDaemonset", err)
-	}
-
-	// not created, don't have to clean up
-	if timeout > vlanmanv1.WaitForDaemonTimeout {
-		return &DaemonSetTimeoutError{
-			Name:           a.Manager.OwnerNetworkName,
-			Ready:          0,
-			Total:          0,
-			CleanupSuccess: true,
-			CleanupErr:     nil,
-		}
-	}
-
-	// use the same timeout counter as above, same thing really same limit should apply
-	// TODO: maybe wait for daemon to not be not found before this loop?
-	for timeout <= vlanmanv1.WaitForDaemonTimeout && daemonSet.Status.NumberUnavailable != 0 {
-		stats := fmt.Sprintf("%d/%d/%d",
-			daemonSet.Status.CurrentNumberScheduled,
-			daemonSet.Status.DesiredNumberScheduled,
-			daemonSet.Status.NumberUnavailable,
-		)
-		tries := fmt.Sprintf("%d/%d",
-			timeout,
-			vlanmanv1.WaitForDaemonTimeout,
-		)
-		err = r.Client.Get(ctx, types.NamespacedName{Name: daemonSet.Name, Namespace: r.Env.NamespaceName}, &daemonSet)
-		if err != nil {
-			if !apierrors.IsNotFound(err) {
-				return &errs.ClientRequestError{
-					Action: "Get Daemonset",
-					Err:    err,
-				}
-			}
-		}
-		log.Info("Waiting for daemons 100% availible", "amount", stats, "tries", tries)
-		timeout += 1
-		time.Sleep(time.Second / 2)
-	}
-	if timeout > vlanmanv1.WaitForDaemonTimeout {
-		err := r.Client.Delete(ctx, &daemonSet)
-		cleanupSuccess := true
-		var cleanupError error = nil
-		if err != nil {
-			cleanupError = err
-			cleanupSuccess = false
-		}
-
-		return &DaemonSetTimeoutError{
-			Name:           a.Manager.OwnerNetworkName,
-			Ready:          int(daemonSet.Status.CurrentNumberScheduled),
-			Total:          int(daemonSet.Status.DesiredNumberScheduled),
-			CleanupSuccess: cleanupSuccess,
-			CleanupErr:     cleanupError,
-		}
-	}
-	selector := labels.NewSelector()
-	requirement, err := labels.NewRequirement(vlanmanv1.ManagerSetLabelKey, "==", []string{a.Manager.OwnerNetworkName})
-	if err != nil {
-		return &errs.InternalError{Context: fmt.Sprintf("Error creating a label selector requirement in createDesiredState: %s", err.Error())}
-	}
-	selector = selector.Add(*requirement)
-	opts := client.ListOptions{
-		LabelSelector: selector,
-	}
-	pods := &corev1.PodList{Items: []corev1.Pod{}}
-	for len(pods.Items) != int(daemonSet.Status.DesiredNumberScheduled) {
-		ready := fmt.Sprintf("%d/%d", len(pods.Items), int(daemonSet.Status.DesiredNumberScheduled))
-		log.Info("Waiting for daemonset to create all pods", "ready", ready)
-		err = r.Client.List(ctx, pods, &opts)
-		if err != nil {
-			return errs.NewClientRequestError("ListPods", err)
-		}
-	}
-	log.Info("Pods", "number", len(pods.Items), "Desired", daemonSet.Status.DesiredNumberScheduled)
-
-	for _, pod := range pods.Items {
-		tries := 1
-		for pod.Status.PodIP == "" && tries <= vlanmanv1.WaitForDaemonTimeout {
-			triesString := fmt.Sprintf("%d/%d", tries, vlanmanv1.WaitForDaemonTimeout)
-			log.Info("Waiting for daemon before starting job", "pod", pod.Name, "tries", triesString)
-			err := r.Client.Get(ctx, types.NamespacedName{Name: pod.Name, Namespace: pod.Namespace}, &pod)
-			if err != nil {
-				return &errs.ClientRequestError{
-					Action: "Wait for pod",
-					Err:    err,
-				}
-			}
-			tries += 1
-		}
-		if tries > vlanmanv1.WaitForDaemonTimeout {
-			return &DaemonPodTimeoutError{
-				Name:           pod.Name,
-				CleanupSuccess: false,
-				CleanupErr:     &errs.UnimplementedError{Feature: "Cleanup unimplemented"}, // TODO
-			}
-		}
-
-		pid, err := requestManagerPID(pod.Status.PodIP)
-		if err != nil {
-			return err
-		}
-		job := interfaceFromDaemon(pod, pid, int(a.Manager.VlanID), r.Env.TTL, r.Env.InterfacePodImage, a.Manager.OwnerNetworkName, r.Env.InterfacePodPullPolicy, a.Manager.Mappings, false)
-		err = r.Client.Create(ctx, &job)
-		if err != nil {
-			if apierrors.IsAlreadyExists(err) {
-				existingJob := batchv1.Job{}
-				err = r.Client.Get(ctx, types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, &existingJob)
-				if err != nil {
-					return errs.NewClientRequestError("Get conflicting job", err)
-				}
-				// wait for job to complete
-				tries := 1
-				for existingJob.Status.Active != 0 && tries <= 30 {
-					log.Info("Waiting for conflicting job to finish", "job", existingJob.Name, "activeItems", existingJob.Status.Active, "tries", fmt.Sprintf("%d/%d", tries, 30))
-					time.Sleep(time.Second)
-					err = r.Client.Get(ctx, types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, &existingJob)
-					if err != nil {
-						return errs.NewClientRequestError("Get conflicting job", err)
-					}
-					tries += 1
-				}
-				log.Info("Deleting conflicting job", "job", existingJob.Name)
-				// Delete pods created by this job as well
-				pp := metav1.DeletePropagationBackground
-				err = r.Client.Delete(ctx, &existingJob, &client.DeleteOptions{
-					PropagationPolicy: &pp,
-				})
-				if err != nil {
-					return errs.NewClientRequestError("Delete conflicting job after it finished", err)
-				}
-				err = r.Client.Get(ctx, types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, &existingJob)
-				if err != nil && !apierrors.IsNotFound(err) {
-					return errs.NewClientRequestError("Get conflicting job", err)
-				}
-
-				jobDeleted := apierrors.IsNotFound(err)
-				tries = 0
-				for !jobDeleted && tries <= 30 {
-					log.Info("Waiting for conflicting job to delete", "job", existingJob.Name, "activeItems", existingJob.Status.Active, "tries", fmt.Sprintf("%d/%d", tries, 30))
-					time.Sleep(time.Second)
-					err = r.Client.Get(ctx, types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, &existingJob)
-					if err != nil && !apierrors.IsNotFound(err) {
-						return errs.NewClientRequestError("Get conflicting job while waiting for delete", err)
-					}
-					if apierrors.IsNotFound(err) {
-						jobDeleted = true
-					}
-					tries += 1
-				}
-				log.Info("Conflicting job deleted successfully, trying to create again...")
-				err = r.Client.Create(ctx, &job)
-				if err != nil {
-					return errs.NewClientRequestError("Create job after deleting conflicting one", err)
-				}
-			} else {
-				// TODO: there should be cleanup here as well
-				return &errs.ClientRequestError{
-					Action: "Create job",
-					Err:    err,
-				}
-			}
-		}
-		resp, err := http.Get(fmt.Sprintf("http://%s:61410", pod.Status.PodIP))
-		if err != nil {
-			return &errs.RequestError{
-				Action: "CheckDaemonReady",
-				Err:    err,
-			}
-		}
-		if resp == nil {
-			return &errs.RequestError{
-				Action: "CheckDaemonReady",
-				Err:    fmt.Errorf("Response is nil"),
-			}
-		}
-		tries = 0
-		for resp.StatusCode != 200 && tries <= vlanmanv1.WaitForDaemonTimeout {
-			triesString := fmt.Sprintf("%d/%d", tries, vlanmanv1.WaitForDaemonTimeout)
-			log.Info("Waiting for pod to return ready (200)", "received", resp.StatusCode, "tries", triesString)
-			resp, err = http.Get(fmt.Sprintf("http://%s:61410/ready", pod.Status.PodIP))
-			if err != nil {
-				return &errs.RequestError{
-					Action: "CheckDaemonReady",
-					Err:    err,
-				}
-			}
-			time.Sleep(time.Second / 2)
-			tries += 1
-		}
-		vlan := vlanmanv1.VlanNetwork{}
-		err = r.Client.Get(ctx, types.NamespacedName{Name: a.Manager.OwnerNetworkName, Namespace: ""}, &vlan)
-		if vlan.Status.State == nil {
-			vlan.Status.State = make(map[string]vlanmanv1.ConnectionState)
-		}
-		vlan.Status.State[pod.Name] = vlanmanv1.StateUp
-		vlan.Status.UpdateShortState()
-		r.Client.Status().Update(ctx, &vlan)
-	}
 	err = r.Client.Create(ctx, &svc)
-	if err != nil {
+	if err != nil && !apierrors.IsAlreadyExists(err) {
 		return &errs.ClientRequestError{
 			Action: "CreateService",
 			Err:    err,
@@ -337,21 +111,21 @@ type DeleteManagerAction struct {
 	Manager ManagerSet
 }
 
-func (a *DeleteManagerAction) Do(ctx context.Context, r *VlanmanReconciler) error {
+func (a *DeleteManagerAction) Do(ctx context.Context, r *VlanmanReconciler) (*time.Duration, error) {
 	daemonSet, err := daemonSetFromManager(a.Manager, r.Env)
 	if err != nil {
-		return &errs.UnrecoverableError{
+		return nil, &errs.UnrecoverableError{
 			Context: "Error creating a daemonset from manager specification",
 			Err:     err,
 		}
 	}
 	svc := serviceForManagerSet(a.Manager, r.Env.NamespaceName)
-	return a.execute(ctx, r, daemonSet, svc)
+	return nil, a.execute(ctx, r, daemonSet, svc)
 }
 
 func (a *DeleteManagerAction) execute(ctx context.Context, r *VlanmanReconciler, daemonSet appsv1.DaemonSet, svc corev1.Service) error {
 	err := r.Client.Delete(ctx, &daemonSet)
-	if err != nil {
+	if err != nil && !apierrors.IsNotFound(err) {
 		return &errs.ClientRequestError{
 			Action: "Delete daemonset",
 			Err:    err,
@@ -368,131 +142,334 @@ func (a *DeleteManagerAction) execute(ctx context.Context, r *VlanmanReconciler,
 	return nil
 }
 
+// SpawnInterfaceAction creates the vlan interface of a manager pod that's new or whose interface is down.
+// Each call does one step: start the interface job, follow it and wait for the manager to report ready.
+// The job being followed is recorded in an annotation of the manager pod.
 type SpawnInterfaceAction struct {
 	OwnerNetwork VlanNetworkState
 	PodName      string
 }
 
-func (a *SpawnInterfaceAction) Do(ctx context.Context, r *VlanmanReconciler) error {
+func (a *SpawnInterfaceAction) Do(ctx context.Context, r *VlanmanReconciler) (*time.Duration, error) {
+	log := log.FromContext(ctx)
 	pod := corev1.Pod{}
-	podNsn := types.NamespacedName{Name: a.PodName, Namespace: r.Env.NamespaceName}
-	err := r.Client.Get(ctx, podNsn, &pod)
+	err := r.Client.Get(ctx, types.NamespacedName{Name: a.PodName, Namespace: r.Env.NamespaceName}, &pod)
+	if apierrors.IsNotFound(err) {
+		// the state of a deleted pod is removed with the next status update
+		return nil, nil
+	}
 	if err != nil {
-		return errs.NewClientRequestError(fmt.Sprintf("Get pod %s@%s", a.PodName, r.Env.NamespaceName), err)
+		return nil, errs.NewClientRequestError(fmt.Sprintf("Get pod %s@%s", a.PodName, r.Env.NamespaceName), err)
 	}
-	for len(pod.Status.PodIP) == 0 {
-		time.Sleep(1 * time.Second)
-		err = r.Client.Get(ctx, podNsn, &pod)
-		if err != nil {
-			return errs.NewClientRequestError("Get pod in spawn interface action", err)
+	if pod.DeletionTimestamp != nil {
+		return nil, nil
+	}
+	if pod.Status.PodIP == "" {
+		timeout := time.Second * vlanmanv1.WaitForDaemonTimeout
+		if time.Since(pod.CreationTimestamp.Time) > timeout {
+			return nil, &DaemonPodTimeoutError{Name: pod.Name, Timeout: timeout}
 		}
+		log.Info("Waiting for daemon before starting job", "pod", pod.Name)
+		return requeue(), nil
 	}
+
+	if jobName, ok := pod.Annotations[vlanmanv1.ManagerInterfaceJobAnnotation]; ok {
+		return a.followJob(ctx, r, pod, jobName)
+	}
+	return a.startJob(ctx, r, pod)
+}
+
+// startJob creates the interface job, a job left with the same name is deleted first
+func (a *SpawnInterfaceAction) startJob(ctx context.Context, r *VlanmanReconciler, pod corev1.Pod) (*time.Duration, error) {
+	log := log.FromContext(ctx)
 	pid, err := requestManagerPID(pod.Status.PodIP)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	mapping, err := r.parentMapping(ctx, pod, a.OwnerNetwork.Mappings)
+	if err != nil {
+		return nil, err
+	}
+	// pods that already have a state had their interface created before
+	_, fixup := a.OwnerNetwork.Status[a.PodName]
+	job := interfaceFromDaemon(pod, pid, a.OwnerNetwork.VlanId, a.OwnerNetwork.ServiceTag, r.Env.TTL, r.Env.InterfacePodImage, a.OwnerNetwork.Name, r.Env.InterfacePodPullPolicy, mapping, a.OwnerNetwork.MTU, fixup)
+
+	err = r.setNetworkOwner(ctx, a.OwnerNetwork.Name, &job)
+	if err != nil {
+		return nil, err
 	}
 
-	job := interfaceFromDaemon(pod, pid, int(a.OwnerNetwork.VlanId), r.Env.TTL, r.Env.InterfacePodImage, a.OwnerNetwork.Name, r.Env.InterfacePodPullPolicy, a.OwnerNetwork.Mappings, true)
-	return a.execute(ctx, r, job)
+	existingJob := batchv1.Job{}
+	err = r.Client.Get(ctx, types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, &existingJob)
+	if err == nil {
+		if existingJob.DeletionTimestamp != nil {
+			log.Info("Waiting for conflicting job to delete", "job", existingJob.Name)
+			return requeue(), nil
+		}
+		if _, finished := jobFinished(existingJob); !finished {
+			log.Info("Waiting for conflicting job to finish", "job", existingJob.Name, "activeItems", existingJob.Status.Active)
+			return requeue(), nil
+		}
+		log.Info("Deleting conflicting job", "job", existingJob.Name)
+		// Delete pods created by this job as well
+		pp := metav1.DeletePropagationBackground
+		err = r.Client.Delete(ctx, &existingJob, &client.DeleteOptions{
+			PropagationPolicy: &pp,
+		})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, errs.NewClientRequestError("Delete conflicting job after it finished", err)
+		}
+		return requeue(), nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, errs.NewClientRequestError("Get conflicting job", err)
+	}
+
+	err = r.Client.Create(ctx, &job)
+	if apierrors.IsAlreadyExists(err) {
+		return requeue(), nil
+	}
+	if err != nil {
+		return nil, &errs.ClientRequestError{
+			Action: "Create job",
+			Err:    err,
+		}
+	}
+	log.Info("Started interface job", "job", job.Name, "pod", pod.Name)
+	err = r.setInterfaceJob(ctx, pod, job.Name)
+	if err != nil {
+		return nil, err
+	}
+	return requeue(), nil
 }
 
-func (a *SpawnInterfaceAction) execute(ctx context.Context, r *VlanmanReconciler, job batchv1.Job) error {
+// followJob waits for the interface job to finish and for the manager to report its interface
+// ready, the pod's state is set up then. A failed or missing job is started again.
+func (a *SpawnInterfaceAction) followJob(ctx context.Context, r *VlanmanReconciler, pod corev1.Pod, jobName string) (*time.Duration, error) {
 	log := log.FromContext(ctx)
-	err := r.Client.Create(ctx, &job)
+	job := batchv1.Job{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: jobName, Namespace: pod.Namespace}, &job)
+	if apierrors.IsNotFound(err) {
+		log.Info("Interface job is gone, starting it again", "job", jobName, "pod", pod.Name)
+		return requeue(), r.setInterfaceJob(ctx, pod, "")
+	}
+	if err != nil {
+		return nil, errs.NewClientRequestError("Get running job", err)
+	}
+
+	failed, finished := jobFinished(job)
+	if !finished {
+		log.Info("Waiting for job to finish", "job", job.Name, "activeItems", job.Status.Active)
+		return requeue(), nil
+	}
+	if failed {
+		// the job is kept so that its failure is copied to the status, it's deleted before the next one starts
+		err = r.setInterfaceJob(ctx, pod, "")
+		if err != nil {
+			return nil, err
+		}
+		return nil, &InterfaceJobFailedError{Job: job.Name, Pod: pod.Name}
+	}
+
+	ready, err := managerReady(pod.Status.PodIP)
+	if err != nil {
+		return nil, err
+	}
+	if !ready {
+		log.Info("Waiting for pod to return ready (200)", "pod", pod.Name)
+		return requeue(), nil
+	}
+	log.Info("Job finished", "job", job.Name)
+
+	err = r.setInterfaceJob(ctx, pod, "")
 	if err != nil {
-		if apierrors.IsAlreadyExists(err) {
-			existingJob := batchv1.Job{}
-			err = r.Client.Get(ctx, types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, &existingJob)
-			if err != nil {
-				return errs.NewClientRequestError("Get conflicting job", err)
-			}
-			// wait for job to complete
-			tries := 1
-			for existingJob.Status.Active != 0 && tries <= 30 {
-				log.Info("Waiting for conflicting job to finish", "job", existingJob.Name, "activeItems", existingJob.Status.Active, "tries", fmt.Sprintf("%d/%d", tries, 30))
-				time.Sleep(time.Second)
-				err = r.Client.Get(ctx, types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, &existingJob)
-				if err != nil {
-					return errs.NewClientRequestError("Get conflicting job", err)
-				}
-				tries += 1
-			}
-			log.Info("Deleting conflicting job", "job", existingJob.Name)
-			// Delete pods created by this job as well
-			pp := metav1.DeletePropagationBackground
-			err = r.Client.Delete(ctx, &existingJob, &client.DeleteOptions{
-				PropagationPolicy: &pp,
-			})
-			if err != nil {
-				return errs.NewClientRequestError("Delete conflicting job after it finished", err)
-			}
-			err = r.Client.Get(ctx, types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, &existingJob)
-			if err != nil && !apierrors.IsNotFound(err) {
-				return errs.NewClientRequestError("Get conflicting job", err)
-			}
-
-			jobDeleted := apierrors.IsNotFound(err)
-			tries = 0
-			for !jobDeleted && tries <= 30 {
-				log.Info("Waiting for conflicting job to delete", "job", existingJob.Name, "activeItems", existingJob.Status.Active, "tries", fmt.Sprintf("%d/%d", tries, 30))
-				time.Sleep(time.Second)
-				err = r.Client.Get(ctx, types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, &existingJob)
-				if err != nil && !apierrors.IsNotFound(err) {
-					return errs.NewClientRequestError("Get conflicting job while waiting for delete", err)
-				}
-				if apierrors.IsNotFound(err) {
-					jobDeleted = true
-				}
-				tries += 1
-			}
-			log.Info("Conflicting job deleted successfully, trying to create again...")
-			err = r.Client.Create(ctx, &job)
-			if err != nil {
-				return errs.NewClientRequestError("Create job after deleting conflicting one", err)
-			}
-		} else {
-			return &errs.ClientRequestError{
-				Action: "Create job",
-				Err:    err,
-			}
+		return nil, err
+	}
+	return nil, r.setPodState(ctx, a.OwnerNetwork.Name, pod.Name, vlanmanv1.StateUp)
+}
+
+// jobFinished checks the conditions of the job, Active is zero before its pod is created as well
+func jobFinished(job batchv1.Job) (failed bool, finished bool) {
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobFailed:
+			return true, true
+		case batchv1.JobComplete:
+			return false, true
 		}
 	}
+	return false, false
+}
 
-	tries := 0
-	for job.Status.Active != 0 && tries <= 30 {
-		log.Info("Waiting for conflicting job to finish", "job", job.Name, "activeItems", job.Status.Active, "tries", fmt.Sprintf("%d/%d", tries, 30))
-		time.Sleep(time.Second)
-		err = r.Client.Get(ctx, types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, &job)
+// setInterfaceJob records the interface job of the manager pod, an empty name removes it
+func (r *VlanmanReconciler) setInterfaceJob(ctx context.Context, pod corev1.Pod, jobName string) error {
+	patch := client.MergeFrom(pod.DeepCopy())
+	if jobName == "" {
+		delete(pod.Annotations, vlanmanv1.ManagerInterfaceJobAnnotation)
+	} else {
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[vlanmanv1.ManagerInterfaceJobAnnotation] = jobName
+	}
+	err := r.Client.Patch(ctx, &pod, patch)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errs.NewClientRequestError(fmt.Sprintf("Annotate manager pod %s", pod.Name), err)
+	}
+	return nil
+}
+
+// setNetworkOwner makes the network the controller of objects created for it,
+// they're garbage collected with the network even if its finalizer was removed by hand
+func (r *VlanmanReconciler) setNetworkOwner(ctx context.Context, network string, objs ...client.Object) error {
+	net := vlanmanv1.VlanNetwork{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: network}, &net)
+	if err != nil {
+		return errs.NewClientRequestError(fmt.Sprintf("Get owner network %s", network), err)
+	}
+	for _, obj := range objs {
+		err = controllerutil.SetControllerReference(&net, obj, r.Scheme)
 		if err != nil {
-			return errs.NewClientRequestError("Get running job", err)
+			return &errs.InternalError{Context: fmt.Sprintf("Couldn't set owner reference of %s: %s", obj.GetName(), err)}
 		}
-		tries += 1
 	}
-	log.Info("Job finished")
-	if tries == 31 {
-		return &errs.InternalError{
-			Context: fmt.Sprintf("Error waiting for vlan interface creation job to complete: %s", job.Name),
+	return nil
+}
+
+// setPodState records the state of a manager pod in the status of the network
+func (r *VlanmanReconciler) setPodState(ctx context.Context, network, podName string, state vlanmanv1.ConnectionState) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		vlan := vlanmanv1.VlanNetwork{}
+		err := r.Client.Get(ctx, types.NamespacedName{Name: network}, &vlan)
+		if err != nil {
+			return err
+		}
+		if vlan.Status.State == nil {
+			vlan.Status.State = make(map[string]vlanmanv1.ConnectionState)
 		}
+		vlan.Status.State[podName] = state
+		vlan.Status.UpdateShortState()
+		return r.Client.Status().Update(ctx, &vlan)
+	})
+	if err != nil {
+		return errs.NewClientRequestError(fmt.Sprintf("Update status of vlan network %s", network), err)
 	}
-	vlan := vlanmanv1.VlanNetwork{}
-	err = r.Client.Get(ctx, types.NamespacedName{Name: a.OwnerNetwork.Name, Namespace: ""}, &vlan)
+	return nil
+}
+
+// parentMapping resolves the mapping of the node the manager pod runs on
+// and records the parent interface in the pod, so that label changes can be detected
+func (r *VlanmanReconciler) parentMapping(ctx context.Context, pod corev1.Pod, mappings []vlanmanv1.IPMapping) (vlanmanv1.IPMapping, error) {
+	node := corev1.Node{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: pod.Spec.NodeName}, &node)
+	if err != nil {
+		return vlanmanv1.IPMapping{}, errs.NewClientRequestError(fmt.Sprintf("Get node %s", pod.Spec.NodeName), err)
+	}
+	mapping, _, err := resolveMapping(node, mappings)
 	if err != nil {
-		return errs.NewClientRequestError(fmt.Sprintf("Get vlan network %s", a.OwnerNetwork.Name), err)
+		return vlanmanv1.IPMapping{}, err
+	}
+
+	if current, ok := pod.Annotations[vlanmanv1.ManagerParentInterfaceAnnotation]; ok && current == parentSelector(mapping) {
+		return mapping, nil
 	}
-	if vlan.Status.State == nil {
-		vlan.Status.State = make(map[string]vlanmanv1.ConnectionState)
+	patch := client.MergeFrom(pod.DeepCopy())
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
 	}
-	vlan.Status.State[a.PodName] = vlanmanv1.StateUp
-	vlan.Status.UpdateShortState()
-	err = r.Client.Status().Update(ctx, &vlan)
+	pod.Annotations[vlanmanv1.ManagerParentInterfaceAnnotation] = parentSelector(mapping)
+	err = r.Client.Patch(ctx, &pod, patch)
 	if err != nil {
-		return errs.NewClientRequestError(fmt.Sprintf("Update status of vlan network %s", a.OwnerNetwork.Name), err)
+		return vlanmanv1.IPMapping{}, errs.NewClientRequestError(fmt.Sprintf("Annotate manager pod %s", pod.Name), err)
+	}
+	return mapping, nil
+}
+
+// RecreateManagerPodAction deletes a manager pod whose node now maps to a different parent interface,
+// the vlan interface is removed with the pod's netns and created on the new parent for its replacement
+type RecreateManagerPodAction struct {
+	OwnerNetwork string
+	PodName      string
+	From         string
+	To           string
+}
+
+func (a *RecreateManagerPodAction) Do(ctx context.Context, r *VlanmanReconciler) (*time.Duration, error) {
+	log.FromContext(ctx).Info("Parent interface of manager changed, recreating pod", "pod", a.PodName, "from", a.From, "to", a.To)
+	r.networkEvent(ctx, a.OwnerNetwork, corev1.EventTypeNormal, vlanmanv1.EventReasonParentChanged, "Recreating manager pod %s, parent interface changed from '%s' to '%s'", a.PodName, a.From, a.To)
+	return nil, deleteManagerPod(ctx, r, a.PodName)
+}
+
+// RolloutManagerPodAction deletes a manager pod created from an older template of the DaemonSet,
+// it uses the OnDelete strategy so the replacement is created from the current one.
+// With Handover the pod moves its vlan interface to the host netns when it terminates, the interface
+// job of the replacement reuses it, so the interfaces of worker pods on top of it are kept.
+type RolloutManagerPodAction struct {
+	OwnerNetwork string
+	PodName      string
+	NodeName     string
+	From         string
+	To           string
+	Handover     bool
+}
+
+func (a *RolloutManagerPodAction) Do(ctx context.Context, r *VlanmanReconciler) (*time.Duration, error) {
+	log.FromContext(ctx).Info("Manager pod is outdated, recreating it", "pod", a.PodName, "node", a.NodeName, "generation", a.From, "current", a.To, "handover", a.Handover)
+	if a.Handover {
+		pod := corev1.Pod{}
+		err := r.Client.Get(ctx, types.NamespacedName{Name: a.PodName, Namespace: r.Env.NamespaceName}, &pod)
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, errs.NewClientRequestError("Get manager pod", err)
+		}
+		patch := client.MergeFrom(pod.DeepCopy())
+		metav1.SetMetaDataAnnotation(&pod.ObjectMeta, vlanmanv1.ManagerHandoverAnnotation, "true")
+		err = r.Client.Patch(ctx, &pod, patch)
+		if err != nil {
+			return nil, errs.NewClientRequestError("Annotate manager pod for handover", err)
+		}
+	}
+	r.networkEvent(ctx, a.OwnerNetwork, corev1.EventTypeNormal, vlanmanv1.EventReasonManagerUpdated, "Recreating manager pod %s on node %s, it was created from template generation %s, current is %s", a.PodName, a.NodeName, a.From, a.To)
+	return nil, deleteManagerPod(ctx, r, a.PodName)
+}
+
+func deleteManagerPod(ctx context.Context, r *VlanmanReconciler, name string) error {
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: r.Env.NamespaceName,
+		},
+	}
+	err := r.Client.Delete(ctx, &pod)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errs.NewClientRequestError(fmt.Sprintf("Delete manager pod %s", name), err)
 	}
 	return nil
 }
 
+// managerHTTPClient has a timeout so that an unresponsive manager pod doesn't block reconciliation
+var managerHTTPClient = &http.Client{Timeout: 5 * time.Second}
+
+// managerReady checks whether the vlan interface of the manager pod is up
+func managerReady(IP string) (bool, error) {
+	resp, err := managerHTTPClient.Get(fmt.Sprintf("http://%s:61410/ready", IP))
+	if err != nil {
+		return false, &errs.RequestError{
+			Action: "CheckDaemonReady",
+			Err:    err,
+		}
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
+
 func requestManagerPID(IP string) (int, error) {
-	resp, err := http.Get(fmt.Sprintf("http://%s:61410/pid", IP))
+	resp, err := managerHTTPClient.Get(fmt.Sprintf("http://%s:61410/pid", IP))
 	if err != nil {
 		return 0, &errs.RequestError{
 			Action: "Get PID",
@@ -530,8 +507,14 @@ type UpdateManagerAction struct {
 	Manager      ManagerSet
 }
 
-func (a *UpdateManagerAction) Do(ctx context.Context, r *VlanmanReconciler) error {
+func (a *UpdateManagerAction) Do(ctx context.Context, r *VlanmanReconciler) (*time.Duration, error) {
 	desiredDs, err := daemonSetFromManager(a.Manager, r.Env)
+	if err != nil {
+		return nil, &errs.UnrecoverableError{
+			Context: "Error creating a daemonset from manager specification",
+			Err:     err,
+		}
+	}
 	// currentDs := appsv1.DaemonSet{}
 	// if err != nil {
 	// 	return &errs.InternalError{Context: fmt.Sprintf("Couldn't convert managerset object to daemonset: %s", err)}
@@ -541,9 +524,13 @@ func (a *UpdateManagerAction) Do(ctx context.Context, r *VlanmanReconciler) erro
 	// 	return errs.NewClientRequestError("Get daemonset", err)
 	// }
 
+	err = r.setNetworkOwner(ctx, a.Manager.OwnerNetworkName, &desiredDs)
+	if err != nil {
+		return nil, err
+	}
 	err = r.Client.Update(ctx, &desiredDs)
 	if err != nil {
-		return errs.NewClientRequestError("Update daemonset", err)
+		return nil, errs.NewClientRequestError("Update daemonset", err)
 	}
-	return nil
+	return nil, nil
 }
@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	vlanmanv1 "dialo.ai/vlanman/api/v1"
+)
+
+func TestJobFinished(t *testing.T) {
+	condition := func(t batchv1.JobConditionType, s corev1.ConditionStatus) batchv1.Job {
+		return batchv1.Job{Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{{Type: t, Status: s}}}}
+	}
+	tests := []struct {
+		name     string
+		job      batchv1.Job
+		failed   bool
+		finished bool
+	}{
+		{name: "just created", job: batchv1.Job{}},
+		{name: "running", job: batchv1.Job{Status: batchv1.JobStatus{Active: 1}}},
+		{name: "complete", job: condition(batchv1.JobComplete, corev1.ConditionTrue), finished: true},
+		{name: "failed", job: condition(batchv1.JobFailed, corev1.ConditionTrue), failed: true, finished: true},
+		{name: "condition not true", job: condition(batchv1.JobFailed, corev1.ConditionFalse)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failed, finished := jobFinished(tt.job)
+			assert.Equal(t, tt.failed, failed)
+			assert.Equal(t, tt.finished, finished)
+		})
+	}
+}
+
+func TestSpawnInterfaceAction(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = batchv1.AddToScheme(scheme)
+	_ = vlanmanv1.AddToScheme(scheme)
+
+	managerPod := func(podIP string, created time.Time, jobName string) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "manager-1",
+				Namespace:         "vlanman-system",
+				Labels:            map[string]string{vlanmanv1.ManagerSetLabelKey: "net1"},
+				CreationTimestamp: metav1.NewTime(created),
+			},
+			Spec:   corev1.PodSpec{NodeName: "node1"},
+			Status: corev1.PodStatus{PodIP: podIP},
+		}
+		if jobName != "" {
+			pod.Annotations = map[string]string{vlanmanv1.ManagerInterfaceJobAnnotation: jobName}
+		}
+		return pod
+	}
+	job := func(status batchv1.JobStatus) *batchv1.Job {
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "create-vlan-job-net1-node1", Namespace: "vlanman-system"},
+			Status:     status,
+		}
+	}
+
+	tests := []struct {
+		name           string
+		objects        []client.Object
+		expectRequeue  bool
+		expectErr      error
+		expectJobAnnot bool
+	}{
+		{
+			name: "pod is gone",
+		},
+		{
+			name:          "pod without IP",
+			objects:       []client.Object{managerPod("", time.Now(), "")},
+			expectRequeue: true,
+		},
+		{
+			name:      "pod without IP for too long",
+			objects:   []client.Object{managerPod("", time.Now().Add(-time.Hour), "")},
+			expectErr: ErrDaemonPodTimeout,
+		},
+		{
+			name:           "job is running",
+			objects:        []client.Object{managerPod("10.0.0.5", time.Now(), "create-vlan-job-net1-node1"), job(batchv1.JobStatus{Active: 1})},
+			expectRequeue:  true,
+			expectJobAnnot: true,
+		},
+		{
+			name:          "job is gone",
+			objects:       []client.Object{managerPod("10.0.0.5", time.Now(), "create-vlan-job-net1-node1")},
+			expectRequeue: true,
+		},
+		{
+			name: "job failed",
+			objects: []client.Object{
+				managerPod("10.0.0.5", time.Now(), "create-vlan-job-net1-node1"),
+				job(batchv1.JobStatus{Conditions: []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}}),
+			},
+			expectErr: ErrInterfaceJobFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(tt.objects...).Build()
+			reconciler := &VlanmanReconciler{
+				Client: c,
+				Scheme: scheme,
+				Env:    Envs{NamespaceName: "vlanman-system"},
+			}
+			action := &SpawnInterfaceAction{
+				OwnerNetwork: VlanNetworkState{Name: "net1", VlanId: 10},
+				PodName:      "manager-1",
+			}
+
+			rq, err := action.Do(context.Background(), reconciler)
+			if tt.expectErr != nil {
+				assert.ErrorIs(t, err, tt.expectErr)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectRequeue, rq != nil)
+
+			pod := corev1.Pod{}
+			err = c.Get(context.Background(), types.NamespacedName{Name: "manager-1", Namespace: "vlanman-system"}, &pod)
+			if err != nil {
+				return
+			}
+			_, ok := pod.Annotations[vlanmanv1.ManagerInterfaceJobAnnotation]
+			assert.Equal(t, tt.expectJobAnnot, ok)
+		})
+	}
+}
+
+func TestRolloutManagerPodAction(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = vlanmanv1.AddToScheme(scheme)
+
+	for _, handover := range []bool{true, false} {
+		// the finalizer keeps the deleted pod around to check its annotations
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:       "manager-1",
+			Namespace:  "vlanman-system",
+			Finalizers: []string{"test"},
+		}}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pod).Build()
+		reconciler := &VlanmanReconciler{Client: c, Scheme: scheme, Env: Envs{NamespaceName: "vlanman-system"}}
+		action := &RolloutManagerPodAction{OwnerNetwork: "net1", PodName: "manager-1", From: "1", To: "2", Handover: handover}
+
+		rq, err := action.Do(context.Background(), reconciler)
+		require.NoError(t, err)
+		assert.Nil(t, rq)
+
+		current := corev1.Pod{}
+		require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "manager-1", Namespace: "vlanman-system"}, &current))
+		assert.NotNil(t, current.DeletionTimestamp)
+		_, ok := current.Annotations[vlanmanv1.ManagerHandoverAnnotation]
+		assert.Equal(t, handover, ok)
+	}
+}
@@ -1,15 +1,24 @@
 package controller
 
 import (
+	"slices"
 	"strconv"
 	"strings"
 
 	vlanmanv1 "dialo.ai/vlanman/api/v1"
+	errs "dialo.ai/vlanman/pkg/errors"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
+// ServiceTag is the outer tag of a QinQ network, an ID of 0 means the network has a single tag
+type ServiceTag struct {
+	ID       int
+	Protocol vlanmanv1.VlanProtocol
+}
+
 type InterfacePod struct {
 	ID            int
 	PID           int
@@ -17,13 +26,65 @@ type InterfacePod struct {
 	InterfaceName string
 }
 
-func interfaceFromDaemon(p corev1.Pod, pid, id int, ttl *int32, image, networkName, pullPolicy string, mappings []vlanmanv1.IPMapping, fixup bool) batchv1.Job {
-	intrface := ""
-	for _, m := range mappings {
-		if m.NodeName == p.Spec.NodeName {
-			intrface = m.Interface
-			break
+// resolveMapping returns the mapping of the node. A mapping with the node's name wins,
+// then the selector mapping with the highest priority, the first listed on ties.
+// Returns false if no mapping matches, the default route interface is used then.
+func resolveMapping(node corev1.Node, mappings []vlanmanv1.IPMapping) (vlanmanv1.IPMapping, bool, error) {
+	var best *vlanmanv1.IPMapping
+	for i, m := range mappings {
+		if m.NodeName != "" {
+			if m.NodeName == node.Name {
+				return m, true, nil
+			}
+			continue
+		}
+		if m.NodeSelector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(m.NodeSelector)
+		if err != nil {
+			return vlanmanv1.IPMapping{}, false, errs.NewParsingError("mapping node selector", err)
+		}
+		if !selector.Matches(labels.Set(node.Labels)) {
+			continue
 		}
+		if best == nil || m.Priority > best.Priority {
+			best = &mappings[i]
+		}
+	}
+	if best == nil {
+		return vlanmanv1.IPMapping{}, false, nil
+	}
+	return *best, true, nil
+}
+
+// parentSelector describes how the mapping identifies the parent interface,
+// it's empty for the default route interface and the name for mappings only setting it
+func parentSelector(m vlanmanv1.IPMapping) string {
+	parts := []string{}
+	for _, kv := range [][2]string{
+		{"macAddress", m.MACAddress},
+		{"pciAddress", m.PCIAddress},
+		{"driver", m.Driver},
+		{"altName", m.AltName},
+	} {
+		if kv[1] != "" {
+			parts = append(parts, kv[0]+"="+kv[1])
+		}
+	}
+	if len(parts) == 0 {
+		return m.Interface
+	}
+	if m.Interface != "" {
+		parts = append([]string{"interfaceName=" + m.Interface}, parts...)
+	}
+	return strings.Join(parts, ",")
+}
+
+func interfaceFromDaemon(p corev1.Pod, pid, id int, serviceTag ServiceTag, ttl *int32, image, networkName, pullPolicy string, mapping vlanmanv1.IPMapping, mtu int, fixup bool) batchv1.Job {
+	intrface := mapping.Interface
+	if mapping.MTU != 0 {
+		mtu = mapping.MTU
 	}
 	var tgp int64 = 1
 	parts := []string{vlanmanv1.JobNamePrefix, networkName, p.Spec.NodeName}
@@ -34,10 +95,18 @@ func interfaceFromDaemon(p corev1.Pod, pid, id int, ttl *int32, image, networkNa
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      strings.Join(parts, "-"),
 			Namespace: p.Namespace,
+			Labels: map[string]string{
+				vlanmanv1.InterfaceJobNetworkLabelKey: networkName,
+			},
 		},
 		Spec: batchv1.JobSpec{
 			TTLSecondsAfterFinished: ttl,
 			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						vlanmanv1.InterfaceJobNetworkLabelKey: networkName,
+					},
+				},
 				Spec: corev1.PodSpec{
 					TerminationGracePeriodSeconds: &tgp,
 					HostNetwork:                   true,
@@ -63,6 +132,101 @@ func interfaceFromDaemon(p corev1.Pod, pid, id int, ttl *int32, image, networkNa
 									Name:  "INTERFACE",
 									Value: intrface,
 								},
+								{
+									Name:  "INTERFACE_MAC",
+									Value: mapping.MACAddress,
+								},
+								{
+									Name:  "INTERFACE_PCI",
+									Value: mapping.PCIAddress,
+								},
+								{
+									Name:  "INTERFACE_DRIVER",
+									Value: mapping.Driver,
+								},
+								{
+									Name:  "INTERFACE_ALTNAME",
+									Value: mapping.AltName,
+								},
+								{
+									Name:  "MTU",
+									Value: strconv.Itoa(mtu),
+								},
+								{
+									Name:  "SERVICE_ID",
+									Value: strconv.Itoa(serviceTag.ID),
+								},
+								{
+									Name:  "SERVICE_PROTOCOL",
+									Value: string(serviceTag.Protocol.OrDefault()),
+								},
+							},
+							SecurityContext: &corev1.SecurityContext{
+								Capabilities: &corev1.Capabilities{
+									Add: slices.Clone(netnsCapabilities),
+								},
+							},
+						},
+					},
+					RestartPolicy: corev1.RestartPolicyNever,
+				},
+			},
+		},
+	}
+}
+
+// teardownJob removes the links of a deleted network left in the host netns of the node,
+// the service vlan is only removed if no other network uses the service tag. The finished job
+// marks the node as torn down, so it has no TTL, it's garbage collected with the network.
+func teardownJob(node, namespace string, id int, serviceTag ServiceTag, removeServiceVlan bool, image, networkName, pullPolicy string) batchv1.Job {
+	var tgp int64 = 1
+	var deadline int64 = vlanmanv1.TeardownJobDeadlineSeconds
+	var backoff int32 = 2
+	return batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      strings.Join([]string{vlanmanv1.TeardownJobNamePrefix, networkName, node}, "-"),
+			Namespace: namespace,
+			Labels: map[string]string{
+				vlanmanv1.TeardownJobNetworkLabelKey: networkName,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			ActiveDeadlineSeconds: &deadline,
+			BackoffLimit:          &backoff,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						vlanmanv1.TeardownJobNetworkLabelKey: networkName,
+					},
+				},
+				Spec: corev1.PodSpec{
+					TerminationGracePeriodSeconds: &tgp,
+					HostNetwork:                   true,
+					NodeSelector: map[string]string{
+						"kubernetes.io/hostname": node,
+					},
+					Containers: []corev1.Container{
+						{
+							Name:            "delete-vlan",
+							Image:           image,
+							ImagePullPolicy: corev1.PullPolicy(pullPolicy),
+							Env: []corev1.EnvVar{
+								{
+									Name:  "TEARDOWN",
+									Value: "true",
+								},
+								{
+									Name:  "ID",
+									Value: strconv.FormatInt(int64(id), 10),
+								},
+								{
+									Name:  "SERVICE_ID",
+									Value: strconv.Itoa(serviceTag.ID),
+								},
+								{
+									Name:  "REMOVE_SERVICE_VLAN",
+									Value: strconv.FormatBool(removeServiceVlan),
+								},
 							},
 							SecurityContext: &corev1.SecurityContext{
 								Capabilities: &corev1.Capabilities{
@@ -5,6 +5,7 @@ import (
 
 	vlanmanv1 "dialo.ai/vlanman/api/v1"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -63,11 +64,12 @@ func TestInterfaceFromDaemon(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			job := interfaceFromDaemon(tt.pod, tt.pid, tt.id, tt.ttl, tt.image, tt.networkName, tt.pullPolicy, []vlanmanv1.IPMapping{})
+			job := interfaceFromDaemon(tt.pod, tt.pid, tt.id, ServiceTag{}, tt.ttl, tt.image, tt.networkName, tt.pullPolicy, vlanmanv1.IPMapping{}, 0, false)
 
 			// Verify job metadata
 			assert.Equal(t, tt.expectedJob(), job.Name)
 			assert.Equal(t, tt.pod.Namespace, job.Namespace)
+			assert.Equal(t, tt.networkName, job.Labels[vlanmanv1.InterfaceJobNetworkLabelKey])
 
 			// Verify job spec
 			assert.Equal(t, tt.ttl, job.Spec.TTLSecondsAfterFinished)
@@ -95,12 +97,26 @@ func TestInterfaceFromDaemon(t *testing.T) {
 				{Name: "PID", Value: "12345"},
 				{Name: "ID", Value: "100"},
 				{Name: "INTERFACE", Value: ""},
+				{Name: "INTERFACE_MAC", Value: ""},
+				{Name: "INTERFACE_PCI", Value: ""},
+				{Name: "INTERFACE_DRIVER", Value: ""},
+				{Name: "INTERFACE_ALTNAME", Value: ""},
+				{Name: "MTU", Value: "0"},
+				{Name: "SERVICE_ID", Value: "0"},
+				{Name: "SERVICE_PROTOCOL", Value: "802.1ad"},
 			}
 			if tt.pid == 67890 {
 				expectedEnvVars = []corev1.EnvVar{
 					{Name: "PID", Value: "67890"},
 					{Name: "ID", Value: "200"},
 					{Name: "INTERFACE", Value: ""},
+					{Name: "INTERFACE_MAC", Value: ""},
+					{Name: "INTERFACE_PCI", Value: ""},
+					{Name: "INTERFACE_DRIVER", Value: ""},
+					{Name: "INTERFACE_ALTNAME", Value: ""},
+					{Name: "MTU", Value: "0"},
+					{Name: "SERVICE_ID", Value: "0"},
+					{Name: "SERVICE_PROTOCOL", Value: "802.1ad"},
 				}
 
 			}
@@ -109,8 +125,134 @@ func TestInterfaceFromDaemon(t *testing.T) {
 			// Verify security context
 			assert.NotNil(t, container.SecurityContext)
 			assert.NotNil(t, container.SecurityContext.Capabilities)
-			expectedCapabilities := []corev1.Capability{"NET_ADMIN", "NET_RAW"}
+			expectedCapabilities := []corev1.Capability{"NET_ADMIN", "NET_RAW", "SYS_ADMIN"}
 			assert.Equal(t, expectedCapabilities, container.SecurityContext.Capabilities.Add)
 		})
 	}
 }
+
+func TestInterfaceFromDaemonMTU(t *testing.T) {
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "test-namespace"},
+		Spec:       corev1.PodSpec{NodeName: "jumbo-node"},
+	}
+
+	job := interfaceFromDaemon(pod, 1, 100, ServiceTag{}, nil, "image", "net", "IfNotPresent", vlanmanv1.IPMapping{NodeName: "jumbo-node", Interface: "eth1", MTU: 9000}, 1450, false)
+	assert.Contains(t, job.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{Name: "INTERFACE", Value: "eth1"})
+	assert.Contains(t, job.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{Name: "MTU", Value: "9000"})
+
+	job = interfaceFromDaemon(pod, 1, 100, ServiceTag{}, nil, "image", "net", "IfNotPresent", vlanmanv1.IPMapping{NodeName: "jumbo-node", Interface: "eth1"}, 1450, false)
+	assert.Contains(t, job.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{Name: "MTU", Value: "1450"})
+}
+
+func TestResolveMapping(t *testing.T) {
+	edge := &metav1.LabelSelector{MatchLabels: map[string]string{"node-type": "edge"}}
+	fast := &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+		{Key: "nic", Operator: metav1.LabelSelectorOpIn, Values: []string{"100g"}},
+	}}
+	mappings := []vlanmanv1.IPMapping{
+		{NodeSelector: edge, Interface: "bond0"},
+		{NodeSelector: fast, Interface: "ens5", Priority: 10},
+		{NodeSelector: edge, Interface: "bond1"},
+		{NodeName: "special", Interface: "eth7"},
+	}
+
+	tests := []struct {
+		name      string
+		node      corev1.Node
+		expected  string
+		resolved  bool
+		mappings  []vlanmanv1.IPMapping
+		expectErr bool
+	}{
+		{
+			name:     "selector match",
+			node:     corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "edge-1", Labels: map[string]string{"node-type": "edge"}}},
+			expected: "bond0",
+			resolved: true,
+		},
+		{
+			name:     "higher priority wins",
+			node:     corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "edge-2", Labels: map[string]string{"node-type": "edge", "nic": "100g"}}},
+			expected: "ens5",
+			resolved: true,
+		},
+		{
+			name:     "node name wins over selectors",
+			node:     corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "special", Labels: map[string]string{"node-type": "edge", "nic": "100g"}}},
+			expected: "eth7",
+			resolved: true,
+		},
+		{
+			name:     "no match",
+			node:     corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "core-1", Labels: map[string]string{"node-type": "core"}}},
+			resolved: false,
+		},
+		{
+			name: "invalid selector",
+			node: corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "core-1"}},
+			mappings: []vlanmanv1.IPMapping{{NodeSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "nic", Operator: "Bad"},
+			}}, Interface: "eth0"}},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := mappings
+			if tt.mappings != nil {
+				m = tt.mappings
+			}
+			mapping, ok, err := resolveMapping(tt.node, m)
+			if tt.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.resolved, ok)
+			assert.Equal(t, tt.expected, mapping.Interface)
+		})
+	}
+}
+
+func TestInterfaceFromDaemonServiceTag(t *testing.T) {
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "test-namespace"},
+	}
+
+	job := interfaceFromDaemon(pod, 1, 100, ServiceTag{ID: 300, Protocol: vlanmanv1.VlanProtocol8021Q}, nil, "image", "net", "IfNotPresent", vlanmanv1.IPMapping{}, 0, false)
+	assert.Contains(t, job.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{Name: "SERVICE_ID", Value: "300"})
+	assert.Contains(t, job.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{Name: "SERVICE_PROTOCOL", Value: "802.1Q"})
+}
+
+func TestParentSelector(t *testing.T) {
+	assert.Equal(t, "", parentSelector(vlanmanv1.IPMapping{NodeName: "node", MTU: 9000}))
+	assert.Equal(t, "eth1", parentSelector(vlanmanv1.IPMapping{Interface: "eth1"}))
+	assert.Equal(t, "pciAddress=0000:3b:00.0,driver=mlx5_core", parentSelector(vlanmanv1.IPMapping{PCIAddress: "0000:3b:00.0", Driver: "mlx5_core"}))
+	assert.Equal(t, "interfaceName=eth1,macAddress=aa:bb:cc:dd:ee:ff", parentSelector(vlanmanv1.IPMapping{Interface: "eth1", MACAddress: "aa:bb:cc:dd:ee:ff"}))
+}
+
+func TestTeardownJob(t *testing.T) {
+	job := teardownJob("node1", "vlanman-system", 100, ServiceTag{ID: 300}, true, "interface:latest", "net1", "IfNotPresent")
+
+	assert.Equal(t, "delete-vlan-job-net1-node1", job.Name)
+	assert.Equal(t, "vlanman-system", job.Namespace)
+	assert.Equal(t, "net1", job.Labels[vlanmanv1.TeardownJobNetworkLabelKey])
+	assert.Equal(t, "net1", job.Spec.Template.Labels[vlanmanv1.TeardownJobNetworkLabelKey])
+	assert.Nil(t, job.Spec.TTLSecondsAfterFinished)
+	require.NotNil(t, job.Spec.ActiveDeadlineSeconds)
+	assert.Equal(t, int64(vlanmanv1.TeardownJobDeadlineSeconds), *job.Spec.ActiveDeadlineSeconds)
+	assert.True(t, job.Spec.Template.Spec.HostNetwork)
+	assert.Equal(t, map[string]string{"kubernetes.io/hostname": "node1"}, job.Spec.Template.Spec.NodeSelector)
+
+	container := job.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, "interface:latest", container.Image)
+	assert.Equal(t, []corev1.EnvVar{
+		{Name: "TEARDOWN", Value: "true"},
+		{Name: "ID", Value: "100"},
+		{Name: "SERVICE_ID", Value: "300"},
+		{Name: "REMOVE_SERVICE_VLAN", Value: "true"},
+	}, container.Env)
+	assert.Equal(t, []corev1.Capability{"NET_ADMIN", "NET_RAW"}, container.SecurityContext.Capabilities.Add)
+}
@@ -1,7 +1,10 @@
 package controller
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -10,6 +13,7 @@ import (
 	u "dialo.ai/vlanman/pkg/utils"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
@@ -17,15 +21,22 @@ import (
 type ManagerSet struct {
 	OwnerNetworkName string
 	VlanID           int64
+	ServiceTag       ServiceTag
 	Gateways         []vlanmanv1.Gateway
 	ManagerAffinity  *corev1.Affinity
 	Mappings         []vlanmanv1.IPMapping
+	Attachment       vlanmanv1.AttachmentMode
+	MTU              int
 }
 
 func managerCmp(a, b ManagerSet) int {
 	return strings.Compare(a.OwnerNetworkName, b.OwnerNetworkName)
 }
 
+// netnsCapabilities are added to containers that create links and move them between netns,
+// opening a netlink handle in another netns uses setns, which needs SYS_ADMIN
+var netnsCapabilities = []corev1.Capability{"NET_ADMIN", "NET_RAW", "SYS_ADMIN"}
+
 func daemonSetFromManager(mgr ManagerSet, e Envs) (appsv1.DaemonSet, error) {
 	poolsJSON, err := json.Marshal(mgr.Gateways)
 	if err != nil {
@@ -108,13 +119,34 @@ func daemonSetFromManager(mgr ManagerSet, e Envs) (appsv1.DaemonSet, error) {
 									Name:  "GATEWAYS",
 									Value: gateways,
 								},
+								{
+									Name:  "ATTACHMENT",
+									Value: string(mgr.Attachment.OrDefault()),
+								},
+								{
+									Name:  "MTU",
+									Value: strconv.Itoa(mgr.MTU),
+								},
+								{
+									Name:  "SERVICE_VLAN_ID",
+									Value: strconv.Itoa(mgr.ServiceTag.ID),
+								},
+								{
+									Name:  "SERVICE_VLAN_PROTOCOL",
+									Value: string(mgr.ServiceTag.Protocol.OrDefault()),
+								},
+								{
+									Name: "NODE_NAME",
+									ValueFrom: &corev1.EnvVarSource{
+										FieldRef: &corev1.ObjectFieldSelector{
+											FieldPath: "spec.nodeName",
+										},
+									},
+								},
 							},
 							SecurityContext: &corev1.SecurityContext{
 								Capabilities: &corev1.Capabilities{
-									Add: []corev1.Capability{
-										"NET_ADMIN",
-										"NET_RAW",
-									},
+									Add: slices.Clone(netnsCapabilities),
 								},
 							},
 						},
@@ -143,9 +175,40 @@ func daemonSetFromManager(mgr ManagerSet, e Envs) (appsv1.DaemonSet, error) {
 	if mgr.ManagerAffinity != nil {
 		spec.Spec.Template.Spec.Affinity = mgr.ManagerAffinity
 	}
+	hash, err := managerHash(spec)
+	if err != nil {
+		return appsv1.DaemonSet{}, err
+	}
+	spec.Annotations = map[string]string{vlanmanv1.ManagerSpecHashAnnotation: hash}
 	return spec, nil
 }
 
+// managerHash returns the hash of the labels and spec of a rendered DaemonSet,
+// every field set by the controller is included, e.g. the image and the sidecars
+func managerHash(ds appsv1.DaemonSet) (string, error) {
+	data, err := json.Marshal(struct {
+		Labels map[string]string
+		Spec   appsv1.DaemonSetSpec
+	}{ds.Labels, ds.Spec})
+	if err != nil {
+		return "", errs.NewParsingError("manager daemonset", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// managerUpToDate checks whether the live DaemonSet matches the desired one. The hash detects changes of
+// the desired state, manual edits of the fields set by the controller are found by comparing them
+// to the live object, fields defaulted by the API server are ignored.
+func managerUpToDate(desired, current appsv1.DaemonSet) bool {
+	hash := desired.Annotations[vlanmanv1.ManagerSpecHashAnnotation]
+	if current.Annotations[vlanmanv1.ManagerSpecHashAnnotation] != hash {
+		return false
+	}
+	return equality.Semantic.DeepDerivative(desired.Labels, current.Labels) &&
+		equality.Semantic.DeepDerivative(desired.Spec, current.Spec)
+}
+
 func serviceForManagerSet(d ManagerSet, namespace string) corev1.Service {
 	return corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
@@ -169,35 +232,6 @@ func serviceForManagerSet(d ManagerSet, namespace string) corev1.Service {
 	}
 }
 
-func managerFromSet(d appsv1.DaemonSet) (ManagerSet, error) {
-	managerAffinity := d.Spec.Template.Spec.Affinity
-
-	envs := d.Spec.Template.Spec.Containers[0].Env
-	var vlanID int64 = -1
-	gateways := []vlanmanv1.Gateway{}
-	for _, e := range envs {
-		switch e.Name {
-		case "VLAN_ID":
-			vlanID, _ = strconv.ParseInt(e.Value, 10, 64)
-		case "GATEWAYS":
-			err := json.Unmarshal([]byte(e.Value), &gateways)
-			if err != nil {
-				return ManagerSet{}, err
-			}
-		default:
-			continue
-		}
-	}
-
-	return ManagerSet{
-		OwnerNetworkName: d.Labels[vlanmanv1.ManagerSetLabelKey],
-		VlanID:           vlanID,
-		ManagerAffinity:  managerAffinity,
-		Mappings:         []vlanmanv1.IPMapping{},
-		Gateways:         gateways,
-	}, nil
-}
-
 func getPullPolicy(pp string) corev1.PullPolicy {
 	switch pp {
 	case "Always":
@@ -218,5 +252,11 @@ func createDesiredManagerSet(network vlanmanv1.VlanNetwork) ManagerSet {
 		Gateways:         network.Spec.Gateways,
 		ManagerAffinity:  network.Spec.ManagerAffinity,
 		Mappings:         network.Spec.Mappings,
+		Attachment:       network.Spec.Attachment.OrDefault(),
+		MTU:              network.Spec.MTU,
+		ServiceTag: ServiceTag{
+			ID:       network.Spec.ServiceVlanID,
+			Protocol: network.Spec.ServiceVlanProtocol.OrDefault(),
+		},
 	}
 }
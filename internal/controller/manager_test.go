@@ -4,94 +4,98 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	vlanmanv1 "dialo.ai/vlanman/api/v1"
+	u "dialo.ai/vlanman/pkg/utils"
 )
 
-func TestManagerFromDaemonSet(t *testing.T) {
+func TestManagerUpToDate(t *testing.T) {
+	env := Envs{NamespaceName: "vlanman-system", VlanManagerImage: "vlanman-manager:v1", VlanManagerPullPolicy: "IfNotPresent"}
+	net := vlanmanv1.VlanNetwork{
+		ObjectMeta: metav1.ObjectMeta{Name: "net1"},
+		Spec: vlanmanv1.VlanNetworkSpec{
+			VlanID:   100,
+			Gateways: []vlanmanv1.Gateway{{Address: "192.168.1.1/24"}},
+		},
+	}
+	render := func(net vlanmanv1.VlanNetwork, env Envs) appsv1.DaemonSet {
+		ds, err := daemonSetFromManager(createDesiredManagerSet(net), env)
+		require.NoError(t, err)
+		return ds
+	}
+	live := render(net, env)
+	// fields defaulted by the API server
+	live.Spec.RevisionHistoryLimit = u.Ptr(int32(10))
+	live.Spec.Template.Spec.RestartPolicy = corev1.RestartPolicyAlways
+	live.Spec.Template.Spec.Containers[0].TerminationMessagePath = corev1.TerminationMessagePathDefault
+
 	tests := []struct {
-		name        string
-		daemonSet   appsv1.DaemonSet
-		expectedMgr ManagerSet
+		name     string
+		desired  func() appsv1.DaemonSet
+		current  func() appsv1.DaemonSet
+		expected bool
 	}{
 		{
-			name: "basic daemonset conversion",
-			daemonSet: appsv1.DaemonSet{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      "manager-net1",
-					Namespace: "default",
-					Labels: map[string]string{
-						vlanmanv1.ManagerSetLabelKey: "net1",
-					},
-				},
-				Spec: appsv1.DaemonSetSpec{
-					Template: corev1.PodTemplateSpec{
-						Spec: corev1.PodSpec{
-							Containers: []corev1.Container{
-								{
-									Env: []corev1.EnvVar{
-										{
-											Name:  "VLAN_ID",
-											Value: "100",
-										},
-									},
-								},
-							},
-							Affinity: &corev1.Affinity{
-								NodeAffinity: &corev1.NodeAffinity{
-									RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
-										NodeSelectorTerms: []corev1.NodeSelectorTerm{
-											{
-												MatchExpressions: []corev1.NodeSelectorRequirement{
-													{
-														Key:      "kubernetes.io/hostname",
-														Operator: corev1.NodeSelectorOpNotIn,
-														Values:   []string{"excluded-node"},
-													},
-												},
-											},
-										},
-									},
-								},
-							},
-						},
-					},
-				},
+			name:     "unchanged",
+			desired:  func() appsv1.DaemonSet { return render(net, env) },
+			expected: true,
+		},
+		{
+			name: "mappings aren't rendered",
+			desired: func() appsv1.DaemonSet {
+				withMappings := *net.DeepCopy()
+				withMappings.Spec.Mappings = []vlanmanv1.IPMapping{{NodeName: "node1", Interface: "eth1"}}
+				return render(withMappings, env)
 			},
-			expectedMgr: ManagerSet{
-				OwnerNetworkName: "net1",
-				VlanID:           100,
-				ManagerAffinity: &corev1.Affinity{
-					NodeAffinity: &corev1.NodeAffinity{
-						RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
-							NodeSelectorTerms: []corev1.NodeSelectorTerm{
-								{
-									MatchExpressions: []corev1.NodeSelectorRequirement{
-										{
-											Key:      "kubernetes.io/hostname",
-											Operator: corev1.NodeSelectorOpNotIn,
-											Values:   []string{"excluded-node"},
-										},
-									},
-								},
-							},
-						},
-					},
-				},
-				Gateways: []vlanmanv1.Gateway{},
-				Mappings: []vlanmanv1.IPMapping{},
+			expected: true,
+		},
+		{
+			name: "image changed",
+			desired: func() appsv1.DaemonSet {
+				upgraded := env
+				upgraded.VlanManagerImage = "vlanman-manager:v2"
+				return render(net, upgraded)
+			},
+		},
+		{
+			name: "ip monitor sidecar enabled",
+			desired: func() appsv1.DaemonSet {
+				monitored := env
+				monitored.IsManagerIPMonitoringEnabled = true
+				return render(net, monitored)
+			},
+		},
+		{
+			name:    "edited manually",
+			desired: func() appsv1.DaemonSet { return render(net, env) },
+			current: func() appsv1.DaemonSet {
+				edited := *live.DeepCopy()
+				edited.Spec.Template.Spec.Containers[0].Env[0].Value = "200"
+				return edited
+			},
+		},
+		{
+			name:    "created without hash",
+			desired: func() appsv1.DaemonSet { return render(net, env) },
+			current: func() appsv1.DaemonSet {
+				old := *live.DeepCopy()
+				old.Annotations = nil
+				return old
 			},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := managerFromSet(tt.daemonSet)
-			assert.NoError(t, err)
-			assert.Equal(t, tt.expectedMgr, result)
+			current := live
+			if tt.current != nil {
+				current = tt.current()
+			}
+			assert.Equal(t, tt.expected, managerUpToDate(tt.desired(), current))
 		})
 	}
 }
@@ -134,6 +138,8 @@ func TestCreateDesiredManagerSet(t *testing.T) {
 			expectedManager: ManagerSet{
 				OwnerNetworkName: "test-network",
 				VlanID:           100,
+				ServiceTag:       ServiceTag{Protocol: vlanmanv1.VlanProtocol8021AD},
+				Attachment:       vlanmanv1.AttachmentMacvlanBridge,
 				ManagerAffinity:  nil,
 				Gateways: []vlanmanv1.Gateway{
 					{
@@ -197,6 +203,8 @@ func TestCreateDesiredManagerSet(t *testing.T) {
 			expectedManager: ManagerSet{
 				OwnerNetworkName: "complex-network",
 				VlanID:           200,
+				ServiceTag:       ServiceTag{Protocol: vlanmanv1.VlanProtocol8021AD},
+				Attachment:       vlanmanv1.AttachmentMacvlanBridge,
 				ManagerAffinity: &corev1.Affinity{
 					NodeAffinity: &corev1.NodeAffinity{
 						RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
@@ -247,6 +255,8 @@ func TestCreateDesiredManagerSet(t *testing.T) {
 			expectedManager: ManagerSet{
 				OwnerNetworkName: "minimal-network",
 				VlanID:           42,
+				ServiceTag:       ServiceTag{Protocol: vlanmanv1.VlanProtocol8021AD},
+				Attachment:       vlanmanv1.AttachmentMacvlanBridge,
 				ManagerAffinity:  nil,
 				Gateways:         nil,
 				Mappings:         nil,
@@ -283,6 +293,8 @@ func TestCreateDesiredManagerSet(t *testing.T) {
 			expectedManager: ManagerSet{
 				OwnerNetworkName: "zero-vlan-network",
 				VlanID:           1,
+				ServiceTag:       ServiceTag{Protocol: vlanmanv1.VlanProtocol8021AD},
+				Attachment:       vlanmanv1.AttachmentMacvlanBridge,
 				ManagerAffinity:  nil,
 				Gateways: []vlanmanv1.Gateway{
 					{
@@ -5,8 +5,12 @@ import (
 )
 
 type VlanNetworkState struct {
-	Status   map[string]vlanmanv1.ConnectionState
-	Mappings []vlanmanv1.IPMapping
-	VlanId   int
-	Name     string
+	Status     map[string]vlanmanv1.ConnectionState
+	Mappings   []vlanmanv1.IPMapping
+	VlanId     int
+	ServiceTag ServiceTag
+	MTU        int
+	Name       string
+	// Managers are the names of the manager pods of the network
+	Managers []string
 }
@@ -2,10 +2,13 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"maps"
 	"reflect"
 	"slices"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -15,16 +18,23 @@ import (
 
 	vlanmanv1 "dialo.ai/vlanman/api/v1"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/api/meta"
 	k8sRuntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 
 	errs "dialo.ai/vlanman/pkg/errors"
+	"dialo.ai/vlanman/pkg/ipam"
+	u "dialo.ai/vlanman/pkg/utils"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
@@ -57,6 +67,7 @@ type VlanmanReconciler struct {
 	Scheme         *k8sRuntime.Scheme
 	Env            Envs
 	Config         *rest.Config
+	Recorder       record.EventRecorder
 	reconciles     atomic.Int64
 	fullReconciles atomic.Int64
 }
@@ -88,55 +99,53 @@ func (e *ReconcileErrorList) Error() string {
 	return msg
 }
 
-func (r *VlanmanReconciler) getCurrentState(ctx context.Context) ([]ManagerSet, []VlanNetworkState, error) {
-	vlans := vlanmanv1.VlanNetworkList{}
-	err := r.Client.List(ctx, &vlans)
-	if err != nil {
+// getCurrentState returns the manager of the network and the state of its connection,
+// the connection state is missing if the network was deleted
+func (r *VlanmanReconciler) getCurrentState(ctx context.Context, network string) ([]appsv1.DaemonSet, []VlanNetworkState, error) {
+	connStates := []VlanNetworkState{}
+	conn := vlanmanv1.VlanNetwork{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: network}, &conn)
+	if err != nil && !apierrors.IsNotFound(err) {
 		return nil, nil, &errs.ClientRequestError{
-			Action: "List vlan networks",
+			Action: "Get vlan network",
 			Err:    err,
 		}
 	}
-	connStates := make([]VlanNetworkState, len(vlans.Items))
-	for _, conn := range vlans.Items {
-		connStates = append(connStates, VlanNetworkState{
-			Status:   conn.Status.State,
-			VlanId:   conn.Spec.VlanID,
+	if err == nil {
+		state := VlanNetworkState{
+			Status: conn.Status.State,
+			VlanId: conn.Spec.VlanID,
+			ServiceTag: ServiceTag{
+				ID:       conn.Spec.ServiceVlanID,
+				Protocol: conn.Spec.ServiceVlanProtocol,
+			},
+			MTU:      conn.Spec.MTU,
 			Mappings: conn.Spec.Mappings,
 			Name:     conn.Name,
-		})
+		}
+		managerPods := corev1.PodList{}
+		err = r.Client.List(ctx, &managerPods, client.InNamespace(r.Env.NamespaceName), client.MatchingLabels{vlanmanv1.ManagerSetLabelKey: network})
+		if err != nil {
+			return nil, nil, errs.NewClientRequestError("List manager pods", err)
+		}
+		for _, pod := range managerPods.Items {
+			if pod.DeletionTimestamp == nil {
+				state.Managers = append(state.Managers, pod.Name)
+			}
+		}
+		connStates = append(connStates, state)
 	}
 
 	managers := appsv1.DaemonSetList{}
-	selector := labels.NewSelector()
-	requirement, err := labels.NewRequirement(vlanmanv1.ManagerSetLabelKey, "exists", nil)
-	if err != nil {
-		return nil, nil, &errs.InternalError{
-			Context: fmt.Sprintf("Error creating a label selector requirement in createDesiredState: %s", err.Error()),
-		}
-	}
-	selector = selector.Add(*requirement)
-	opts := client.ListOptions{
-		LabelSelector: selector,
-	}
-	err = r.Client.List(ctx, &managers, &opts)
+	err = r.Client.List(ctx, &managers, client.MatchingLabels{vlanmanv1.ManagerSetLabelKey: network})
 	if err != nil {
 		return nil, nil, &errs.ClientRequestError{
-			Action: "List pods with field selector",
+			Action: "List manager daemonsets",
 			Err:    err,
 		}
 	}
 
-	// TODO: check if interfaces are created
-	mgrs := []ManagerSet{}
-	for _, m := range managers.Items {
-		newMgr, err := managerFromSet(m)
-		if err != nil {
-			return nil, nil, err
-		}
-		mgrs = append(mgrs, newMgr)
-	}
-	return mgrs, connStates, nil
+	return managers.Items, connStates, nil
 }
 
 func (r *VlanmanReconciler) createDesiredState(networks []vlanmanv1.VlanNetwork) []ManagerSet {
@@ -147,24 +156,17 @@ func (r *VlanmanReconciler) createDesiredState(networks []vlanmanv1.VlanNetwork)
 	return mgrs
 }
 
-func (r *VlanmanReconciler) diffManagers(desired, current ManagerSet, network VlanNetworkState) []Action {
-	// eq := true
-	// eq = eq && desired.OwnerNetworkName == current.OwnerNetworkName
-	// eq = eq && desired.VlanID == current.VlanID
-
-	// if !eq {
-	// 	return []Action{&DeleteManagerAction{Manager: current, OwnerNetwork: network}, &CreateManagerAction{Manager: desired, OwnerNetwork: network}}
-	// }
-	// if !reflect.DeepEqual(current.ManagerAffinity, desired.ManagerAffinity) {
-	// 	return []Action{&DeleteManagerAction{Manager: current, OwnerNetwork: network}, &CreateManagerAction{Manager: desired, OwnerNetwork: network}}
-	// }
-	if !reflect.DeepEqual(desired, current) {
+// diffManagers updates the DaemonSet if it doesn't match the one rendered from the desired manager.
+// If rendering fails the update is returned anyway, UpdateManagerAction renders the DaemonSet again and reports the error.
+func (r *VlanmanReconciler) diffManagers(desired ManagerSet, current appsv1.DaemonSet, network VlanNetworkState) []Action {
+	desiredDs, err := daemonSetFromManager(desired, r.Env)
+	if err != nil || !managerUpToDate(desiredDs, current) {
 		return []Action{&UpdateManagerAction{Manager: desired, OwnerNetwork: network}}
 	}
 	return []Action{}
 }
 
-func (r *VlanmanReconciler) diffStates(desired, current []ManagerSet, currentConns []VlanNetworkState) []Action {
+func (r *VlanmanReconciler) diffStates(desired []ManagerSet, current []appsv1.DaemonSet, currentConns []VlanNetworkState) []Action {
 	acts := []Action{}
 
 	for _, conn := range currentConns {
@@ -176,17 +178,30 @@ func (r *VlanmanReconciler) diffStates(desired, current []ManagerSet, currentCon
 				})
 			}
 		}
+		// new manager pods don't have a state until their interface is up
+		for _, podName := range conn.Managers {
+			if _, ok := conn.Status[podName]; !ok {
+				acts = append(acts, &SpawnInterfaceAction{
+					PodName:      podName,
+					OwnerNetwork: conn,
+				})
+			}
+		}
 	}
 
 	// sort for searching
 	slices.SortFunc(desired, managerCmp)
-	slices.SortFunc(current, managerCmp)
+	slices.SortFunc(current, func(a, b appsv1.DaemonSet) int {
+		return strings.Compare(daemonSetOwner(a), daemonSetOwner(b))
+	})
 	slices.SortFunc(currentConns, func(a, b VlanNetworkState) int {
 		return strings.Compare(a.Name, b.Name)
 	})
 
 	for _, desiredMgr := range desired {
-		idx, found := slices.BinarySearchFunc(current, desiredMgr, managerCmp)
+		idx, found := slices.BinarySearchFunc(current, desiredMgr.OwnerNetworkName, func(ds appsv1.DaemonSet, name string) int {
+			return strings.Compare(daemonSetOwner(ds), name)
+		})
 		netIdx := -1
 		for i, ns := range currentConns {
 			if ns.Name == desiredMgr.OwnerNetworkName {
@@ -206,45 +221,83 @@ func (r *VlanmanReconciler) diffStates(desired, current []ManagerSet, currentCon
 		acts = append(acts, r.diffManagers(desiredMgr, current[idx], currentConns[netIdx])...)
 	}
 
-	for _, currentMgr := range current {
-		_, found := slices.BinarySearchFunc(desired, currentMgr, managerCmp)
+	for _, currentDs := range current {
+		owner := ManagerSet{OwnerNetworkName: daemonSetOwner(currentDs)}
+		_, found := slices.BinarySearchFunc(desired, owner, managerCmp)
 		if !found {
-			acts = append(acts, &DeleteManagerAction{Manager: currentMgr})
+			acts = append(acts, &DeleteManagerAction{Manager: owner})
 		}
 	}
 	return acts
 }
 
-func (r *VlanmanReconciler) reconcileNetwork(ctx context.Context) (*time.Duration, error) {
+func daemonSetOwner(ds appsv1.DaemonSet) string {
+	return ds.Labels[vlanmanv1.ManagerSetLabelKey]
+}
+
+// reconcileNetwork brings the managers of one network to the desired state,
+// they're deleted if the network doesn't exist anymore
+func (r *VlanmanReconciler) reconcileNetwork(ctx context.Context, name string) (*time.Duration, error) {
 	log := log.FromContext(ctx)
 
 	errList := []*ReconcileError{}
 
-	networkList := &vlanmanv1.VlanNetworkList{}
-	err := r.Client.List(ctx, networkList)
-	if err != nil {
+	networks := []vlanmanv1.VlanNetwork{}
+	network := vlanmanv1.VlanNetwork{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: name}, &network)
+	if err != nil && !apierrors.IsNotFound(err) {
 		return nil, &errs.ClientRequestError{
-			Action: "List VlanNetworks",
+			Action: "Get VlanNetwork",
 			Err:    err,
 		}
 	}
+	if err == nil {
+		if network.DeletionTimestamp != nil {
+			return r.finalizeNetwork(ctx, &network)
+		}
+		if !controllerutil.ContainsFinalizer(&network, vlanmanv1.NetworkFinalizer) {
+			patch := client.MergeFrom(network.DeepCopy())
+			controllerutil.AddFinalizer(&network, vlanmanv1.NetworkFinalizer)
+			err = r.Client.Patch(ctx, &network, patch)
+			if err != nil {
+				return nil, errs.NewClientRequestError("Add finalizer to VlanNetwork", err)
+			}
+		}
+		networks = append(networks, network)
+	}
 
-	desired := r.createDesiredState(networkList.Items)
+	desired := r.createDesiredState(networks)
 
-	currentMgrs, currentConns, err := r.getCurrentState(ctx)
+	currentMgrs, currentConns, err := r.getCurrentState(ctx, name)
 	if err != nil {
 		return nil, err
 	}
 
 	actions := r.diffStates(desired, currentMgrs, currentConns)
+	for _, net := range networks {
+		recreate, err := r.diffParents(ctx, net)
+		if err != nil {
+			return nil, err
+		}
+		rollout, err := r.rolloutManagers(ctx, net, recreate)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, rollout...)
+	}
 
+	var rq *time.Duration
 	for _, action := range actions {
 		log.Info("Doing action", "type", reflect.TypeOf(action))
-		err := action.Do(ctx, r)
+		after, err := action.Do(ctx, r)
 		if err != nil {
 			recErr := &ReconcileError{Action: reflect.TypeOf(action), Err: err}
 			errList = append(errList, recErr)
 			log.Error(recErr, "Error reconciling")
+			r.actionFailedEvent(ctx, action, err)
+		}
+		if after != nil && (rq == nil || *after < *rq) {
+			rq = after
 		}
 	}
 
@@ -254,27 +307,307 @@ func (r *VlanmanReconciler) reconcileNetwork(ctx context.Context) (*time.Duratio
 		}
 	}
 
-	return nil, err
+	return rq, nil
 }
 
-func extractVlan(pod corev1.Pod) (string, bool) {
-	subnet := ""
-	ip := ""
-	for _, cont := range pod.Spec.InitContainers {
-		for _, e := range cont.Env {
-			if e.Name == "MACVLAN_IP" {
-				ip = e.Value
+// finalizeNetwork deletes the manager DaemonSet, its service and the interface jobs of a deleted network.
+// The vlan interfaces live in the netns of the manager pods, the finalizer is removed once the pods are gone
+// and teardown jobs removed what was left in the host netns of their nodes.
+func (r *VlanmanReconciler) finalizeNetwork(ctx context.Context, net *vlanmanv1.VlanNetwork) (*time.Duration, error) {
+	log := log.FromContext(ctx)
+	if !controllerutil.ContainsFinalizer(net, vlanmanv1.NetworkFinalizer) {
+		return nil, nil
+	}
+
+	_, err := (&DeleteManagerAction{Manager: createDesiredManagerSet(*net)}).Do(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := batchv1.JobList{}
+	err = r.Client.List(ctx, &jobs, client.InNamespace(r.Env.NamespaceName), client.MatchingLabels{
+		vlanmanv1.InterfaceJobNetworkLabelKey: net.Name,
+	})
+	if err != nil {
+		return nil, errs.NewClientRequestError("List interface jobs", err)
+	}
+	for _, job := range jobs.Items {
+		if job.DeletionTimestamp != nil {
+			continue
+		}
+		err = r.Client.Delete(ctx, &job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, errs.NewClientRequestError(fmt.Sprintf("Delete interface job %s", job.Name), err)
+		}
+	}
+
+	pods := corev1.PodList{}
+	err = r.Client.List(ctx, &pods, client.InNamespace(r.Env.NamespaceName), client.MatchingLabels{
+		vlanmanv1.ManagerSetLabelKey: net.Name,
+	})
+	if err != nil {
+		return nil, errs.NewClientRequestError("List manager pods", err)
+	}
+	if len(pods.Items) != 0 {
+		log.Info("Waiting for manager pods to terminate before removing finalizer", "network", net.Name, "pods", len(pods.Items))
+		return requeue(), nil
+	}
+
+	done, err := r.teardownNodes(ctx, net)
+	if err != nil {
+		return nil, err
+	}
+	if !done {
+		log.Info("Waiting for teardown jobs to finish before removing finalizer", "network", net.Name)
+		return requeue(), nil
+	}
+
+	patch := client.MergeFrom(net.DeepCopy())
+	controllerutil.RemoveFinalizer(net, vlanmanv1.NetworkFinalizer)
+	err = r.Client.Patch(ctx, net, patch)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, errs.NewClientRequestError("Remove finalizer from VlanNetwork", err)
+	}
+	log.Info("Network cleaned up", "network", net.Name)
+	return nil, nil
+}
+
+// teardownNodes runs a teardown job on each node of the network's status and reports their results once
+// all of them finished. The status isn't updated after deletion, so it lists the nodes that had a manager.
+func (r *VlanmanReconciler) teardownNodes(ctx context.Context, net *vlanmanv1.VlanNetwork) (bool, error) {
+	networks := vlanmanv1.VlanNetworkList{}
+	err := r.Client.List(ctx, &networks)
+	if err != nil {
+		return false, errs.NewClientRequestError("List VlanNetworks", err)
+	}
+	// the service vlan is shared by networks with the same service tag
+	removeServiceVlan := !slices.ContainsFunc(networks.Items, func(n vlanmanv1.VlanNetwork) bool {
+		return n.Name != net.Name && n.Spec.ServiceVlanID == net.Spec.ServiceVlanID
+	})
+	serviceTag := ServiceTag{ID: net.Spec.ServiceVlanID, Protocol: net.Spec.ServiceVlanProtocol}
+
+	pending := false
+	finished := []batchv1.Job{}
+	for _, nodeName := range slices.Sorted(maps.Keys(net.Status.Nodes)) {
+		err := r.Client.Get(ctx, types.NamespacedName{Name: nodeName}, &corev1.Node{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return false, errs.NewClientRequestError(fmt.Sprintf("Get node %s", nodeName), err)
+		}
+
+		job := teardownJob(nodeName, r.Env.NamespaceName, net.Spec.VlanID, serviceTag, removeServiceVlan, r.Env.InterfacePodImage, net.Name, r.Env.InterfacePodPullPolicy)
+		existing := batchv1.Job{}
+		err = r.Client.Get(ctx, types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, &existing)
+		if apierrors.IsNotFound(err) {
+			err = controllerutil.SetControllerReference(net, &job, r.Scheme)
+			if err != nil {
+				return false, &errs.InternalError{Context: fmt.Sprintf("Couldn't set owner reference of %s: %s", job.Name, err)}
+			}
+			err = r.Client.Create(ctx, &job)
+			if err != nil && !apierrors.IsAlreadyExists(err) {
+				return false, errs.NewClientRequestError("Create teardown job", err)
+			}
+			pending = true
+			continue
+		}
+		if err != nil {
+			return false, errs.NewClientRequestError("Get teardown job", err)
+		}
+		if _, ok := jobFinished(existing); !ok {
+			pending = true
+			continue
+		}
+		finished = append(finished, existing)
+	}
+	if pending {
+		return false, nil
+	}
+
+	for _, job := range finished {
+		r.teardownEvent(ctx, net, job)
+	}
+	return true, nil
+}
+
+// teardownEvent records the result of a teardown job on the network,
+// the job reports what it removed in its termination message
+func (r *VlanmanReconciler) teardownEvent(ctx context.Context, net *vlanmanv1.VlanNetwork, job batchv1.Job) {
+	node := job.Spec.Template.Spec.NodeSelector["kubernetes.io/hostname"]
+	pods := corev1.PodList{}
+	err := r.Client.List(ctx, &pods, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name})
+	if err != nil {
+		log.FromContext(ctx).Error(errs.NewClientRequestError("List teardown job pods", err), "Couldn't get teardown result", "job", job.Name)
+	}
+	msg := ""
+	for _, pod := range pods.Items {
+		for _, cs := range pod.Status.ContainerStatuses {
+			if term := cs.State.Terminated; term != nil && term.Message != "" {
+				msg = term.Message
+			}
+		}
+	}
+	if failed, _ := jobFinished(job); failed {
+		if msg == "" {
+			msg = "job failed"
+		}
+		r.event(net, corev1.EventTypeWarning, vlanmanv1.EventReasonTeardownFailed, "Couldn't remove vlan interfaces from node %s: %s", node, msg)
+		return
+	}
+	r.event(net, corev1.EventTypeNormal, vlanmanv1.EventReasonTeardownComplete, "Removed vlan interfaces from node %s: %s", node, msg)
+}
+
+// diffParents resolves the mappings again for nodes running a manager pod and returns
+// the pods whose parent interface changed, e.g. after the labels of a node or the mappings changed
+func (r *VlanmanReconciler) diffParents(ctx context.Context, net vlanmanv1.VlanNetwork) ([]*RecreateManagerPodAction, error) {
+	acts := []*RecreateManagerPodAction{}
+	nodes := map[string]corev1.Node{}
+	pods := corev1.PodList{}
+	err := r.Client.List(ctx, &pods, client.InNamespace(r.Env.NamespaceName), client.MatchingLabels{
+		vlanmanv1.ManagerSetLabelKey: net.Name,
+	})
+	if err != nil {
+		return nil, errs.NewClientRequestError("List manager pods", err)
+	}
+	for _, pod := range pods.Items {
+		current, ok := pod.Annotations[vlanmanv1.ManagerParentInterfaceAnnotation]
+		if !ok || pod.Spec.NodeName == "" || pod.DeletionTimestamp != nil {
+			continue
+		}
+		node, ok := nodes[pod.Spec.NodeName]
+		if !ok {
+			err = r.Client.Get(ctx, types.NamespacedName{Name: pod.Spec.NodeName}, &node)
+			if apierrors.IsNotFound(err) {
+				continue
 			}
-			if e.Name == "MACVLAN_SUBNET" {
-				subnet = e.Value
+			if err != nil {
+				return nil, errs.NewClientRequestError(fmt.Sprintf("Get node %s", pod.Spec.NodeName), err)
 			}
+			nodes[pod.Spec.NodeName] = node
+		}
+		mapping, _, err := resolveMapping(node, net.Spec.Mappings)
+		if err != nil {
+			return nil, err
+		}
+		if parentSelector(mapping) != current {
+			acts = append(acts, &RecreateManagerPodAction{OwnerNetwork: net.Name, PodName: pod.Name, From: current, To: parentSelector(mapping)})
 		}
 	}
-	fullIp := ip
-	if subnet != "" {
-		fullIp += "/" + subnet
+	return acts, nil
+}
+
+// rolloutManagers returns the actions recreating outdated manager pods. The DaemonSet uses the OnDelete
+// strategy, a pod is outdated when it was created from an older template, e.g. after an upgrade of the
+// operator image, or its parent interface changed. Pods whose vlan interface isn't up are recreated right
+// away, they don't carry traffic. Otherwise a single pod is recreated once every manager pod is up, so the
+// network stays available on the other nodes, and the pod holding the gateway addresses goes last
+// so the gateway moves only once.
+func (r *VlanmanReconciler) rolloutManagers(ctx context.Context, net vlanmanv1.VlanNetwork, recreate []*RecreateManagerPodAction) ([]Action, error) {
+	ds := appsv1.DaemonSet{}
+	dsName := strings.Join([]string{vlanmanv1.ManagerSetNamePrefix, net.Name}, "-")
+	err := r.Client.Get(ctx, types.NamespacedName{Name: dsName, Namespace: r.Env.NamespaceName}, &ds)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
 	}
-	return fullIp, ip != ""
+	if err != nil {
+		return nil, errs.NewClientRequestError("Get manager daemonset", err)
+	}
+	pods := corev1.PodList{}
+	err = r.Client.List(ctx, &pods, client.InNamespace(r.Env.NamespaceName), client.MatchingLabels{
+		vlanmanv1.ManagerSetLabelKey: net.Name,
+	})
+	if err != nil {
+		return nil, errs.NewClientRequestError("List manager pods", err)
+	}
+
+	outdated := map[string]Action{}
+	for _, a := range recreate {
+		outdated[a.PodName] = a
+	}
+	generation := ds.Annotations[appsv1.DeprecatedTemplateGeneration]
+	vlanID := containerEnv(ds.Spec.Template.Spec.Containers, "VLAN_ID")
+	settled := ds.Status.ObservedGeneration >= ds.Generation
+	running := 0
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil {
+			settled = false
+			continue
+		}
+		running++
+		if net.Status.State[pod.Name] != vlanmanv1.StateUp {
+			settled = false
+		}
+		podGeneration := pod.Labels[vlanmanv1.ManagerTemplateGenerationLabelKey]
+		if _, ok := outdated[pod.Name]; ok || generation == "" || podGeneration == generation {
+			continue
+		}
+		outdated[pod.Name] = &RolloutManagerPodAction{
+			OwnerNetwork: net.Name,
+			PodName:      pod.Name,
+			NodeName:     pod.Spec.NodeName,
+			From:         podGeneration,
+			To:           generation,
+			// the vlan interface can be reused by the replacement unless the vlan changed
+			Handover: containerEnv(pod.Spec.Containers, "VLAN_ID") == vlanID,
+		}
+	}
+	if running < int(ds.Status.DesiredNumberScheduled) {
+		settled = false
+	}
+
+	names := slices.Sorted(maps.Keys(outdated))
+	acts := []Action{}
+	for _, name := range names {
+		if net.Status.State[name] != vlanmanv1.StateUp {
+			acts = append(acts, outdated[name])
+		}
+	}
+	if len(acts) != 0 || !settled || len(names) == 0 {
+		return acts, nil
+	}
+
+	leader, err := r.gatewayLeader(ctx, net)
+	if err != nil {
+		return nil, err
+	}
+	next := names[0]
+	if next == leader && len(names) > 1 {
+		next = names[1]
+	}
+	return []Action{outdated[next]}, nil
+}
+
+// gatewayLeader returns the name of the manager pod holding the gateway addresses,
+// empty if the network has no gateways or no leader was elected yet
+func (r *VlanmanReconciler) gatewayLeader(ctx context.Context, net vlanmanv1.VlanNetwork) (string, error) {
+	if len(net.Spec.Gateways) == 0 {
+		return "", nil
+	}
+	lease := coordinationv1.Lease{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: vlanmanv1.LeaderElectionLeaseName + "-" + net.Name, Namespace: r.Env.NamespaceName}, &lease)
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errs.NewClientRequestError("Get gateway leader lease", err)
+	}
+	if lease.Spec.HolderIdentity == nil {
+		return "", nil
+	}
+	return *lease.Spec.HolderIdentity, nil
+}
+
+func containerEnv(containers []corev1.Container, name string) string {
+	if len(containers) == 0 {
+		return ""
+	}
+	for _, e := range containers[0].Env {
+		if e.Name == name {
+			return e.Value
+		}
+	}
+	return ""
 }
 
 func poolName(name string) func(vlanmanv1.VlanNetworkPool) bool {
@@ -284,20 +617,16 @@ func poolName(name string) func(vlanmanv1.VlanNetworkPool) bool {
 }
 
 func (r *VlanmanReconciler) updateVlanNetworkStatus(ctx context.Context, net *vlanmanv1.VlanNetwork) (*time.Duration, error) {
-	if net.Status.FreeIPs == nil {
-		net.Status.FreeIPs = map[string][]string{}
-	}
-	if net.Status.PendingIPs == nil {
-		net.Status.PendingIPs = map[string]map[string]string{}
-	}
-	if net.Status.State == nil {
-		net.Status.State = map[string]vlanmanv1.ConnectionState{}
-	}
+	log := log.FromContext(ctx)
+	net.Status = u.PopulateStatus(net.Status)
 	daemons := corev1.PodList{}
-	err := r.Client.List(ctx, &daemons, &client.ListOptions{
-		Namespace: r.Env.NamespaceName,
+	err := r.Client.List(ctx, &daemons, client.InNamespace(r.Env.NamespaceName), client.MatchingLabels{
+		vlanmanv1.ManagerSetLabelKey: net.Name,
 	})
-	daemonNames := make([]string, len(daemons.Items))
+	if err != nil {
+		return nil, errs.NewClientRequestError("List manager pods in updateVlanNetworkStatus", err)
+	}
+	daemonNames := []string{}
 	for _, it := range daemons.Items {
 		daemonNames = append(daemonNames, it.Name)
 	}
@@ -306,122 +635,641 @@ func (r *VlanmanReconciler) updateVlanNetworkStatus(ctx context.Context, net *vl
 		return !slices.Contains(daemonNames, k)
 	})
 
-	for name := range net.Status.FreeIPs {
-		if !slices.ContainsFunc(net.Spec.Pools, poolName(name)) {
-			delete(net.Status.FreeIPs, name)
-			delete(net.Status.PendingIPs, name)
-		}
+	maps.DeleteFunc(net.Status.FreeIPCount, func(name string, _ int64) bool {
+		return !slices.ContainsFunc(net.Spec.Pools, poolName(name))
+	})
+
+	allocs, err := ipam.ListAllocations(ctx, r.Client, net.Name, "")
+	if err != nil {
+		return nil, err
 	}
 
-	for _, pool := range net.Spec.Pools {
-		if net.Status.FreeIPs[pool.Name] == nil {
-			net.Status.FreeIPs[pool.Name] = []string{}
+	// allocations are checked periodically, pods that finished while the
+	// controller wasn't running don't get another event
+	gcInterval := time.Second * vlanmanv1.AllocationGCIntervalSeconds
+	requeueIn := &gcInterval
+	delay := time.Second * time.Duration(net.Spec.AddressReleaseDelaySeconds)
+	allocs = slices.DeleteFunc(allocs, func(a vlanmanv1.VlanIPAllocation) bool {
+		if a.DeletionTimestamp != nil {
+			expiresIn, err := r.expireAllocation(ctx, &a, delay)
+			if err != nil {
+				log.Error(err, "Couldn't release allocation", "allocation", a.Name, "namespace", a.Namespace)
+				return false
+			}
+			if expiresIn > 0 {
+				if expiresIn < *requeueIn {
+					requeueIn = &expiresIn
+				}
+				return false
+			}
+			return true
+		}
+		if ipam.IsSticky(a) {
+			deleted, err := r.reconcileStickyAllocation(ctx, &a)
+			if err != nil {
+				log.Error(err, "Couldn't reconcile sticky allocation", "allocation", a.Name, "namespace", a.Namespace)
+			}
+			return deleted
+		}
+		if ipam.IsBound(a) {
+			released, err := r.collectAllocation(ctx, &a)
+			if err != nil {
+				log.Error(err, "Couldn't release allocation of a pod that's gone", "allocation", a.Name, "namespace", a.Namespace)
+				return false
+			}
+			// allocations with the release finalizer stay until the delay passed
+			return released && !controllerutil.ContainsFinalizer(&a, vlanmanv1.AllocationFinalizer)
+		}
+		expiresIn := time.Until(a.CreationTimestamp.Add(time.Second * vlanmanv1.AllocationBindTimeoutSeconds))
+		if expiresIn > 0 {
+			if expiresIn < *requeueIn {
+				requeueIn = &expiresIn
+			}
+			return false
+		}
+		// the pod event binding the allocation might have been missed
+		pod, err := r.podOfAllocation(ctx, &a)
+		if err != nil {
+			log.Error(err, "Couldn't look up the pod of an unbound allocation", "allocation", a.Name, "namespace", a.Namespace)
+			return false
+		}
+		if pod != nil {
+			err = r.bindOneAllocation(ctx, pod, a.Name)
+			if err != nil {
+				log.Error(err, "Couldn't bind allocation to its pod", "allocation", a.Name, "namespace", a.Namespace, "pod", pod.Name)
+			}
+			return false
+		}
+		log.Info("Deleting allocation that was never bound to a pod", "allocation", a.Name, "namespace", a.Namespace, "addresses", a.Spec.Addresses)
+		err = r.Client.Delete(ctx, &a)
+		if err != nil && !apierrors.IsNotFound(err) {
+			log.Error(errs.NewClientRequestError("Delete expired VlanIPAllocation", err), "Couldn't delete allocation", "allocation", a.Name)
+			return false
 		}
-		net.Status.FreeIPs[pool.Name] = slices.Clone(pool.Addresses)
+		return true
+	})
+	taken := ipam.Taken(allocs)
 
-		if net.Status.PendingIPs[pool.Name] == nil {
-			net.Status.PendingIPs[pool.Name] = map[string]string{}
+	net.Status.QuarantinedAddresses = slices.DeleteFunc(net.Status.QuarantinedAddresses, func(q vlanmanv1.QuarantinedAddress) bool {
+		return !ipam.InQuarantine(q)
+	})
+	for _, q := range net.Status.QuarantinedAddresses {
+		expiresIn := time.Until(q.LastDetected.Add(time.Second * vlanmanv1.AddressQuarantineSeconds))
+		if expiresIn < *requeueIn {
+			requeueIn = &expiresIn
 		}
 	}
+	maps.Copy(taken, ipam.Quarantined(net.Status.QuarantinedAddresses))
 
-	podlist := &corev1.PodList{}
-	requirement, err := labels.NewRequirement(vlanmanv1.WorkerPodLabelKey, "==", []string{net.Name})
+	err = r.collectNodes(ctx, net)
 	if err != nil {
-		return nil, &errs.InternalError{
-			Context: fmt.Sprintf("Error compiling the label selector requirement for listing worker pods while updating status: %s", err.Error()),
+		return nil, err
+	}
+
+	for _, pool := range net.Spec.Pools {
+		p, err := ipam.NewPool(pool)
+		if err != nil {
+			log.Error(errs.NewParsingError("pool addresses in updateVlanNetworkStatus", err), "Skipping free IP count", "network", net.Name, "pool", pool.Name)
+			continue
 		}
+		net.Status.FreeIPCount[pool.Name] = p.Free(taken)
 	}
-	selector := labels.NewSelector().Add(*requirement)
-	err = r.Client.List(ctx, podlist, &client.ListOptions{
-		LabelSelector: selector,
-	})
+
+	err = r.updateConditions(ctx, net)
 	if err != nil {
-		err = errs.NewClientRequestError("Error listing pods in updateVlanNetworkStatus", err)
 		return nil, err
 	}
-	podsWithAnnotation := podlist.Items
+	return requeueIn, nil
+}
+
+// collectNodes copies the parent interfaces reported by interface jobs to the status of their node,
+// nodes without a manager pod are removed
+func (r *VlanmanReconciler) collectNodes(ctx context.Context, net *vlanmanv1.VlanNetwork) error {
+	if net.Status.Nodes == nil {
+		net.Status.Nodes = map[string]vlanmanv1.NodeAttachment{}
+	}
+	managers := corev1.PodList{}
+	err := r.Client.List(ctx, &managers, client.InNamespace(r.Env.NamespaceName), client.MatchingLabels{
+		vlanmanv1.ManagerSetLabelKey: net.Name,
+	})
+	if err != nil {
+		return errs.NewClientRequestError("List manager pods", err)
+	}
+	// jobs finished before the manager pod was created reported the interface of its predecessor
+	created := map[string]time.Time{}
+	for _, m := range managers.Items {
+		if m.Spec.NodeName == "" {
+			continue
+		}
+		created[m.Spec.NodeName] = m.CreationTimestamp.Time
+		n := net.Status.Nodes[m.Spec.NodeName]
+		if n.Pod != m.Name {
+			// the manager pod was replaced, its interface wasn't reported yet
+			n = vlanmanv1.NodeAttachment{Pod: m.Name, LastError: n.LastError, LastTransitionTime: metav1.Now()}
+		}
+		net.Status.Nodes[m.Spec.NodeName] = n
+	}
+	maps.DeleteFunc(net.Status.Nodes, func(node string, _ vlanmanv1.NodeAttachment) bool {
+		_, ok := created[node]
+		return !ok
+	})
 
-	// TODO: if it turns out it's taking too long we can reverse sort by
-	// timestamp and stop after we reach the first still valid
-	for name := range net.Status.PendingIPs {
-		maps.DeleteFunc(net.Status.PendingIPs[name], func(ip string, timestamp string) bool {
-			ts, err := time.Parse(time.Layout, timestamp)
+	jobPods := corev1.PodList{}
+	err = r.Client.List(ctx, &jobPods, client.InNamespace(r.Env.NamespaceName), client.MatchingLabels{
+		vlanmanv1.InterfaceJobNetworkLabelKey: net.Name,
+	})
+	if err != nil {
+		return errs.NewClientRequestError("List interface job pods", err)
+	}
+	finished := maps.Clone(created)
+	for _, pod := range jobPods.Items {
+		n, ok := net.Status.Nodes[pod.Spec.NodeName]
+		if !ok {
+			continue
+		}
+		if pod.Status.Phase == corev1.PodFailed && pod.CreationTimestamp.Time.After(created[pod.Spec.NodeName]) {
+			// the last error is compared so that the event is only recorded once
+			msg := fmt.Sprintf("Interface job pod %s failed on node %s: %s", pod.Name, pod.Spec.NodeName, jobFailure(pod))
+			if n.LastError != msg {
+				n.LastError = msg
+				net.Status.Nodes[pod.Spec.NodeName] = n
+				r.event(net, corev1.EventTypeWarning, vlanmanv1.EventReasonInterfaceJobFailed, msg)
+			}
+			continue
+		}
+		if pod.Status.Phase != corev1.PodSucceeded {
+			continue
+		}
+		for _, cs := range pod.Status.ContainerStatuses {
+			term := cs.State.Terminated
+			if term == nil || term.Message == "" || term.FinishedAt.Time.Before(finished[pod.Spec.NodeName]) {
+				continue
+			}
+			parent := vlanmanv1.ParentInterface{}
+			err := json.Unmarshal([]byte(term.Message), &parent)
 			if err != nil {
-				err = errs.NewParsingError(fmt.Sprintf("Pending IP timestamp '%s' in updateVlanNetworkStatus", timestamp), err)
-				return true
+				log.FromContext(ctx).Error(errs.NewParsingError("interface job termination message", err), "Skipping parent interface", "pod", pod.Name)
+				continue
 			}
-			contains := slices.ContainsFunc(podsWithAnnotation, func(p corev1.Pod) bool {
-				extractedIP, found := extractVlan(p)
-				cutVip, _, _ := strings.Cut(extractedIP, "/")
-				cutIp, _, _ := strings.Cut(ip, "/")
-
-				return found && cutIp == cutVip
-			})
-			timePassed := time.Now().After(ts.Add(time.Second * time.Duration(vlanmanv1.ReconcilerPendingIPsTimeoutSeconds)))
-			return contains || timePassed
-		})
+			n.Parent = &parent
+			net.Status.Nodes[pod.Spec.NodeName] = n
+			finished[pod.Spec.NodeName] = term.FinishedAt.Time
+		}
 	}
+	return nil
+}
 
-	podIpList := []string{}
-	for _, p := range podsWithAnnotation {
-		ip, found := extractVlan(p)
-		cutIp, _, _ := strings.Cut(ip, "/")
-		if found {
-			podIpList = append(podIpList, cutIp)
+// jobFailure returns the reason an interface job pod failed
+func jobFailure(pod corev1.Pod) string {
+	for _, cs := range pod.Status.ContainerStatuses {
+		if term := cs.State.Terminated; term != nil && term.ExitCode != 0 {
+			if term.Message != "" {
+				return term.Message
+			}
+			return fmt.Sprintf("exit code %d", term.ExitCode)
 		}
 	}
+	return pod.Status.Reason
+}
+
+// event records an event if the reconciler has a recorder, tests don't set one
+func (r *VlanmanReconciler) event(obj k8sRuntime.Object, eventType, reason, msgFmt string, args ...any) {
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Eventf(obj, eventType, reason, msgFmt, args...)
+}
+
+// networkEvent records an event on the network with the name
+func (r *VlanmanReconciler) networkEvent(ctx context.Context, name, eventType, reason, msgFmt string, args ...any) {
+	if r.Recorder == nil || name == "" {
+		return
+	}
+	net := vlanmanv1.VlanNetwork{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: name}, &net)
+	if err != nil {
+		log.FromContext(ctx).Error(errs.NewClientRequestError("Get VlanNetwork for event", err), "Couldn't record event", "network", name, "reason", reason)
+		return
+	}
+	r.Recorder.Eventf(&net, eventType, reason, msgFmt, args...)
+}
+
+// actionFailedEvent records the error of an action on its network
+func (r *VlanmanReconciler) actionFailedEvent(ctx context.Context, action Action, err error) {
+	network := ""
+	reason := vlanmanv1.EventReasonReconcileFailed
+	switch a := action.(type) {
+	case *CreateManagerAction:
+		network = a.Manager.OwnerNetworkName
+	case *UpdateManagerAction:
+		network = a.Manager.OwnerNetworkName
+	case *DeleteManagerAction:
+		network = a.Manager.OwnerNetworkName
+	case *SpawnInterfaceAction:
+		network = a.OwnerNetwork.Name
+		reason = vlanmanv1.EventReasonInterfaceJobFailed
+	case *RecreateManagerPodAction:
+		network = a.OwnerNetwork
+	}
+	if errors.Is(err, ErrDaemonPodTimeout) {
+		reason = vlanmanv1.EventReasonManagerTimeout
+	}
+	r.networkEvent(ctx, network, corev1.EventTypeWarning, reason, "%s failed: %s", reflect.TypeOf(action).Elem().Name(), err)
+}
 
-	for pn := range net.Status.FreeIPs {
-		if net.Status.FreeIPs[pn] == nil {
-			net.Status.FreeIPs[pn] = []string{}
+// updateConditions sets the conditions of the network from the manager DaemonSet,
+// the state of the vlan interfaces and the free address counts
+func (r *VlanmanReconciler) updateConditions(ctx context.Context, net *vlanmanv1.VlanNetwork) error {
+	gen := net.Generation
+	net.Status.ObservedGeneration = gen
+	previous := slices.Clone(net.Status.Conditions)
+
+	ds := appsv1.DaemonSet{}
+	dsName := strings.Join([]string{vlanmanv1.ManagerSetNamePrefix, net.Name}, "-")
+	err := r.Client.Get(ctx, types.NamespacedName{Name: dsName, Namespace: r.Env.NamespaceName}, &ds)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errs.NewClientRequestError("Get manager daemonset", err)
+	}
+	available := metav1.Condition{Type: vlanmanv1.ConditionManagersAvailable, ObservedGeneration: gen}
+	switch {
+	case apierrors.IsNotFound(err):
+		available.Status = metav1.ConditionFalse
+		available.Reason = vlanmanv1.ReasonManagersNotFound
+		available.Message = fmt.Sprintf("DaemonSet %s doesn't exist", dsName)
+	case ds.Status.DesiredNumberScheduled == 0 || ds.Status.NumberAvailable < ds.Status.DesiredNumberScheduled:
+		available.Status = metav1.ConditionFalse
+		available.Reason = vlanmanv1.ReasonManagersUnavailable
+		available.Message = fmt.Sprintf("%d/%d manager pods available", ds.Status.NumberAvailable, ds.Status.DesiredNumberScheduled)
+	default:
+		available.Status = metav1.ConditionTrue
+		available.Reason = vlanmanv1.ReasonManagersAvailable
+		available.Message = fmt.Sprintf("%d/%d manager pods available", ds.Status.NumberAvailable, ds.Status.DesiredNumberScheduled)
+	}
+	meta.SetStatusCondition(&net.Status.Conditions, available)
+
+	// the DaemonSet controller counts the pods created from the current template also with the OnDelete strategy
+	progressing := metav1.Condition{Type: vlanmanv1.ConditionProgressing, ObservedGeneration: gen}
+	switch {
+	case apierrors.IsNotFound(err):
+		meta.RemoveStatusCondition(&net.Status.Conditions, vlanmanv1.ConditionProgressing)
+	case ds.Status.ObservedGeneration < ds.Generation || ds.Status.UpdatedNumberScheduled < ds.Status.DesiredNumberScheduled:
+		progressing.Status = metav1.ConditionTrue
+		progressing.Reason = vlanmanv1.ReasonRollingManagers
+		progressing.Message = fmt.Sprintf("%d/%d manager pods updated", ds.Status.UpdatedNumberScheduled, ds.Status.DesiredNumberScheduled)
+		meta.SetStatusCondition(&net.Status.Conditions, progressing)
+	default:
+		progressing.Status = metav1.ConditionFalse
+		progressing.Reason = vlanmanv1.ReasonManagersUpdated
+		progressing.Message = fmt.Sprintf("%d/%d manager pods updated", ds.Status.UpdatedNumberScheduled, ds.Status.DesiredNumberScheduled)
+		meta.SetStatusCondition(&net.Status.Conditions, progressing)
+	}
+
+	exhausted := []string{}
+	for _, pool := range net.Spec.Pools {
+		if free, ok := net.Status.FreeIPCount[pool.Name]; ok && free == 0 {
+			exhausted = append(exhausted, pool.Name)
 		}
-		pendingIPs := slices.Collect(maps.Keys(net.Status.PendingIPs))
-		net.Status.FreeIPs[pn] = slices.DeleteFunc(net.Status.FreeIPs[pn], func(ip string) bool {
-			cutIp, _, _ := strings.Cut(ip, "/")
-			return slices.Contains(pendingIPs, cutIp) || slices.Contains(podIpList, cutIp)
-		})
 	}
+	slices.Sort(exhausted)
+	pools := metav1.Condition{Type: vlanmanv1.ConditionPoolExhausted, ObservedGeneration: gen}
+	if len(exhausted) != 0 {
+		pools.Status = metav1.ConditionTrue
+		pools.Reason = vlanmanv1.ReasonPoolExhausted
+		pools.Message = "No free addresses in pools: " + strings.Join(exhausted, ", ")
+	} else {
+		pools.Status = metav1.ConditionFalse
+		pools.Reason = vlanmanv1.ReasonAddressesAvailable
+		pools.Message = "Every pool has free addresses"
+	}
+	meta.SetStatusCondition(&net.Status.Conditions, pools)
+
+	down := []string{}
+	for pod, state := range net.Status.State {
+		if state != vlanmanv1.StateUp {
+			down = append(down, pod)
+		}
+	}
+	slices.Sort(down)
+	degraded := metav1.Condition{Type: vlanmanv1.ConditionDegraded, ObservedGeneration: gen}
+	if len(down) != 0 {
+		degraded.Status = metav1.ConditionTrue
+		degraded.Reason = vlanmanv1.ReasonInterfacesDown
+		degraded.Message = "Vlan interface is down in manager pods: " + strings.Join(down, ", ")
+	} else {
+		degraded.Status = metav1.ConditionFalse
+		degraded.Reason = vlanmanv1.ReasonInterfacesUp
+		degraded.Message = "Vlan interfaces are up"
+	}
+	meta.SetStatusCondition(&net.Status.Conditions, degraded)
 
-	var requeueIn *time.Duration
-	for _, pending := range net.Status.PendingIPs {
-		p := slices.Collect(maps.Values(pending))
-		sortedPending := []time.Duration{}
-		for _, v := range p {
-			// err ignored since already checked above
-			// maybe combine it if there is a lot of them :TODO
-			t, _ := time.Parse(time.Layout, v)
-			sortedPending = append(sortedPending, time.Until(t.Add(time.Second*vlanmanv1.ReconcilerPendingIPsTimeoutSeconds)))
+	ready := metav1.Condition{Type: vlanmanv1.ConditionReady, ObservedGeneration: gen}
+	switch {
+	case available.Status != metav1.ConditionTrue:
+		ready.Status = metav1.ConditionFalse
+		ready.Reason = available.Reason
+		ready.Message = available.Message
+	case len(down) != 0 || len(net.Status.State) == 0:
+		ready.Status = metav1.ConditionFalse
+		ready.Reason = vlanmanv1.ReasonInterfacesDown
+		ready.Message = fmt.Sprintf("%d/%d vlan interfaces up", len(net.Status.State)-len(down), len(net.Status.State))
+	default:
+		ready.Status = metav1.ConditionTrue
+		ready.Reason = vlanmanv1.ReasonReady
+		ready.Message = fmt.Sprintf("%d/%d vlan interfaces up", len(net.Status.State), len(net.Status.State))
+	}
+	meta.SetStatusCondition(&net.Status.Conditions, ready)
+
+	if pools.Status == metav1.ConditionTrue && !meta.IsStatusConditionTrue(previous, vlanmanv1.ConditionPoolExhausted) {
+		r.event(net, corev1.EventTypeWarning, vlanmanv1.EventReasonPoolExhausted, pools.Message)
+	}
+	if degraded.Status == metav1.ConditionTrue && !meta.IsStatusConditionTrue(previous, vlanmanv1.ConditionDegraded) {
+		r.event(net, corev1.EventTypeWarning, vlanmanv1.EventReasonInterfacesDown, degraded.Message)
+	}
+	if ready.Status == metav1.ConditionTrue && !meta.IsStatusConditionTrue(previous, vlanmanv1.ConditionReady) {
+		r.event(net, corev1.EventTypeNormal, vlanmanv1.EventReasonReady, ready.Message)
+	}
+	return nil
+}
+
+// reconcileStickyAllocation marks sticky allocations whose pod is gone as reserved, and deletes
+// allocations of StatefulSets that were deleted or scaled down below the allocation's ordinal.
+// Allocations of claims are only deleted by the user. Returns true if the allocation was deleted.
+func (r *VlanmanReconciler) reconcileStickyAllocation(ctx context.Context, alloc *vlanmanv1.VlanIPAllocation) (bool, error) {
+	podExists := false
+	if alloc.Status.PodName != "" {
+		pod := corev1.Pod{}
+		err := r.Client.Get(ctx, types.NamespacedName{Name: alloc.Status.PodName, Namespace: alloc.Namespace}, &pod)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return false, errs.NewClientRequestError("Get pod of sticky allocation", err)
+		}
+		podExists = err == nil
+	}
+
+	if stsName, ok := alloc.Labels[vlanmanv1.AllocationStatefulSetLabelKey]; ok && !podExists {
+		sts := appsv1.StatefulSet{}
+		err := r.Client.Get(ctx, types.NamespacedName{Name: stsName, Namespace: alloc.Namespace}, &sts)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return false, errs.NewClientRequestError("Get StatefulSet of sticky allocation", err)
 		}
-		if len(sortedPending) == 0 {
-			requeueIn = nil
-		} else {
-			min := slices.Min(sortedPending)
-			requeueIn = &min
+		if apierrors.IsNotFound(err) || !hasOrdinal(&sts, alloc.Labels[vlanmanv1.AllocationOrdinalLabelKey]) {
+			log.FromContext(ctx).Info("Deleting allocation of a removed StatefulSet replica", "allocation", alloc.Name, "namespace", alloc.Namespace, "addresses", alloc.Spec.Addresses)
+			err = r.Client.Delete(ctx, alloc)
+			if err != nil && !apierrors.IsNotFound(err) {
+				return false, errs.NewClientRequestError("Delete VlanIPAllocation of a removed StatefulSet replica", err)
+			}
+			return true, nil
 		}
 	}
-	return requeueIn, nil
+
+	if !podExists && alloc.Status.Phase == vlanmanv1.AllocationBound {
+		alloc.Status.Phase = vlanmanv1.AllocationReserved
+		err := r.Client.Status().Update(ctx, alloc)
+		if err != nil {
+			return false, errs.NewClientRequestError("Update VlanIPAllocation status to reserved", err)
+		}
+	}
+	return false, nil
 }
 
-func (r *VlanmanReconciler) UpdateAllNetworkStatus(ctx context.Context) (*time.Duration, error) {
-	list := vlanmanv1.VlanNetworkList{}
-	var rq *time.Duration
-	err := r.Client.List(ctx, &list)
+// hasOrdinal checks whether the ordinal is one of the StatefulSet's desired replicas
+func hasOrdinal(sts *appsv1.StatefulSet, ordinal string) bool {
+	n, err := strconv.Atoi(ordinal)
 	if err != nil {
-		err = errs.NewClientRequestError("List vlan networks in UpdateStatus", err)
-		return nil, err
+		return false
+	}
+	start := 0
+	if sts.Spec.Ordinals != nil {
+		start = int(sts.Spec.Ordinals.Start)
+	}
+	replicas := 1
+	if sts.Spec.Replicas != nil {
+		replicas = int(*sts.Spec.Replicas)
+	}
+	return n >= start && n < start+replicas
+}
+
+// isTerminal checks whether the pod finished, its containers won't be restarted
+func isTerminal(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+// podOwner returns the pod owning a bound allocation
+func podOwner(alloc vlanmanv1.VlanIPAllocation) (metav1.OwnerReference, bool) {
+	idx := slices.IndexFunc(alloc.OwnerReferences, func(o metav1.OwnerReference) bool {
+		return o.Kind == "Pod"
+	})
+	if idx == -1 {
+		return metav1.OwnerReference{}, false
 	}
-	for _, net := range list.Items {
-		rq2, err := r.updateVlanNetworkStatus(ctx, &net)
-		rq = rq2
+	return alloc.OwnerReferences[idx], true
+}
+
+// releasePodAllocations releases the allocations bound to a pod that was deleted or finished,
+// an empty uid matches any pod with the name. Sticky allocations are kept for the next pod with the same claim.
+func (r *VlanmanReconciler) releasePodAllocations(ctx context.Context, nsn types.NamespacedName, uid types.UID) error {
+	allocs := vlanmanv1.VlanIPAllocationList{}
+	err := r.Client.List(ctx, &allocs, client.InNamespace(nsn.Namespace))
+	if err != nil {
+		return errs.NewClientRequestError("List VlanIPAllocations of pod", err)
+	}
+	for _, alloc := range allocs.Items {
+		owner, ok := podOwner(alloc)
+		if !ok || owner.Name != nsn.Name || (uid != "" && owner.UID != uid) || alloc.DeletionTimestamp != nil {
+			continue
+		}
+		err = r.releaseAllocation(ctx, &alloc)
 		if err != nil {
-			return rq, err
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseAllocation deletes the allocation, its addresses stay taken until the release finalizer is removed
+func (r *VlanmanReconciler) releaseAllocation(ctx context.Context, alloc *vlanmanv1.VlanIPAllocation) error {
+	log.FromContext(ctx).Info("Releasing addresses", "allocation", alloc.Name, "namespace", alloc.Namespace, "pod", alloc.Status.PodName, "addresses", alloc.Spec.Addresses)
+	err := r.Client.Delete(ctx, alloc)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errs.NewClientRequestError("Delete VlanIPAllocation of a released pod", err)
+	}
+	return nil
+}
+
+// collectAllocation releases a bound allocation whose pod is gone, was replaced by a pod with the same name
+// or finished, in case the pod event was missed. Returns true if the allocation was released.
+func (r *VlanmanReconciler) collectAllocation(ctx context.Context, alloc *vlanmanv1.VlanIPAllocation) (bool, error) {
+	owner, ok := podOwner(*alloc)
+	if !ok {
+		return false, nil
+	}
+	pod := corev1.Pod{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: owner.Name, Namespace: alloc.Namespace}, &pod)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return false, errs.NewClientRequestError("Get pod of allocation", err)
+	}
+	if err == nil && pod.UID == owner.UID && !isTerminal(&pod) {
+		return false, nil
+	}
+	return true, r.releaseAllocation(ctx, alloc)
+}
+
+// expireAllocation removes the release finalizer of a deleted allocation once the release delay passed since
+// its deletion, until then its phase is Released. Allocations that were never bound are removed right away,
+// their addresses weren't used. Returns how long the addresses are still kept.
+func (r *VlanmanReconciler) expireAllocation(ctx context.Context, alloc *vlanmanv1.VlanIPAllocation, delay time.Duration) (time.Duration, error) {
+	if !controllerutil.ContainsFinalizer(alloc, vlanmanv1.AllocationFinalizer) {
+		return 0, nil
+	}
+	if alloc.Status.Phase != vlanmanv1.AllocationPending && alloc.Status.Phase != "" {
+		left := time.Until(alloc.DeletionTimestamp.Add(delay))
+		if left > 0 {
+			if alloc.Status.Phase != vlanmanv1.AllocationReleased {
+				alloc.Status.Phase = vlanmanv1.AllocationReleased
+				err := r.Client.Status().Update(ctx, alloc)
+				if err != nil && !apierrors.IsNotFound(err) {
+					return left, errs.NewClientRequestError("Update VlanIPAllocation status to released", err)
+				}
+			}
+			return left, nil
+		}
+	}
+
+	patch := client.MergeFrom(alloc.DeepCopy())
+	controllerutil.RemoveFinalizer(alloc, vlanmanv1.AllocationFinalizer)
+	err := r.Client.Patch(ctx, alloc, patch)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return 0, errs.NewClientRequestError("Remove finalizer from VlanIPAllocation", err)
+	}
+	log.FromContext(ctx).Info("Addresses are free", "allocation", alloc.Name, "namespace", alloc.Namespace, "addresses", alloc.Spec.Addresses)
+	return 0, nil
+}
+
+// expireNetworkAllocations removes the release finalizer of the deleted allocations of a network
+// that's gone or being deleted, nothing else would remove it
+func (r *VlanmanReconciler) expireNetworkAllocations(ctx context.Context, network string) error {
+	allocs, err := ipam.ListAllocations(ctx, r.Client, network, "")
+	if err != nil {
+		return err
+	}
+	for _, alloc := range allocs {
+		if alloc.DeletionTimestamp == nil {
+			continue
 		}
-		err = r.Client.Status().Update(ctx, &net)
+		_, err = r.expireAllocation(ctx, &alloc, 0)
 		if err != nil {
-			err = errs.NewClientRequestError(fmt.Sprintf("Update vlan network %s's status", net.Name), err)
-			return rq, err
+			return err
 		}
 	}
+	return nil
+}
+
+// bindAllocation makes the pod an owner of the allocation created for it by the mutating webhook,
+// so that the allocation is garbage collected together with the pod. Sticky allocations are only
+// marked as bound to the pod.
+func (r *VlanmanReconciler) bindAllocation(ctx context.Context, pod *corev1.Pod) error {
+	names, ok := pod.Annotations[vlanmanv1.PodVlanmanAllocationAnnotation]
+	if !ok || names == "" {
+		return nil
+	}
+	// pods attached to more than one network hold an allocation in each
+	for name := range strings.SplitSeq(names, ",") {
+		err := r.bindOneAllocation(ctx, pod, strings.TrimSpace(name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// podOfAllocation returns the pod whose allocation annotation lists the allocation,
+// nil if there's none, e.g. because the pod creation was rejected after the mutating webhook
+func (r *VlanmanReconciler) podOfAllocation(ctx context.Context, alloc *vlanmanv1.VlanIPAllocation) (*corev1.Pod, error) {
+	pods := corev1.PodList{}
+	err := r.Client.List(ctx, &pods, client.InNamespace(alloc.Namespace), client.MatchingLabels{
+		u.WorkerNetworkLabelKey(alloc.Spec.Network): "true",
+	})
+	if err != nil {
+		return nil, errs.NewClientRequestError("List pods of allocation", err)
+	}
+	for _, pod := range pods.Items {
+		for name := range strings.SplitSeq(pod.Annotations[vlanmanv1.PodVlanmanAllocationAnnotation], ",") {
+			if strings.TrimSpace(name) == alloc.Name {
+				return &pod, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (r *VlanmanReconciler) bindOneAllocation(ctx context.Context, pod *corev1.Pod, name string) error {
+	alloc := vlanmanv1.VlanIPAllocation{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: name, Namespace: pod.Namespace}, &alloc)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			log.FromContext(ctx).Error(err, "Allocation of pod doesn't exist, its addresses might be handed out again", "pod", pod.Name, "allocation", name)
+			return nil
+		}
+		return errs.NewClientRequestError("Get VlanIPAllocation of pod", err)
+	}
+	if ipam.IsSticky(alloc) {
+		// sticky allocations outlive the pod, so it doesn't become an owner
+		if alloc.Status.Phase == vlanmanv1.AllocationBound && alloc.Status.PodName == pod.Name {
+			return nil
+		}
+		alloc.Status.Phase = vlanmanv1.AllocationBound
+		alloc.Status.PodName = pod.Name
+		err = r.Client.Status().Update(ctx, &alloc)
+		if err != nil {
+			return errs.NewClientRequestError("Update VlanIPAllocation status", err)
+		}
+		return nil
+	}
+	if alloc.Status.Phase == vlanmanv1.AllocationBound && slices.ContainsFunc(alloc.OwnerReferences, func(o metav1.OwnerReference) bool {
+		return o.UID == pod.UID
+	}) {
+		return nil
+	}
+
+	err = controllerutil.SetOwnerReference(pod, &alloc, r.Scheme)
+	if err != nil {
+		return &errs.InternalError{Context: fmt.Sprintf("Couldn't set owner reference of allocation %s: %s", name, err)}
+	}
+	err = r.Client.Update(ctx, &alloc)
+	if err != nil {
+		return errs.NewClientRequestError("Update VlanIPAllocation owner", err)
+	}
+	alloc.Status.Phase = vlanmanv1.AllocationBound
+	alloc.Status.PodName = pod.Name
+	err = r.Client.Status().Update(ctx, &alloc)
+	if err != nil {
+		return errs.NewClientRequestError("Update VlanIPAllocation status", err)
+	}
+	return nil
+}
+
+// updateNetworkStatus updates the status of one network, a deleted network is skipped
+func (r *VlanmanReconciler) updateNetworkStatus(ctx context.Context, name string) (*time.Duration, error) {
+	net := vlanmanv1.VlanNetwork{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: name}, &net)
+	if apierrors.IsNotFound(err) {
+		return nil, r.expireNetworkAllocations(ctx, name)
+	}
+	if err != nil {
+		return nil, errs.NewClientRequestError(fmt.Sprintf("Get vlan network %s in UpdateStatus", name), err)
+	}
+	if net.DeletionTimestamp != nil {
+		// the nodes in the status are torn down by finalizeNetwork,
+		// the addresses of a deleted network don't have to be kept
+		return nil, r.expireNetworkAllocations(ctx, name)
+	}
+	rq, err := r.updateVlanNetworkStatus(ctx, &net)
+	if err != nil {
+		return rq, err
+	}
+	err = r.Client.Status().Update(ctx, &net)
+	if err != nil {
+		return rq, errs.NewClientRequestError(fmt.Sprintf("Update vlan network %s's status", net.Name), err)
+	}
 	return rq, nil
 }
 
@@ -461,18 +1309,25 @@ func (r *VlanmanReconciler) ensurePodMonitor(ctx context.Context) error {
 
 var done bool = false
 
-// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;update;create;watch;delete
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;update;patch;create;watch;delete
 // +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;update;create;watch;delete
 // +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
-// +kubebuilder:rbac:groups="",resources=nodes,verbs=watch;list
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;watch;list
 // +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;update;create;list;watch
 // +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=create;delete;list;get;watch;update
-// +kubebuilder:rbac:groups=vlanman.dialo.ai,resources=vlannetworks,verbs=create;delete;list;get;watch;update
+// +kubebuilder:rbac:groups=vlanman.dialo.ai,resources=vlannetworks,verbs=create;delete;list;get;watch;update;patch
+// +kubebuilder:rbac:groups=vlanman.dialo.ai,resources=vlannetworks/finalizers,verbs=update
 // +kubebuilder:rbac:groups=monitoring.coreos.com,resources=podmonitors,verbs=create;delete;list;get;watch;update
 // +kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=create;delete;list;get;watch;update
+// +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=list;get;watch
 // +kubebuilder:rbac:groups="",resources=services,verbs=create;delete;list;get;watch;update
 // +kubebuilder:rbac:groups=vlanman.dialo.ai,resources=vlannetworks/status,verbs=get;update;create;patch
+// +kubebuilder:rbac:groups=vlanman.dialo.ai,resources=vlanipallocations,verbs=create;delete;list;get;watch;update;patch
+// +kubebuilder:rbac:groups=vlanman.dialo.ai,resources=vlanipallocations/status,verbs=get;update;patch
 
+// Reconcile handles two kinds of requests. Namespaced ones are worker pods whose allocations
+// are bound to them, the others are networks whose status and managers are reconciled.
 func (r *VlanmanReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
 	log := log.FromContext(ctx)
 	log.Info("Starting reconciler")
@@ -481,25 +1336,15 @@ func (r *VlanmanReconciler) Reconcile(ctx context.Context, req reconcile.Request
 		r.ensurePodMonitor(ctx)
 	}
 
-	pod := corev1.Pod{}
-	err := r.Client.Get(ctx, req.NamespacedName, &pod)
-	if !apierrors.IsNotFound(err) {
-		if err != nil {
-			log.Error(err, "Error fetching pod")
-			return ctrl.Result{}, err
-		} else {
-			if pod.Status.PodIP == "" {
-				log.Info("Pod doesn't have ip yet, requeuing...")
-				return ctrl.Result{RequeueAfter: time.Second}, nil
-			}
-		}
+	if req.Namespace != "" {
+		return ctrl.Result{}, r.reconcileWorkerPod(ctx, req.NamespacedName)
 	}
 
-	rq, err := r.UpdateAllNetworkStatus(ctx)
+	rq, err := r.updateNetworkStatus(ctx, req.Name)
 	ctr := 1
 	for apierrors.IsConflict(err) && ctr <= vlanmanv1.UpdateStatusMaxRetries {
 		log.Info("Error updating status, trying again", "tries", fmt.Sprintf("%d/%d", ctr, vlanmanv1.UpdateStatusMaxRetries), "error", err)
-		rq, err = r.UpdateAllNetworkStatus(ctx)
+		rq, err = r.updateNetworkStatus(ctx, req.Name)
 		ctr += 1
 	}
 
@@ -509,12 +1354,9 @@ func (r *VlanmanReconciler) Reconcile(ctx context.Context, req reconcile.Request
 		}
 	}
 
-	var rqNet *time.Duration
-	if req.Namespace == "" {
-		rqNet, err = r.reconcileNetwork(ctx)
-		if err != nil {
-			return ctrl.Result{}, err
-		}
+	rqNet, err := r.reconcileNetwork(ctx, req.Name)
+	if err != nil {
+		return ctrl.Result{}, err
 	}
 	if rqNet != nil {
 		return res(rq, *rqNet), nil
@@ -522,6 +1364,31 @@ func (r *VlanmanReconciler) Reconcile(ctx context.Context, req reconcile.Request
 	return res(rq), nil
 }
 
+// reconcileWorkerPod binds the allocations of a worker pod to it and releases them once the pod
+// is deleted or finished, its networks are reconciled from their own requests
+func (r *VlanmanReconciler) reconcileWorkerPod(ctx context.Context, nsn types.NamespacedName) error {
+	log := log.FromContext(ctx)
+	pod := corev1.Pod{}
+	err := r.Client.Get(ctx, nsn, &pod)
+	if apierrors.IsNotFound(err) {
+		return r.releasePodAllocations(ctx, nsn, "")
+	}
+	if err != nil {
+		log.Error(err, "Error fetching pod")
+		return err
+	}
+	if isTerminal(&pod) {
+		return r.releasePodAllocations(ctx, nsn, pod.UID)
+	}
+	err = r.bindAllocation(ctx, &pod)
+	if err != nil {
+		log.Error(err, "Error binding allocation to pod")
+		r.event(&pod, corev1.EventTypeWarning, vlanmanv1.EventReasonBindFailed, "Couldn't bind VlanIPAllocation: %s", err)
+		return err
+	}
+	return nil
+}
+
 func res(rq *time.Duration, times ...time.Duration) ctrl.Result {
 	if rq == nil && times == nil {
 		return ctrl.Result{}
@@ -550,13 +1417,113 @@ func notJob(obj client.Object) bool {
 	return !ok
 }
 
+// becameTerminal checks whether the pod just finished, so that its addresses are released
+func becameTerminal(oldObj, newObj client.Object) bool {
+	oldPod, ok := oldObj.(*corev1.Pod)
+	if !ok {
+		return false
+	}
+	newPod, ok := newObj.(*corev1.Pod)
+	if !ok {
+		return false
+	}
+	return !isTerminal(oldPod) && isTerminal(newPod)
+}
+
+// allocationToNetwork enqueues the network of an allocation
+// so that free IP counts are refreshed when allocations come and go
+func allocationToNetwork(_ context.Context, obj client.Object) []reconcile.Request {
+	alloc, ok := obj.(*vlanmanv1.VlanIPAllocation)
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: alloc.Spec.Network}}}
+}
+
+// labelToNetwork enqueues the network named by the label of an object, it's used
+// for manager DaemonSets, manager pods and interface jobs so that waiting actions continue
+func labelToNetwork(key string) handler.MapFunc {
+	return func(_ context.Context, obj client.Object) []reconcile.Request {
+		name := obj.GetLabels()[key]
+		if name == "" {
+			return nil
+		}
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: name}}}
+	}
+}
+
+func hasLabel(key string) predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		_, ok := obj.GetLabels()[key]
+		return ok
+	})
+}
+
+// workerPodToNetworks enqueues the networks a worker pod is attached to
+func workerPodToNetworks(_ context.Context, obj client.Object) []reconcile.Request {
+	networks := map[string]bool{}
+	for key, val := range obj.GetLabels() {
+		if key == vlanmanv1.WorkerPodLabelKey && val != "" {
+			networks[val] = true
+		}
+		if name, ok := strings.CutPrefix(key, vlanmanv1.WorkerPodNetworkLabelPrefix); ok && name != "" {
+			networks[name] = true
+		}
+	}
+	reqs := []reconcile.Request{}
+	for name := range networks {
+		reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Name: name}})
+	}
+	return reqs
+}
+
+// nodeToNetworks enqueues the networks with mappings, so that they are
+// resolved for nodes that joined the cluster or whose labels changed
+func (r *VlanmanReconciler) nodeToNetworks(ctx context.Context, _ client.Object) []reconcile.Request {
+	networks := vlanmanv1.VlanNetworkList{}
+	err := r.Client.List(ctx, &networks)
+	if err != nil {
+		log.FromContext(ctx).Error(errs.NewClientRequestError("List VlanNetworks", err), "Couldn't map node to networks")
+		return nil
+	}
+	reqs := []reconcile.Request{}
+	for _, net := range networks.Items {
+		if len(net.Spec.Mappings) != 0 {
+			reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Name: net.Name}})
+		}
+	}
+	return reqs
+}
+
+// statefulSetToNetworks enqueues the networks holding sticky allocations of a StatefulSet,
+// so that allocations of removed replicas are released after a scale down
+func (r *VlanmanReconciler) statefulSetToNetworks(ctx context.Context, obj client.Object) []reconcile.Request {
+	allocs := vlanmanv1.VlanIPAllocationList{}
+	err := r.Client.List(ctx, &allocs, client.InNamespace(obj.GetNamespace()), client.MatchingLabels{
+		vlanmanv1.AllocationStatefulSetLabelKey: obj.GetName(),
+	})
+	if err != nil {
+		log.FromContext(ctx).Error(errs.NewClientRequestError("List VlanIPAllocations of StatefulSet", err), "Couldn't map StatefulSet to networks", "statefulset", obj.GetName())
+		return nil
+	}
+	networks := map[string]bool{}
+	for _, a := range allocs.Items {
+		networks[a.Spec.Network] = true
+	}
+	reqs := []reconcile.Request{}
+	for name := range networks {
+		reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Name: name}})
+	}
+	return reqs
+}
+
 func (r *VlanmanReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	annotationPredicate := predicate.Funcs{
 		CreateFunc: func(e event.CreateEvent) bool {
 			return hasVlanmanAnnotation(e.Object) && notJob(e.Object)
 		},
 		UpdateFunc: func(e event.UpdateEvent) bool {
-			return false
+			return hasVlanmanAnnotation(e.ObjectNew) && notJob(e.ObjectNew) && becameTerminal(e.ObjectOld, e.ObjectNew)
 		},
 		DeleteFunc: func(e event.DeleteEvent) bool {
 			return hasVlanmanAnnotation(e.Object) && notJob(e.Object)
@@ -565,8 +1532,30 @@ func (r *VlanmanReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			return hasVlanmanAnnotation(e.Object) && notJob(e.Object)
 		},
 	}
+	nodePredicate := predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return true
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return !reflect.DeepEqual(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels())
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return false
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&vlanmanv1.VlanNetwork{}).
 		Watches(&corev1.Pod{}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(annotationPredicate)).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(workerPodToNetworks), builder.WithPredicates(annotationPredicate)).
+		Watches(&vlanmanv1.VlanIPAllocation{}, handler.EnqueueRequestsFromMapFunc(allocationToNetwork)).
+		Watches(&appsv1.StatefulSet{}, handler.EnqueueRequestsFromMapFunc(r.statefulSetToNetworks)).
+		Watches(&corev1.Node{}, handler.EnqueueRequestsFromMapFunc(r.nodeToNetworks), builder.WithPredicates(nodePredicate)).
+		Watches(&appsv1.DaemonSet{}, handler.EnqueueRequestsFromMapFunc(labelToNetwork(vlanmanv1.ManagerSetLabelKey)), builder.WithPredicates(hasLabel(vlanmanv1.ManagerSetLabelKey))).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(labelToNetwork(vlanmanv1.ManagerSetLabelKey)), builder.WithPredicates(hasLabel(vlanmanv1.ManagerSetLabelKey))).
+		Watches(&batchv1.Job{}, handler.EnqueueRequestsFromMapFunc(labelToNetwork(vlanmanv1.InterfaceJobNetworkLabelKey)), builder.WithPredicates(hasLabel(vlanmanv1.InterfaceJobNetworkLabelKey))).
+		Watches(&batchv1.Job{}, handler.EnqueueRequestsFromMapFunc(labelToNetwork(vlanmanv1.TeardownJobNetworkLabelKey)), builder.WithPredicates(hasLabel(vlanmanv1.TeardownJobNetworkLabelKey))).
 		Complete(r)
 }
@@ -3,16 +3,25 @@ package controller
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
 	vlanmanv1 "dialo.ai/vlanman/api/v1"
+	u "dialo.ai/vlanman/pkg/utils"
 )
 
 func TestVlanmanReconciler_createDesiredState(t *testing.T) {
@@ -442,7 +451,7 @@ func TestVlanmanReconciler_getCurrentState(t *testing.T) {
 			}
 
 			ctx := context.Background()
-			state, err := reconciler.getCurrentState(ctx)
+			state, _, err := reconciler.getCurrentState(ctx, "net1")
 
 			require.NoError(t, err)
 			assert.NotNil(t, state)
@@ -475,7 +484,7 @@ func TestVlanmanReconciler_getCurrentState_ErrorHandling(t *testing.T) {
 		}
 
 		ctx := context.Background()
-		state, err := reconciler.getCurrentState(ctx)
+		state, _, err := reconciler.getCurrentState(ctx, "net1")
 
 		// With fake client, this should succeed with empty state
 		require.NoError(t, err)
@@ -519,3 +528,905 @@ func TestVlanmanReconciler_createDesiredState_ErrorHandling(t *testing.T) {
 		assert.Equal(t, "network1", state[0].OwnerNetworkName)
 	})
 }
+
+func TestVlanmanReconciler_collectNodes(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = vlanmanv1.AddToScheme(scheme)
+
+	jobPod := func(name, node, message string, finished time.Time) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "vlanman-system",
+				Labels:    map[string]string{vlanmanv1.InterfaceJobNetworkLabelKey: "net1"},
+			},
+			Spec: corev1.PodSpec{NodeName: node},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodSucceeded,
+				ContainerStatuses: []corev1.ContainerStatus{{
+					State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+						Message:    message,
+						FinishedAt: metav1.NewTime(finished),
+					}},
+				}},
+			},
+		}
+	}
+	managerPod := func(name, node string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "vlanman-system",
+				Labels:    map[string]string{vlanmanv1.ManagerSetLabelKey: "net1"},
+			},
+			Spec: corev1.PodSpec{NodeName: node},
+		}
+	}
+
+	now := time.Now()
+	client := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(
+			jobPod("job-1", "node1", `{"name":"eth0"}`, now.Add(-time.Hour)),
+			jobPod("job-1-fixup", "node1", `{"name":"ens5","pciAddress":"0000:3b:00.0"}`, now),
+			jobPod("job-2", "node2", `not json`, now),
+			managerPod("manager-1", "node1"),
+			managerPod("manager-2", "node2"),
+		).
+		Build()
+	reconciler := &VlanmanReconciler{
+		Client: client,
+		Scheme: scheme,
+		Env:    Envs{NamespaceName: "vlanman-system"},
+	}
+
+	net := &vlanmanv1.VlanNetwork{
+		ObjectMeta: metav1.ObjectMeta{Name: "net1"},
+		Status: vlanmanv1.VlanNetworkStatus{
+			Nodes: map[string]vlanmanv1.NodeAttachment{
+				"node2": {Pod: "manager-2", State: vlanmanv1.StateUp, LinkIndex: 5, Parent: &vlanmanv1.ParentInterface{Name: "eth1"}},
+				"gone":  {Pod: "manager-3", Parent: &vlanmanv1.ParentInterface{Name: "eth0"}},
+			},
+		},
+	}
+	err := reconciler.collectNodes(context.Background(), net)
+	require.NoError(t, err)
+	require.Len(t, net.Status.Nodes, 2)
+	assert.Equal(t, "manager-1", net.Status.Nodes["node1"].Pod)
+	assert.Equal(t, &vlanmanv1.ParentInterface{Name: "ens5", PCIAddress: "0000:3b:00.0"}, net.Status.Nodes["node1"].Parent)
+	assert.Equal(t, vlanmanv1.NodeAttachment{Pod: "manager-2", State: vlanmanv1.StateUp, LinkIndex: 5, Parent: &vlanmanv1.ParentInterface{Name: "eth1"}}, net.Status.Nodes["node2"])
+}
+
+func TestVlanmanReconciler_updateConditions(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	_ = vlanmanv1.AddToScheme(scheme)
+
+	daemonSet := func(desired, available int32) *appsv1.DaemonSet {
+		return &appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "vlan-manager-net1", Namespace: "vlanman-system"},
+			Status: appsv1.DaemonSetStatus{
+				DesiredNumberScheduled: desired,
+				NumberAvailable:        available,
+				UpdatedNumberScheduled: desired,
+			},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		daemonSet *appsv1.DaemonSet
+		state     map[string]vlanmanv1.ConnectionState
+		free      map[string]int64
+		expected  map[string]metav1.ConditionStatus
+		reason    string
+	}{
+		{
+			name:      "ready",
+			daemonSet: daemonSet(2, 2),
+			state:     map[string]vlanmanv1.ConnectionState{"a": vlanmanv1.StateUp, "b": vlanmanv1.StateUp},
+			free:      map[string]int64{"primary": 3},
+			expected: map[string]metav1.ConditionStatus{
+				vlanmanv1.ConditionReady:             metav1.ConditionTrue,
+				vlanmanv1.ConditionManagersAvailable: metav1.ConditionTrue,
+				vlanmanv1.ConditionPoolExhausted:     metav1.ConditionFalse,
+				vlanmanv1.ConditionDegraded:          metav1.ConditionFalse,
+				vlanmanv1.ConditionProgressing:       metav1.ConditionFalse,
+			},
+			reason: vlanmanv1.ReasonReady,
+		},
+		{
+			name: "rolling managers",
+			daemonSet: &appsv1.DaemonSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "vlan-manager-net1", Namespace: "vlanman-system"},
+				Status: appsv1.DaemonSetStatus{
+					DesiredNumberScheduled: 2,
+					NumberAvailable:        2,
+					UpdatedNumberScheduled: 1,
+				},
+			},
+			state: map[string]vlanmanv1.ConnectionState{"a": vlanmanv1.StateUp, "b": vlanmanv1.StateUp},
+			free:  map[string]int64{"primary": 3},
+			expected: map[string]metav1.ConditionStatus{
+				vlanmanv1.ConditionReady:       metav1.ConditionTrue,
+				vlanmanv1.ConditionProgressing: metav1.ConditionTrue,
+			},
+			reason: vlanmanv1.ReasonReady,
+		},
+		{
+			name:      "degraded and exhausted",
+			daemonSet: daemonSet(2, 2),
+			state:     map[string]vlanmanv1.ConnectionState{"a": vlanmanv1.StateUp, "b": vlanmanv1.StateDown},
+			free:      map[string]int64{"primary": 0},
+			expected: map[string]metav1.ConditionStatus{
+				vlanmanv1.ConditionReady:             metav1.ConditionFalse,
+				vlanmanv1.ConditionManagersAvailable: metav1.ConditionTrue,
+				vlanmanv1.ConditionPoolExhausted:     metav1.ConditionTrue,
+				vlanmanv1.ConditionDegraded:          metav1.ConditionTrue,
+			},
+			reason: vlanmanv1.ReasonInterfacesDown,
+		},
+		{
+			name:      "managers unavailable",
+			daemonSet: daemonSet(2, 1),
+			state:     map[string]vlanmanv1.ConnectionState{"a": vlanmanv1.StateUp},
+			free:      map[string]int64{"primary": 3},
+			expected: map[string]metav1.ConditionStatus{
+				vlanmanv1.ConditionReady:             metav1.ConditionFalse,
+				vlanmanv1.ConditionManagersAvailable: metav1.ConditionFalse,
+			},
+			reason: vlanmanv1.ReasonManagersUnavailable,
+		},
+		{
+			name:  "no managers",
+			state: map[string]vlanmanv1.ConnectionState{},
+			free:  map[string]int64{"primary": 3},
+			expected: map[string]metav1.ConditionStatus{
+				vlanmanv1.ConditionReady:             metav1.ConditionFalse,
+				vlanmanv1.ConditionManagersAvailable: metav1.ConditionFalse,
+			},
+			reason: vlanmanv1.ReasonManagersNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := fake.NewClientBuilder().WithScheme(scheme)
+			if tt.daemonSet != nil {
+				builder = builder.WithObjects(tt.daemonSet)
+			}
+			reconciler := &VlanmanReconciler{
+				Client: builder.Build(),
+				Scheme: scheme,
+				Env:    Envs{NamespaceName: "vlanman-system"},
+			}
+			net := &vlanmanv1.VlanNetwork{
+				ObjectMeta: metav1.ObjectMeta{Name: "net1", Generation: 3},
+				Spec: vlanmanv1.VlanNetworkSpec{
+					Pools: []vlanmanv1.VlanNetworkPool{{Name: "primary"}},
+				},
+				Status: vlanmanv1.VlanNetworkStatus{
+					State:       tt.state,
+					FreeIPCount: tt.free,
+				},
+			}
+
+			err := reconciler.updateConditions(context.Background(), net)
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), net.Status.ObservedGeneration)
+			for condType, status := range tt.expected {
+				cond := meta.FindStatusCondition(net.Status.Conditions, condType)
+				require.NotNil(t, cond, condType)
+				assert.Equal(t, status, cond.Status, condType)
+				assert.Equal(t, int64(3), cond.ObservedGeneration)
+			}
+			assert.Equal(t, tt.reason, meta.FindStatusCondition(net.Status.Conditions, vlanmanv1.ConditionReady).Reason)
+		})
+	}
+}
+
+func TestVlanmanReconciler_updateConditionsEvents(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	_ = vlanmanv1.AddToScheme(scheme)
+
+	recorder := record.NewFakeRecorder(10)
+	reconciler := &VlanmanReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(&appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "vlan-manager-net1", Namespace: "vlanman-system"},
+			Status:     appsv1.DaemonSetStatus{DesiredNumberScheduled: 1, NumberAvailable: 1},
+		}).Build(),
+		Scheme:   scheme,
+		Recorder: recorder,
+		Env:      Envs{NamespaceName: "vlanman-system"},
+	}
+	net := &vlanmanv1.VlanNetwork{
+		ObjectMeta: metav1.ObjectMeta{Name: "net1"},
+		Spec: vlanmanv1.VlanNetworkSpec{
+			Pools: []vlanmanv1.VlanNetworkPool{{Name: "primary"}},
+		},
+		Status: vlanmanv1.VlanNetworkStatus{
+			State:       map[string]vlanmanv1.ConnectionState{"a": vlanmanv1.StateUp},
+			FreeIPCount: map[string]int64{"primary": 0},
+		},
+	}
+
+	require.NoError(t, reconciler.updateConditions(context.Background(), net))
+	require.Len(t, recorder.Events, 2)
+	assert.Contains(t, <-recorder.Events, "Warning PoolExhausted")
+	assert.Contains(t, <-recorder.Events, "Normal Ready")
+
+	// conditions didn't change, nothing is recorded
+	require.NoError(t, reconciler.updateConditions(context.Background(), net))
+	assert.Empty(t, recorder.Events)
+}
+
+func TestVlanmanReconciler_diffStatesSpawnsInterfaces(t *testing.T) {
+	reconciler := &VlanmanReconciler{}
+	conns := []VlanNetworkState{{
+		Name: "net1",
+		Status: map[string]vlanmanv1.ConnectionState{
+			"manager-1": vlanmanv1.StateUp,
+			"manager-2": vlanmanv1.StateDown,
+		},
+		Managers: []string{"manager-1", "manager-2", "manager-3"},
+	}}
+
+	acts := reconciler.diffStates(nil, nil, conns)
+	spawned := []string{}
+	for _, a := range acts {
+		if s, ok := a.(*SpawnInterfaceAction); ok {
+			spawned = append(spawned, s.PodName)
+		}
+	}
+	assert.ElementsMatch(t, []string{"manager-2", "manager-3"}, spawned)
+}
+
+func TestVlanmanReconciler_diffStatesManagers(t *testing.T) {
+	env := Envs{NamespaceName: "vlanman-system", VlanManagerImage: "vlanman-manager:v1"}
+	reconciler := &VlanmanReconciler{Env: env}
+	net := vlanmanv1.VlanNetwork{ObjectMeta: metav1.ObjectMeta{Name: "net1"}, Spec: vlanmanv1.VlanNetworkSpec{VlanID: 10}}
+	desired := createDesiredManagerSet(net)
+	live, err := daemonSetFromManager(desired, env)
+	require.NoError(t, err)
+	orphan, err := daemonSetFromManager(ManagerSet{OwnerNetworkName: "gone"}, env)
+	require.NoError(t, err)
+	conns := []VlanNetworkState{{Name: "net1"}}
+
+	acts := reconciler.diffStates([]ManagerSet{desired}, []appsv1.DaemonSet{live, orphan}, conns)
+	require.Len(t, acts, 1)
+	deleted, ok := acts[0].(*DeleteManagerAction)
+	require.True(t, ok)
+	assert.Equal(t, "gone", deleted.Manager.OwnerNetworkName)
+
+	reconciler.Env.VlanManagerImage = "vlanman-manager:v2"
+	acts = reconciler.diffStates([]ManagerSet{desired}, []appsv1.DaemonSet{live}, conns)
+	require.Len(t, acts, 1)
+	assert.IsType(t, &UpdateManagerAction{}, acts[0])
+}
+
+func TestLabelToNetwork(t *testing.T) {
+	mapFunc := labelToNetwork(vlanmanv1.InterfaceJobNetworkLabelKey)
+	job := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:   "create-vlan-job-net1-node1",
+		Labels: map[string]string{vlanmanv1.InterfaceJobNetworkLabelKey: "net1"},
+	}}
+	reqs := mapFunc(context.Background(), job)
+	require.Len(t, reqs, 1)
+	assert.Equal(t, "net1", reqs[0].Name)
+	assert.Empty(t, reqs[0].Namespace)
+
+	assert.Empty(t, mapFunc(context.Background(), &corev1.Pod{}))
+}
+
+func TestVlanmanReconciler_getCurrentStateScopedToNetwork(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	_ = vlanmanv1.AddToScheme(scheme)
+
+	env := Envs{NamespaceName: "vlanman-system"}
+	objs := []client.Object{}
+	for _, name := range []string{"net1", "net2"} {
+		net := vlanmanv1.VlanNetwork{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: vlanmanv1.VlanNetworkSpec{VlanID: 10}}
+		ds, err := daemonSetFromManager(createDesiredManagerSet(net), env)
+		require.NoError(t, err)
+		objs = append(objs, &net, &ds, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      "manager-" + name,
+			Namespace: "vlanman-system",
+			Labels:    map[string]string{vlanmanv1.ManagerSetLabelKey: name},
+		}})
+	}
+	reconciler := &VlanmanReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+		Scheme: scheme,
+		Env:    env,
+	}
+
+	mgrs, conns, err := reconciler.getCurrentState(context.Background(), "net1")
+	require.NoError(t, err)
+	require.Len(t, mgrs, 1)
+	assert.Equal(t, "net1", mgrs[0].Labels[vlanmanv1.ManagerSetLabelKey])
+	require.Len(t, conns, 1)
+	assert.Equal(t, "net1", conns[0].Name)
+	assert.Equal(t, []string{"manager-net1"}, conns[0].Managers)
+
+	// the manager of a deleted network is still returned so that it's deleted
+	mgrs, conns, err = reconciler.getCurrentState(context.Background(), "gone")
+	require.NoError(t, err)
+	assert.Empty(t, mgrs)
+	assert.Empty(t, conns)
+}
+
+func TestWorkerPodToNetworks(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      "worker",
+		Namespace: "default",
+		Labels: map[string]string{
+			vlanmanv1.WorkerPodLabelKey:                    "net1",
+			vlanmanv1.WorkerPodNetworkLabelPrefix + "net1": "true",
+			vlanmanv1.WorkerPodNetworkLabelPrefix + "net2": "true",
+			"app": "web",
+		},
+	}}
+	names := []string{}
+	for _, req := range workerPodToNetworks(context.Background(), pod) {
+		assert.Empty(t, req.Namespace)
+		names = append(names, req.Name)
+	}
+	assert.ElementsMatch(t, []string{"net1", "net2"}, names)
+}
+
+func TestVlanmanReconciler_reconcileNetworkFinalizer(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	_ = batchv1.AddToScheme(scheme)
+	_ = vlanmanv1.AddToScheme(scheme)
+	env := Envs{NamespaceName: "vlanman-system"}
+	ctx := context.Background()
+
+	net := &vlanmanv1.VlanNetwork{ObjectMeta: metav1.ObjectMeta{Name: "net1"}, Spec: vlanmanv1.VlanNetworkSpec{VlanID: 10}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(net).WithStatusSubresource(net).Build()
+	reconciler := &VlanmanReconciler{Client: c, Scheme: scheme, Env: env}
+
+	_, err := reconciler.reconcileNetwork(ctx, "net1")
+	require.NoError(t, err)
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: "net1"}, net))
+	assert.Contains(t, net.Finalizers, vlanmanv1.NetworkFinalizer)
+
+	ds, err := daemonSetFromManager(createDesiredManagerSet(*net), env)
+	require.NoError(t, err)
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: ds.Name, Namespace: ds.Namespace}, &ds))
+	owner := metav1.GetControllerOf(&ds)
+	require.NotNil(t, owner)
+	assert.Equal(t, "net1", owner.Name)
+	svc := serviceForManagerSet(createDesiredManagerSet(*net), env.NamespaceName)
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}, &svc))
+	assert.NotNil(t, metav1.GetControllerOf(&svc))
+
+	manager := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      "manager-1",
+		Namespace: env.NamespaceName,
+		Labels:    map[string]string{vlanmanv1.ManagerSetLabelKey: "net1"},
+	}}
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+		Name:      "create-vlan-job-net1-node1",
+		Namespace: env.NamespaceName,
+		Labels:    map[string]string{vlanmanv1.InterfaceJobNetworkLabelKey: "net1"},
+	}}
+	require.NoError(t, c.Create(ctx, manager))
+	require.NoError(t, c.Create(ctx, job))
+	require.NoError(t, c.Delete(ctx, net))
+
+	// the finalizer stays until the manager pods are gone
+	rq, err := reconciler.reconcileNetwork(ctx, "net1")
+	require.NoError(t, err)
+	assert.NotNil(t, rq)
+	assert.True(t, apierrors.IsNotFound(c.Get(ctx, types.NamespacedName{Name: ds.Name, Namespace: ds.Namespace}, &appsv1.DaemonSet{})))
+	assert.True(t, apierrors.IsNotFound(c.Get(ctx, types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}, &corev1.Service{})))
+	assert.True(t, apierrors.IsNotFound(c.Get(ctx, types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, &batchv1.Job{})))
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: "net1"}, net))
+
+	require.NoError(t, c.Delete(ctx, manager))
+	rq, err = reconciler.reconcileNetwork(ctx, "net1")
+	require.NoError(t, err)
+	assert.Nil(t, rq)
+	assert.True(t, apierrors.IsNotFound(c.Get(ctx, types.NamespacedName{Name: "net1"}, net)))
+}
+
+func TestVlanmanReconciler_teardownNodes(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = batchv1.AddToScheme(scheme)
+	_ = vlanmanv1.AddToScheme(scheme)
+	ctx := context.Background()
+
+	net := &vlanmanv1.VlanNetwork{
+		ObjectMeta: metav1.ObjectMeta{Name: "net1", UID: "net1-uid"},
+		Spec:       vlanmanv1.VlanNetworkSpec{VlanID: 10, ServiceVlanID: 300},
+		Status: vlanmanv1.VlanNetworkStatus{Nodes: map[string]vlanmanv1.NodeAttachment{
+			"node1": {Pod: "manager-1"},
+			"gone":  {Pod: "manager-2"},
+		}},
+	}
+	shared := &vlanmanv1.VlanNetwork{
+		ObjectMeta: metav1.ObjectMeta{Name: "net2"},
+		Spec:       vlanmanv1.VlanNetworkSpec{VlanID: 20, ServiceVlanID: 300},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		net, shared,
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+	).Build()
+	recorder := record.NewFakeRecorder(10)
+	reconciler := &VlanmanReconciler{Client: c, Scheme: scheme, Env: Envs{NamespaceName: "vlanman-system"}, Recorder: recorder}
+
+	done, err := reconciler.teardownNodes(ctx, net)
+	require.NoError(t, err)
+	assert.False(t, done)
+
+	jobs := batchv1.JobList{}
+	require.NoError(t, c.List(ctx, &jobs))
+	// nodes that were removed from the cluster are skipped
+	require.Len(t, jobs.Items, 1)
+	job := jobs.Items[0]
+	assert.Equal(t, "delete-vlan-job-net1-node1", job.Name)
+	assert.Equal(t, "net1", metav1.GetControllerOf(&job).Name)
+	// net2 still uses the service tag
+	assert.Contains(t, job.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{Name: "REMOVE_SERVICE_VLAN", Value: "false"})
+
+	done, err = reconciler.teardownNodes(ctx, net)
+	require.NoError(t, err)
+	assert.False(t, done)
+	assert.Empty(t, recorder.Events)
+
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	require.NoError(t, c.Status().Update(ctx, &job))
+	require.NoError(t, c.Create(ctx, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "delete-vlan-job-net1-node1-abcde", Namespace: "vlanman-system", Labels: map[string]string{"job-name": job.Name}},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: "vlan300.10 (0 addresses, 0 routes)"}},
+		}}},
+	}))
+
+	done, err = reconciler.teardownNodes(ctx, net)
+	require.NoError(t, err)
+	assert.True(t, done)
+	require.Len(t, recorder.Events, 1)
+	assert.Equal(t, "Normal TeardownComplete Removed vlan interfaces from node node1: vlan300.10 (0 addresses, 0 routes)", <-recorder.Events)
+}
+
+func TestVlanmanReconciler_rolloutManagers(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	_ = coordinationv1.AddToScheme(scheme)
+	_ = vlanmanv1.AddToScheme(scheme)
+
+	daemonSet := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "vlan-manager-net1",
+			Namespace:   "vlanman-system",
+			Annotations: map[string]string{appsv1.DeprecatedTemplateGeneration: "2"},
+		},
+		Spec: appsv1.DaemonSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Env: []corev1.EnvVar{{Name: "VLAN_ID", Value: "10"}}}},
+		}}},
+		Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: 3},
+	}
+	managerPod := func(name, generation, vlanID string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "vlanman-system",
+				Labels: map[string]string{
+					vlanmanv1.ManagerSetLabelKey:                "net1",
+					vlanmanv1.ManagerTemplateGenerationLabelKey: generation,
+				},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Env: []corev1.EnvVar{{Name: "VLAN_ID", Value: vlanID}}}}},
+		}
+	}
+	pods := []client.Object{managerPod("manager-a", "1", "10"), managerPod("manager-b", "1", "10"), managerPod("manager-c", "2", "10")}
+	allUp := map[string]vlanmanv1.ConnectionState{"manager-a": vlanmanv1.StateUp, "manager-b": vlanmanv1.StateUp, "manager-c": vlanmanv1.StateUp}
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: vlanmanv1.LeaderElectionLeaseName + "-net1", Namespace: "vlanman-system"},
+		Spec:       coordinationv1.LeaseSpec{HolderIdentity: u.Ptr("manager-a")},
+	}
+
+	tests := []struct {
+		name     string
+		objects  []client.Object
+		state    map[string]vlanmanv1.ConnectionState
+		gateways []vlanmanv1.Gateway
+		recreate []*RecreateManagerPodAction
+		expected []string
+		handover bool
+	}{
+		{
+			name:     "one outdated pod at a time",
+			objects:  pods,
+			state:    allUp,
+			expected: []string{"manager-a"},
+			handover: true,
+		},
+		{
+			name:     "gateway leader goes last",
+			objects:  append([]client.Object{lease}, pods...),
+			state:    allUp,
+			gateways: []vlanmanv1.Gateway{{Address: "192.168.1.1"}},
+			expected: []string{"manager-b"},
+			handover: true,
+		},
+		{
+			name:     "vlan changed",
+			objects:  []client.Object{managerPod("manager-a", "1", "20"), managerPod("manager-b", "2", "10"), managerPod("manager-c", "2", "10")},
+			state:    allUp,
+			expected: []string{"manager-a"},
+		},
+		{
+			name:    "waits for the replacement to come up",
+			objects: pods,
+			state:   map[string]vlanmanv1.ConnectionState{"manager-a": vlanmanv1.StateUp, "manager-b": vlanmanv1.StateUp},
+		},
+		{
+			name:     "outdated pods that are down are recreated right away",
+			objects:  pods,
+			state:    map[string]vlanmanv1.ConnectionState{"manager-a": vlanmanv1.StateDown, "manager-b": vlanmanv1.StateDown, "manager-c": vlanmanv1.StateUp},
+			expected: []string{"manager-a", "manager-b"},
+			handover: true,
+		},
+		{
+			name:    "waits for missing pods",
+			objects: pods[:2],
+			state:   allUp,
+		},
+		{
+			name:     "parent changes are rolled out as well",
+			objects:  []client.Object{managerPod("manager-a", "2", "10"), managerPod("manager-b", "2", "10"), managerPod("manager-c", "2", "10")},
+			state:    allUp,
+			recreate: []*RecreateManagerPodAction{{OwnerNetwork: "net1", PodName: "manager-c"}},
+			expected: []string{"manager-c"},
+		},
+		{
+			name:    "up to date",
+			objects: []client.Object{managerPod("manager-a", "2", "10"), managerPod("manager-b", "2", "10"), managerPod("manager-c", "2", "10")},
+			state:   allUp,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(daemonSet.DeepCopy()).WithObjects(tt.objects...).Build()
+			reconciler := &VlanmanReconciler{Client: c, Scheme: scheme, Env: Envs{NamespaceName: "vlanman-system"}}
+			net := vlanmanv1.VlanNetwork{
+				ObjectMeta: metav1.ObjectMeta{Name: "net1"},
+				Spec:       vlanmanv1.VlanNetworkSpec{Gateways: tt.gateways},
+				Status:     vlanmanv1.VlanNetworkStatus{State: tt.state},
+			}
+
+			acts, err := reconciler.rolloutManagers(context.Background(), net, tt.recreate)
+			require.NoError(t, err)
+			names := []string{}
+			for _, a := range acts {
+				switch a := a.(type) {
+				case *RolloutManagerPodAction:
+					assert.Equal(t, "2", a.To)
+					assert.Equal(t, tt.handover, a.Handover)
+					names = append(names, a.PodName)
+				case *RecreateManagerPodAction:
+					names = append(names, a.PodName)
+				}
+			}
+			assert.ElementsMatch(t, tt.expected, names)
+		})
+	}
+}
+
+func TestVlanmanReconciler_reconcileWorkerPodRelease(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = vlanmanv1.AddToScheme(scheme)
+
+	pod := func(uid types.UID, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "default", UID: uid},
+			Status:     corev1.PodStatus{Phase: phase},
+		}
+	}
+	allocation := func(uid types.UID, sticky bool) *vlanmanv1.VlanIPAllocation {
+		alloc := &vlanmanv1.VlanIPAllocation{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "net1-abc",
+				Namespace: "default",
+				Labels:    map[string]string{vlanmanv1.AllocationNetworkLabelKey: "net1"},
+			},
+			Spec:   vlanmanv1.VlanIPAllocationSpec{Network: "net1", Pool: "pool1", Addresses: []string{"10.0.0.5/24"}},
+			Status: vlanmanv1.VlanIPAllocationStatus{Phase: vlanmanv1.AllocationBound, PodName: "worker"},
+		}
+		if sticky {
+			alloc.Labels[vlanmanv1.AllocationClaimLabelKey] = "claim"
+		} else {
+			alloc.OwnerReferences = []metav1.OwnerReference{{APIVersion: "v1", Kind: "Pod", Name: "worker", UID: uid}}
+		}
+		return alloc
+	}
+
+	tests := []struct {
+		name          string
+		objects       []client.Object
+		expectRelease bool
+	}{
+		{
+			name:          "pod was deleted",
+			objects:       []client.Object{allocation("uid1", false)},
+			expectRelease: true,
+		},
+		{
+			name:          "pod failed",
+			objects:       []client.Object{pod("uid1", corev1.PodFailed), allocation("uid1", false)},
+			expectRelease: true,
+		},
+		{
+			name:          "pod succeeded",
+			objects:       []client.Object{pod("uid1", corev1.PodSucceeded), allocation("uid1", false)},
+			expectRelease: true,
+		},
+		{
+			name:    "pod is running",
+			objects: []client.Object{pod("uid1", corev1.PodRunning), allocation("uid1", false)},
+		},
+		{
+			name:    "finished pod with the same name doesn't own the allocation",
+			objects: []client.Object{pod("uid2", corev1.PodFailed), allocation("uid1", false)},
+		},
+		{
+			name:    "sticky allocation is kept",
+			objects: []client.Object{allocation("", true)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(tt.objects...).
+				WithStatusSubresource(&vlanmanv1.VlanIPAllocation{}).Build()
+			reconciler := &VlanmanReconciler{Client: c, Scheme: scheme}
+
+			err := reconciler.reconcileWorkerPod(context.Background(), types.NamespacedName{Name: "worker", Namespace: "default"})
+			require.NoError(t, err)
+
+			err = c.Get(context.Background(), types.NamespacedName{Name: "net1-abc", Namespace: "default"}, &vlanmanv1.VlanIPAllocation{})
+			assert.Equal(t, tt.expectRelease, apierrors.IsNotFound(err))
+		})
+	}
+}
+
+func TestVlanmanReconciler_expireAllocation(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = vlanmanv1.AddToScheme(scheme)
+
+	allocation := func(phase vlanmanv1.AllocationPhase, deleted time.Time) *vlanmanv1.VlanIPAllocation {
+		return &vlanmanv1.VlanIPAllocation{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "net1-abc",
+				Namespace:         "default",
+				Labels:            map[string]string{vlanmanv1.AllocationNetworkLabelKey: "net1"},
+				Finalizers:        []string{vlanmanv1.AllocationFinalizer},
+				DeletionTimestamp: &metav1.Time{Time: deleted},
+			},
+			Spec:   vlanmanv1.VlanIPAllocationSpec{Network: "net1", Pool: "pool1", Addresses: []string{"10.0.0.5/24"}},
+			Status: vlanmanv1.VlanIPAllocationStatus{Phase: phase, PodName: "worker"},
+		}
+	}
+
+	tests := []struct {
+		name          string
+		alloc         *vlanmanv1.VlanIPAllocation
+		delay         time.Duration
+		expectKept    bool
+		expectRequeue bool
+	}{
+		{
+			name:          "delay didn't pass",
+			alloc:         allocation(vlanmanv1.AllocationBound, time.Now()),
+			delay:         time.Minute,
+			expectKept:    true,
+			expectRequeue: true,
+		},
+		{
+			name:  "delay passed",
+			alloc: allocation(vlanmanv1.AllocationBound, time.Now().Add(-2*time.Minute)),
+			delay: time.Minute,
+		},
+		{
+			name:  "never bound",
+			alloc: allocation(vlanmanv1.AllocationPending, time.Now()),
+			delay: time.Minute,
+		},
+		{
+			name:  "no delay",
+			alloc: allocation(vlanmanv1.AllocationReserved, time.Now()),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(tt.alloc).
+				WithStatusSubresource(&vlanmanv1.VlanIPAllocation{}).Build()
+			reconciler := &VlanmanReconciler{Client: c, Scheme: scheme}
+
+			current := vlanmanv1.VlanIPAllocation{}
+			require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "net1-abc", Namespace: "default"}, &current))
+			left, err := reconciler.expireAllocation(context.Background(), &current, tt.delay)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectRequeue, left > 0)
+
+			err = c.Get(context.Background(), types.NamespacedName{Name: "net1-abc", Namespace: "default"}, &current)
+			if !tt.expectKept {
+				assert.True(t, apierrors.IsNotFound(err))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, vlanmanv1.AllocationReleased, current.Status.Phase)
+		})
+	}
+}
+
+func TestVlanmanReconciler_updateVlanNetworkStatusCollectsAllocations(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	_ = batchv1.AddToScheme(scheme)
+	_ = vlanmanv1.AddToScheme(scheme)
+
+	net := &vlanmanv1.VlanNetwork{
+		ObjectMeta: metav1.ObjectMeta{Name: "net1"},
+		Spec: vlanmanv1.VlanNetworkSpec{
+			VlanID:                     10,
+			AddressReleaseDelaySeconds: 60,
+			Pools:                      []vlanmanv1.VlanNetworkPool{{Name: "pool1", Addresses: []string{"10.0.0.1-10.0.0.4/24"}}},
+		},
+	}
+	allocation := func(name, podName string, finalizer bool) *vlanmanv1.VlanIPAllocation {
+		alloc := &vlanmanv1.VlanIPAllocation{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       "default",
+				Labels:          map[string]string{vlanmanv1.AllocationNetworkLabelKey: "net1"},
+				OwnerReferences: []metav1.OwnerReference{{APIVersion: "v1", Kind: "Pod", Name: podName, UID: types.UID(podName)}},
+			},
+			Spec:   vlanmanv1.VlanIPAllocationSpec{Network: "net1", Pool: "pool1", Addresses: []string{"10.0.0." + name[len(name)-1:] + "/24"}},
+			Status: vlanmanv1.VlanIPAllocationStatus{Phase: vlanmanv1.AllocationBound, PodName: podName},
+		}
+		if finalizer {
+			alloc.Finalizers = []string{vlanmanv1.AllocationFinalizer}
+		}
+		return alloc
+	}
+	running := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "running", Namespace: "default", UID: "running"},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	failed := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "failed", Namespace: "default", UID: "failed"},
+		Status:     corev1.PodStatus{Phase: corev1.PodFailed},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).
+		WithObjects(net, running, failed,
+			allocation("alloc-1", "running", true),
+			allocation("alloc-2", "failed", true),
+			allocation("alloc-3", "gone", false),
+		).
+		WithStatusSubresource(&vlanmanv1.VlanIPAllocation{}, &vlanmanv1.VlanNetwork{}).Build()
+	reconciler := &VlanmanReconciler{Client: c, Scheme: scheme, Env: Envs{NamespaceName: "vlanman-system"}}
+
+	rq, err := reconciler.updateVlanNetworkStatus(context.Background(), net)
+	require.NoError(t, err)
+	require.NotNil(t, rq)
+	assert.LessOrEqual(t, *rq, time.Second*vlanmanv1.AllocationGCIntervalSeconds)
+
+	// the allocation of the failed pod is released but its address is kept for the delay
+	assert.Equal(t, int64(2), net.Status.FreeIPCount["pool1"])
+	released := vlanmanv1.VlanIPAllocation{}
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "alloc-2", Namespace: "default"}, &released))
+	assert.NotNil(t, released.DeletionTimestamp)
+	err = c.Get(context.Background(), types.NamespacedName{Name: "alloc-3", Namespace: "default"}, &vlanmanv1.VlanIPAllocation{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	// the next pass marks it released and requeues when the delay passes
+	rq, err = reconciler.updateVlanNetworkStatus(context.Background(), net)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, *rq, time.Minute)
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "alloc-2", Namespace: "default"}, &released))
+	assert.Equal(t, vlanmanv1.AllocationReleased, released.Status.Phase)
+}
+
+func TestVlanmanReconciler_updateVlanNetworkStatusBindsPendingAllocations(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	_ = batchv1.AddToScheme(scheme)
+	_ = vlanmanv1.AddToScheme(scheme)
+
+	net := &vlanmanv1.VlanNetwork{
+		ObjectMeta: metav1.ObjectMeta{Name: "net1"},
+		Spec: vlanmanv1.VlanNetworkSpec{
+			VlanID: 10,
+			Pools:  []vlanmanv1.VlanNetworkPool{{Name: "pool1", Addresses: []string{"10.0.0.1-10.0.0.4/24"}}},
+		},
+	}
+	// both allocations are older than the bind timeout
+	allocation := func(name, address string) *vlanmanv1.VlanIPAllocation {
+		return &vlanmanv1.VlanIPAllocation{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "default",
+				Labels:            map[string]string{vlanmanv1.AllocationNetworkLabelKey: "net1"},
+				CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Minute)),
+			},
+			Spec:   vlanmanv1.VlanIPAllocationSpec{Network: "net1", Pool: "pool1", Addresses: []string{address}},
+			Status: vlanmanv1.VlanIPAllocationStatus{Phase: vlanmanv1.AllocationPending},
+		}
+	}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:        "worker",
+		Namespace:   "default",
+		UID:         "worker",
+		Labels:      map[string]string{u.WorkerNetworkLabelKey("net1"): "true"},
+		Annotations: map[string]string{vlanmanv1.PodVlanmanAllocationAnnotation: "net2-xyz,net1-abc"},
+	}}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).
+		WithObjects(net, pod, allocation("net1-abc", "10.0.0.1/24"), allocation("net1-rejected", "10.0.0.2/24")).
+		WithStatusSubresource(&vlanmanv1.VlanIPAllocation{}, &vlanmanv1.VlanNetwork{}).Build()
+	reconciler := &VlanmanReconciler{Client: c, Scheme: scheme, Env: Envs{NamespaceName: "vlanman-system"}}
+
+	_, err := reconciler.updateVlanNetworkStatus(context.Background(), net)
+	require.NoError(t, err)
+
+	// the allocation of the existing pod is bound instead of deleted, the other one is released
+	bound := vlanmanv1.VlanIPAllocation{}
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "net1-abc", Namespace: "default"}, &bound))
+	assert.Equal(t, vlanmanv1.AllocationBound, bound.Status.Phase)
+	assert.Equal(t, "worker", bound.Status.PodName)
+	assert.Len(t, bound.OwnerReferences, 1)
+	err = c.Get(context.Background(), types.NamespacedName{Name: "net1-rejected", Namespace: "default"}, &vlanmanv1.VlanIPAllocation{})
+	assert.True(t, apierrors.IsNotFound(err))
+	assert.Equal(t, int64(3), net.Status.FreeIPCount["pool1"])
+}
+
+func TestVlanmanReconciler_updateVlanNetworkStatusQuarantine(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	_ = batchv1.AddToScheme(scheme)
+	_ = vlanmanv1.AddToScheme(scheme)
+
+	net := &vlanmanv1.VlanNetwork{
+		ObjectMeta: metav1.ObjectMeta{Name: "net1"},
+		Spec: vlanmanv1.VlanNetworkSpec{
+			VlanID: 10,
+			Pools:  []vlanmanv1.VlanNetworkPool{{Name: "pool1", Addresses: []string{"10.0.0.1-10.0.0.4/24"}}},
+		},
+		Status: vlanmanv1.VlanNetworkStatus{QuarantinedAddresses: []vlanmanv1.QuarantinedAddress{
+			{Address: "10.0.0.2", MACAddress: "aa:bb:cc:dd:ee:ff", LastDetected: metav1.NewTime(time.Now().Add(-time.Minute))},
+			{Address: "10.0.0.3", LastDetected: metav1.NewTime(time.Now().Add(-2 * time.Second * vlanmanv1.AddressQuarantineSeconds))},
+		}},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(net).WithStatusSubresource(&vlanmanv1.VlanNetwork{}).Build()
+	reconciler := &VlanmanReconciler{Client: c, Scheme: scheme, Env: Envs{NamespaceName: "vlanman-system"}}
+
+	rq, err := reconciler.updateVlanNetworkStatus(context.Background(), net)
+	require.NoError(t, err)
+	require.NotNil(t, rq)
+
+	// the expired address is free again, the other one until its quarantine ends
+	require.Len(t, net.Status.QuarantinedAddresses, 1)
+	assert.Equal(t, "10.0.0.2", net.Status.QuarantinedAddresses[0].Address)
+	assert.Equal(t, int64(3), net.Status.FreeIPCount["pool1"])
+	assert.LessOrEqual(t, *rq, time.Second*vlanmanv1.AddressQuarantineSeconds-time.Minute)
+}
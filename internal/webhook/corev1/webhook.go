@@ -3,14 +3,19 @@ package corev1
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	vlanmanv1 "dialo.ai/vlanman/api/v1"
 	"dialo.ai/vlanman/internal/controller"
 	errs "dialo.ai/vlanman/pkg/errors"
+	"dialo.ai/vlanman/pkg/ipam"
 	"dialo.ai/vlanman/pkg/locker"
 	u "dialo.ai/vlanman/pkg/utils"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -18,22 +23,29 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 
 	"maps"
+	"net/netip"
 	"slices"
+	"strconv"
 	"strings"
-	"time"
+	"unicode"
 
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
 func SetupVlanmanWebhookWithManager(mgr ctrl.Manager, e controller.Envs) error {
 	return ctrl.NewWebhookManagedBy(mgr).
 		For(&corev1.Pod{}).
 		WithDefaulter(&VlanmanPodCustomDefaulter{
-			Client: mgr.GetClient(),
-			Config: *mgr.GetConfig(),
-			Env:    e,
+			Client:   mgr.GetClient(),
+			Reader:   mgr.GetAPIReader(),
+			Config:   *mgr.GetConfig(),
+			Recorder: mgr.GetEventRecorderFor("vlanman-webhook"),
+			Env:      e,
 		}).
 		Complete()
 }
@@ -41,27 +53,94 @@ func SetupVlanmanWebhookWithManager(mgr ctrl.Manager, e controller.Envs) error {
 // +kubebuilder:webhook:path=/mutate--v1-pod,mutating=true,failurePolicy=fail,sideEffects=NoneOnDryRun,groups="",resources=pods,verbs=create,versions=v1,name=webhook.vlanman.dialo.ai,admissionReviewVersions=v1,serviceName=replaceme[.Values.webhook.serviceName],servicePort=443,serviceNamespace=replaceme[.Values.global.namespace]
 
 type VlanmanPodCustomDefaulter struct {
-	Client client.Client
-	Config rest.Config
-	Env    controller.Envs
+	Client   client.Client
+	Reader   client.Reader
+	Config   rest.Config
+	Recorder record.EventRecorder
+	Env      controller.Envs
 }
 
 var _ webhook.CustomDefaulter = &VlanmanPodCustomDefaulter{}
 
+// attachment is a network the pod is attached to, together with
+// everything needed to patch the pod for it
+type attachment struct {
+	NetworkName string
+	PoolName    string
+	Network     *vlanmanv1.VlanNetwork
+	IPs         []string
+	Endpoints   map[string]string
+	Routes      string
+}
+
+// parseAttachments reads the networks and pools a pod is attached to. The network annotation is either a
+// single network name, with the pool in the pool annotation, or a comma separated list of network/pool pairs.
+// Entries without a pool fall back to the pool annotation.
+func parseAttachments(pod *corev1.Pod) ([]attachment, error) {
+	networkNames, existsNet := pod.Annotations[vlanmanv1.PodVlanmanNetworkAnnotation]
+	defaultPool, existsPool := pod.Annotations[vlanmanv1.PodVlanmanIPPoolAnnotation]
+	if !existsNet && !existsPool {
+		return nil, nil
+	}
+	resource := fmt.Sprintf("%s@%s", pod.Name, pod.Namespace)
+	if !existsNet {
+		return nil, &errs.MissingAnnotationError{Resource: resource}
+	}
+
+	attachments := []attachment{}
+	for entry := range strings.SplitSeq(networkNames, ",") {
+		networkName, poolName, hasPool := strings.Cut(strings.TrimSpace(entry), "/")
+		if !hasPool {
+			poolName = defaultPool
+		}
+		if networkName == "" || poolName == "" {
+			return nil, &errs.MissingAnnotationError{Resource: resource}
+		}
+		if slices.ContainsFunc(attachments, func(a attachment) bool { return a.NetworkName == networkName }) {
+			return nil, errs.NewParsingError(
+				fmt.Sprintf("annotation %s", vlanmanv1.PodVlanmanNetworkAnnotation),
+				fmt.Errorf("network %s is listed more than once", networkName),
+			)
+		}
+		attachments = append(attachments, attachment{NetworkName: networkName, PoolName: poolName})
+	}
+	return attachments, nil
+}
+
+// allocationFailedEvent records the failure on the network, the pod
+// doesn't exist yet so its events couldn't be found
+func (v *VlanmanPodCustomDefaulter) allocationFailedEvent(pod *corev1.Pod, a *attachment, err error) {
+	if v.Recorder == nil {
+		return
+	}
+	name := pod.Name
+	if name == "" {
+		name = pod.GenerateName + "*"
+	}
+	reason := vlanmanv1.EventReasonAllocationFailed
+	if errors.Is(err, errs.ErrNoIPInPool) {
+		reason = vlanmanv1.EventReasonPoolExhausted
+	}
+	v.Recorder.Eventf(a.Network, corev1.EventTypeWarning, reason, "Couldn't allocate addresses from pool %s for pod %s/%s: %s", a.PoolName, pod.Namespace, name, err)
+}
+
 func (v *VlanmanPodCustomDefaulter) Default(ctx context.Context, obj runtime.Object) error {
 	pod, ok := obj.(*corev1.Pod)
 	if !ok {
 		return errs.NewTypeMismatchError("Mutating webhook", obj)
 	}
 
-	networkName, existsNet := pod.Annotations[vlanmanv1.PodVlanmanNetworkAnnotation]
-	poolName, existsPool := pod.Annotations[vlanmanv1.PodVlanmanIPPoolAnnotation]
-	if !existsNet && !existsPool {
-		return nil
+	attachments, err := parseAttachments(pod)
+	if err != nil || len(attachments) == 0 {
+		return err
 	}
 
-	if !existsNet || !existsPool {
-		return &errs.MissingAnnotationError{Resource: fmt.Sprintf("%s@%s", pod.Name, pod.Namespace)}
+	dryRun := false
+	if req, err := admission.RequestFromContext(ctx); err == nil {
+		dryRun = req.DryRun != nil && *req.DryRun
+		if pod.Namespace == "" {
+			pod.Namespace = req.Namespace
+		}
 	}
 
 	clientSet, err := kubernetes.NewForConfig(&v.Config)
@@ -71,62 +150,91 @@ func (v *VlanmanPodCustomDefaulter) Default(ctx context.Context, obj runtime.Obj
 			Err:     err,
 		}
 	}
-	locker, err := locker.NewLeaseLocker(false, *clientSet, vlanmanv1.LeaseName, v.Env.NamespaceName)
+	locker, err := locker.NewLeaseLocker(dryRun, *clientSet, vlanmanv1.LeaseName, v.Env.NamespaceName)
+	if err != nil {
+		return err
+	}
 	locker.Lock()
 
-	network := &vlanmanv1.VlanNetwork{}
-	err = v.Client.Get(ctx, types.NamespacedName{Namespace: "", Name: networkName}, network)
-	if err != nil {
-		locker.Unlock()
-		return &errs.ClientRequestError{
-			Action: "Get VlanNetwork",
-			Err:    err,
+	interfaceNames := map[string]string{}
+	for idx := range attachments {
+		a := &attachments[idx]
+		a.Network = &vlanmanv1.VlanNetwork{}
+		err = v.Client.Get(ctx, types.NamespacedName{Namespace: "", Name: a.NetworkName}, a.Network)
+		if err != nil {
+			locker.Unlock()
+			return &errs.ClientRequestError{
+				Action: "Get VlanNetwork",
+				Err:    err,
+			}
 		}
+		name := interfaceName(a.Network)
+		if other, ok := interfaceNames[name]; ok {
+			locker.Unlock()
+			return &errs.InterfaceNameConflictError{
+				Resource: fmt.Sprintf("%s@%s", pod.Name, pod.Namespace),
+				Name:     name,
+				Networks: []string{other, a.NetworkName},
+			}
+		}
+		interfaceNames[name] = a.NetworkName
 	}
 
-	network.Status = u.PopulateStatus(network.Status, poolName)
-	pool := network.Status.FreeIPs[poolName]
-	if network.Status.PendingIPs == nil {
-		network.Status.PendingIPs = map[string]map[string]string{}
+	// allocations created before a failure are never bound to a pod,
+	// so the controller releases them after AllocationBindTimeoutSeconds
+	allocNames := []string{}
+	for idx := range attachments {
+		a := &attachments[idx]
+		alloc, err := v.allocate(ctx, pod, a.Network, a.PoolName, len(attachments) == 1, dryRun)
+		if err != nil {
+			locker.Unlock()
+			if !dryRun {
+				v.allocationFailedEvent(pod, a, err)
+			}
+			return err
+		}
+		a.IPs = alloc.Spec.Addresses
+		allocNames = append(allocNames, alloc.Name)
 	}
+	locker.Unlock()
+	pod.Annotations[vlanmanv1.PodVlanmanAllocationAnnotation] = strings.Join(allocNames, ",")
 
-	pendingMap := network.Status.PendingIPs[poolName]
-	if pendingMap == nil {
-		pendingMap = map[string]string{}
-	}
+	for idx := range attachments {
+		a := &attachments[idx]
+		a.Endpoints, err = v.managerEndpoints(ctx, pod, a.Network)
+		if err != nil {
+			return err
+		}
 
-	found := false
-	var assignedIP *string = nil
-	for _, IP := range pool {
-		if slices.Contains(slices.Collect(maps.Keys(pendingMap)), IP) {
-			continue
+		routes := []vlanmanv1.Route{}
+		for _, pool := range a.Network.Spec.Pools {
+			if pool.Name != a.PoolName {
+				continue
+			}
+			routes = pool.Routes
+			break
 		}
-		found = true
-		assignedIP = &IP
-		pendingMap[IP] = time.Now().Format(time.Layout)
-		break
-	}
-	if !found || assignedIP == nil {
-		locker.Unlock()
-		return &errs.NoIPInPoolError{
-			Resource: fmt.Sprintf("%s@%s", pod.Name, pod.Namespace),
+
+		routesJSON, err := json.Marshal(routes)
+		if err != nil {
+			return &errs.ParsingError{
+				Source: "Marshaling routes",
+				Err:    err,
+			}
 		}
+		a.Routes = string(routesJSON)
 	}
 
-	network.Status.PendingIPs[poolName] = pendingMap
-	err = v.Client.Status().Update(ctx, network)
-	if err != nil {
-		return errs.NewClientRequestError(
-			"Update status in mutating webhook",
-			err,
-		)
-	}
-	locker.Unlock()
+	applyPatch(pod, attachments, v.Env.WorkerInitImage, v.Env.WorkerInitPullPolicy)
+	return nil
+}
 
+// managerEndpoints returns the pod IPs of the network's managers by node name
+func (v *VlanmanPodCustomDefaulter) managerEndpoints(ctx context.Context, pod *corev1.Pod, network *vlanmanv1.VlanNetwork) (map[string]string, error) {
 	managers := corev1.PodList{}
 	requirement, err := labels.NewRequirement(vlanmanv1.ManagerSetLabelKey, "==", []string{network.Name})
 	if err != nil {
-		return &errs.InternalError{
+		return nil, &errs.InternalError{
 			Context: fmt.Sprintf("mutating webhook list manager pods requirement fails to compile: %s", err.Error()),
 		}
 	}
@@ -135,10 +243,10 @@ func (v *VlanmanPodCustomDefaulter) Default(ctx context.Context, obj runtime.Obj
 		LabelSelector: selector,
 	})
 	if err != nil {
-		return errs.NewClientRequestError("Listing manager pods in mutating webhook", err)
+		return nil, errs.NewClientRequestError("Listing manager pods in mutating webhook", err)
 	}
 	if len(managers.Items) == 0 {
-		return &errs.NoManagerPodsError{
+		return nil, &errs.NoManagerPodsError{
 			Resource: fmt.Sprintf("%s@%s", pod.Name, pod.Namespace),
 			Network:  network.Name,
 		}
@@ -146,109 +254,437 @@ func (v *VlanmanPodCustomDefaulter) Default(ctx context.Context, obj runtime.Obj
 	endpoints := map[string]string{}
 	for _, man := range managers.Items {
 		if man.Status.PodIP == "" {
-			return &errs.ManagerNotReadyError{
+			return nil, &errs.ManagerNotReadyError{
 				Resource: fmt.Sprintf("%s@%s", pod.Name, pod.Namespace),
 				Manager:  fmt.Sprintf("%s@%s", man.Name, man.Namespace),
 			}
 		}
 		endpoints[man.Spec.NodeName] = man.Status.PodIP
 	}
+	return endpoints, nil
+}
+
+// stickyClaim returns the name, labels and owners of the allocation for pods whose addresses
+// should survive pod deletion: StatefulSet pods in sticky pools (or annotated as sticky),
+// keyed by StatefulSet name and ordinal, and pods with an explicit claim annotation
+func stickyClaim(pod *corev1.Pod, network string, pool vlanmanv1.VlanNetworkPool) (string, map[string]string, []metav1.OwnerReference, bool) {
+	if claim, ok := pod.Annotations[vlanmanv1.PodVlanmanClaimAnnotation]; ok && claim != "" {
+		return strings.Join([]string{network, "claim", claim}, "-"), map[string]string{
+			vlanmanv1.AllocationClaimLabelKey: claim,
+		}, nil, true
+	}
 
-	routes := []vlanmanv1.Route{} // network.Spec.Pools
-	for _, pool := range network.Spec.Pools {
-		if pool.Name != poolName {
+	if !pool.Sticky && pod.Annotations[vlanmanv1.PodVlanmanStickyAnnotation] != "true" {
+		return "", nil, nil, false
+	}
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil || owner.Kind != "StatefulSet" {
+		return "", nil, nil, false
+	}
+	ordinal, ok := pod.Labels[appsv1.PodIndexLabel]
+	if !ok {
+		// pods of a StatefulSet are always named <statefulset>-<ordinal>
+		ordinal = strings.TrimPrefix(pod.Name, owner.Name+"-")
+	}
+	if _, err := strconv.Atoi(ordinal); err != nil {
+		return "", nil, nil, false
+	}
+	return strings.Join([]string{network, owner.Name, ordinal}, "-"), map[string]string{
+		vlanmanv1.AllocationStatefulSetLabelKey: owner.Name,
+		vlanmanv1.AllocationOrdinalLabelKey:     ordinal,
+	}, []metav1.OwnerReference{*owner}, true
+}
+
+// requestedAddresses parses the static addresses requested by the pod, with the prefix lengths of the pool.
+// Pods attached to more than one network prefix the addresses with the network name (net-a=10.0.0.5),
+// unprefixed addresses are only used by pods attached to a single network.
+// Returns nil if the pod doesn't request any addresses in this network.
+func requestedAddresses(pod *corev1.Pod, network string, pool *ipam.Pool, single bool) ([]netip.Prefix, error) {
+	value, ok := pod.Annotations[vlanmanv1.PodVlanmanIPAnnotation]
+	if !ok || strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	resource := fmt.Sprintf("%s@%s", pod.Name, pod.Namespace)
+	var requested []netip.Prefix
+	for entry := range strings.SplitSeq(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if prefix, address, found := strings.Cut(entry, "="); found {
+			if strings.TrimSpace(prefix) != network {
+				continue
+			}
+			entry = strings.TrimSpace(address)
+		} else if !single {
 			continue
 		}
-		routes = pool.Routes
-		break
+		bare, _, _ := strings.Cut(entry, "/")
+		addr, err := netip.ParseAddr(bare)
+		if err != nil {
+			return nil, errs.NewParsingError(fmt.Sprintf("annotation %s", vlanmanv1.PodVlanmanIPAnnotation), err)
+		}
+		addr = addr.Unmap()
+		if slices.ContainsFunc(requested, func(p netip.Prefix) bool { return p.Addr().Is4() == addr.Is4() }) {
+			return nil, errs.NewParsingError(
+				fmt.Sprintf("annotation %s", vlanmanv1.PodVlanmanIPAnnotation),
+				fmt.Errorf("more than one address of the same family in '%s'", value),
+			)
+		}
+		prefix, ok := pool.Prefix(addr)
+		if !ok {
+			return nil, &errs.AddressNotInPoolError{Resource: resource, Address: addr.String(), Pool: pool.Name}
+		}
+		requested = append(requested, prefix)
 	}
+	// the IPv4 address always comes first, same as in ipam.Pool.Pick
+	slices.SortFunc(requested, func(a, b netip.Prefix) int {
+		return a.Addr().Compare(b.Addr())
+	})
+	return requested, nil
+}
 
-	routesJSON, err := json.Marshal(routes)
-	if err != nil {
-		return &errs.ParsingError{
-			Source: "Marshaling routes",
-			Err:    err,
+// reuseClaim returns the existing allocation of a sticky claim if its addresses can be given to the pod,
+// addresses found in use on the vlan aren't given out again
+func (v *VlanmanPodCustomDefaulter) reuseClaim(ctx context.Context, pod *corev1.Pod, alloc *vlanmanv1.VlanIPAllocation, pool *ipam.Pool, requested []netip.Prefix, quarantined map[netip.Addr]bool) (bool, error) {
+	if alloc.Status.PodName != "" && alloc.Status.PodName != pod.Name && alloc.Status.Phase == vlanmanv1.AllocationBound {
+		holder := corev1.Pod{}
+		err := v.Reader.Get(ctx, types.NamespacedName{Name: alloc.Status.PodName, Namespace: alloc.Namespace}, &holder)
+		if err == nil && holder.DeletionTimestamp == nil {
+			return false, &errs.ClaimInUseError{
+				Resource: fmt.Sprintf("%s@%s", pod.Name, pod.Namespace),
+				Claim:    alloc.Name,
+				Holder:   holder.Name,
+			}
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			return false, errs.NewClientRequestError("Get pod holding a claim", err)
 		}
 	}
-
-	applyPatch(pod, *network, v.Env.WorkerInitImage, v.Env.WorkerInitPullPolicy, *assignedIP, endpoints, string(routesJSON))
-	return nil
+	if alloc.Spec.Pool != pool.Name {
+		return false, nil
+	}
+	held := ipam.ParseAddrs(alloc.Spec.Addresses...)
+	if requested != nil && (len(requested) != len(held) || slices.ContainsFunc(requested, func(p netip.Prefix) bool {
+		return !held[p.Addr()]
+	})) {
+		return false, nil
+	}
+	for addr := range held {
+		if !pool.Contains(addr) || quarantined[addr] {
+			return false, nil
+		}
+	}
+	return true, nil
 }
 
-func applyPatch(pod *corev1.Pod, network vlanmanv1.VlanNetwork, image, pullPolicy, IP string, endpoints map[string]string, routes string) {
-	address, subnet, found := strings.Cut(IP, "/")
-	if !found {
-		subnet = "32"
+// allocate picks free addresses from the pool and records them in a VlanIPAllocation,
+// it has to be called with the IPAM lease held. The API reader is used instead of the cached client
+// because an allocation created by the previous call might not be in the cache yet.
+// Sticky claims reuse the addresses of their existing allocation, pods with the
+// 'vlanman.dialo.ai/ip' annotation get exactly the requested addresses or an error.
+func (v *VlanmanPodCustomDefaulter) allocate(ctx context.Context, pod *corev1.Pod, network *vlanmanv1.VlanNetwork, poolName string, single, dryRun bool) (*vlanmanv1.VlanIPAllocation, error) {
+	poolIdx := slices.IndexFunc(network.Spec.Pools, func(p vlanmanv1.VlanNetworkPool) bool {
+		return p.Name == poolName
+	})
+	if poolIdx == -1 {
+		return nil, &errs.UnknownPoolError{
+			Resource: fmt.Sprintf("%s@%s", pod.Name, pod.Namespace),
+			Network:  network.Name,
+			Pool:     poolName,
+		}
+	}
+	pool, err := ipam.NewPool(network.Spec.Pools[poolIdx])
+	if err != nil {
+		return nil, errs.NewParsingError(fmt.Sprintf("addresses of pool %s", poolName), err)
+	}
+	requested, err := requestedAddresses(pod, network.Name, pool, single)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(pod.Labels) != 0 {
-		pod.Labels[vlanmanv1.WorkerPodLabelKey] = network.Name
-	} else {
-		pod.Labels = map[string]string{
-			vlanmanv1.WorkerPodLabelKey: network.Name,
+	alloc := &vlanmanv1.VlanIPAllocation{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: network.Name + "-",
+			Namespace:    pod.Namespace,
+			Labels: map[string]string{
+				vlanmanv1.AllocationNetworkLabelKey: network.Name,
+				vlanmanv1.AllocationPoolLabelKey:    poolName,
+			},
+		},
+		Spec: vlanmanv1.VlanIPAllocationSpec{
+			Network: network.Name,
+			Pool:    poolName,
+		},
+	}
+
+	// addresses found in use on the vlan by worker pods
+	quarantined := ipam.Quarantined(network.Status.QuarantinedAddresses)
+	claimName, claimLabels, owners, sticky := stickyClaim(pod, network.Name, network.Spec.Pools[poolIdx])
+	exists := false
+	if sticky {
+		err = v.Reader.Get(ctx, types.NamespacedName{Name: claimName, Namespace: pod.Namespace}, alloc)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, errs.NewClientRequestError("Get VlanIPAllocation of a sticky claim", err)
+		}
+		exists = err == nil
+		if exists && alloc.DeletionTimestamp != nil {
+			// the allocation is kept until the release delay passed, it can't be reused
+			return nil, &errs.ClaimInUseError{
+				Resource: fmt.Sprintf("%s@%s", pod.Name, pod.Namespace),
+				Claim:    alloc.Name,
+				Holder:   "an allocation that's being released",
+			}
+		}
+		if exists {
+			if alloc.Spec.Network != network.Name {
+				return nil, &errs.ClaimInUseError{
+					Resource: fmt.Sprintf("%s@%s", pod.Name, pod.Namespace),
+					Claim:    alloc.Name,
+					Holder:   fmt.Sprintf("network %s", alloc.Spec.Network),
+				}
+			}
+			reuse, err := v.reuseClaim(ctx, pod, alloc, pool, requested, quarantined)
+			if err != nil {
+				return nil, err
+			}
+			if reuse {
+				return alloc, nil
+			}
 		}
+		alloc.Name = claimName
+		alloc.GenerateName = ""
+		alloc.OwnerReferences = owners
+		alloc.Labels[vlanmanv1.AllocationPoolLabelKey] = poolName
+		maps.Copy(alloc.Labels, claimLabels)
+		alloc.Spec.Pool = poolName
 	}
 
-	managers := []string{}
-	for k, v := range endpoints {
-		managers = append(managers, fmt.Sprintf("%s=%s", k, v))
+	allocs, err := ipam.ListAllocations(ctx, v.Reader, network.Name, "")
+	if err != nil {
+		return nil, err
+	}
+	// the addresses of a claim that's being moved to another pool are not taken
+	allocs = slices.DeleteFunc(allocs, func(a vlanmanv1.VlanIPAllocation) bool {
+		return exists && a.Name == alloc.Name && a.Namespace == alloc.Namespace
+	})
+	taken := ipam.Taken(allocs)
+	maps.Copy(taken, quarantined)
+	picked := requested
+	if picked == nil {
+		picked = pool.Pick(taken)
+	}
+	assignedIPs := []string{}
+	for _, prefix := range picked {
+		if requested != nil && taken[prefix.Addr()] {
+			return nil, &errs.AddressInUseError{
+				Resource: fmt.Sprintf("%s@%s", pod.Name, pod.Namespace),
+				Address:  prefix.Addr().String(),
+			}
+		}
+		assignedIPs = append(assignedIPs, prefix.String())
+	}
+	if len(assignedIPs) == 0 {
+		return nil, &errs.NoIPInPoolError{
+			Resource: fmt.Sprintf("%s@%s", pod.Name, pod.Namespace),
+			Pool:     poolName,
+		}
 	}
+	alloc.Spec.Addresses = assignedIPs
 
-	initContainer := corev1.Container{
-		Name:            vlanmanv1.WorkerInitContainerName,
-		Image:           image,
-		ImagePullPolicy: corev1.PullPolicy(pullPolicy),
-		SecurityContext: &corev1.SecurityContext{
-			Capabilities: &corev1.Capabilities{
-				Add: []corev1.Capability{"NET_ADMIN"},
-			},
-		},
-		Env: []corev1.EnvVar{
-			{
-				Name:  "VLAN_NETWORK",
-				Value: network.Name,
-			},
-			{
-				Name:  "MACVLAN_IP",
-				Value: address,
-			},
-			{
-				Name:  "MACVLAN_SUBNET",
-				Value: subnet,
-			},
-			{
-				Name:  "ROUTES",
-				Value: routes,
+	if exists {
+		opts := []client.UpdateOption{}
+		if dryRun {
+			opts = append(opts, client.DryRunAll)
+		}
+		err = v.Client.Update(ctx, alloc, opts...)
+		if err != nil {
+			return nil, errs.NewClientRequestError("Update VlanIPAllocation of a sticky claim in mutating webhook", err)
+		}
+		return alloc, nil
+	}
+
+	if network.Spec.AddressReleaseDelaySeconds > 0 {
+		controllerutil.AddFinalizer(alloc, vlanmanv1.AllocationFinalizer)
+	}
+	opts := []client.CreateOption{}
+	if dryRun {
+		opts = append(opts, client.DryRunAll)
+	}
+	err = v.Client.Create(ctx, alloc, opts...)
+	if err != nil {
+		return nil, errs.NewClientRequestError("Create VlanIPAllocation in mutating webhook", err)
+	}
+	return alloc, nil
+}
+
+// applyPatch adds an init container creating the macvlan interface for every attachment
+// and exposes the addresses to the pod's containers. Pods attached to a single network get
+// VLAN_IP and VLAN_SUBNET, pods attached to more networks additionally get VLAN_<NETWORK>_IP
+// and VLAN_<NETWORK>_SUBNET for every network, with VLAN_IP belonging to the first one.
+func applyPatch(pod *corev1.Pod, attachments []attachment, image, pullPolicy string) {
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	// the worker label holds a single value, so it points at the first network,
+	// every network gets its own label as well
+	pod.Labels[vlanmanv1.WorkerPodLabelKey] = attachments[0].NetworkName
+
+	initContainers := []corev1.Container{}
+	appEnv := []corev1.EnvVar{}
+	for idx, a := range attachments {
+		pod.Labels[u.WorkerNetworkLabelKey(a.NetworkName)] = "true"
+
+		address, subnet := splitAddress(a.IPs[0])
+		var address6, subnet6 string
+		if len(a.IPs) > 1 {
+			address6, subnet6 = splitAddress(a.IPs[1])
+		}
+
+		managers := []string{}
+		for k, v := range a.Endpoints {
+			managers = append(managers, fmt.Sprintf("%s=%s", k, v))
+		}
+
+		name := vlanmanv1.WorkerInitContainerName
+		if len(attachments) > 1 {
+			// container names are DNS labels, network names can be longer and contain dots
+			name = fmt.Sprintf("%s-%d", vlanmanv1.WorkerInitContainerName, idx)
+		}
+		initContainer := corev1.Container{
+			Name:            name,
+			Image:           image,
+			ImagePullPolicy: corev1.PullPolicy(pullPolicy),
+			SecurityContext: &corev1.SecurityContext{
+				Capabilities: &corev1.Capabilities{
+					// NET_RAW is needed for probing the addresses with ARP
+					Add: []corev1.Capability{"NET_ADMIN", "NET_RAW"},
+				},
 			},
-			{
-				Name:  "MANAGERS",
-				Value: strings.Join(managers, ","),
+			Env: []corev1.EnvVar{
+				{
+					Name:  "VLAN_NETWORK",
+					Value: a.NetworkName,
+				},
+				{
+					Name:  "MACVLAN_IP",
+					Value: address,
+				},
+				{
+					Name:  "MACVLAN_SUBNET",
+					Value: subnet,
+				},
+				{
+					Name:  "ROUTES",
+					Value: a.Routes,
+				},
+				{
+					Name:  "MANAGERS",
+					Value: strings.Join(managers, ","),
+				},
+				{
+					Name:  "INTERFACE_NAME",
+					Value: interfaceName(a.Network),
+				},
+				// address conflicts are reported to the manager on behalf of the pod
+				{
+					Name:      "POD_NAME",
+					ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}},
+				},
+				{
+					Name:      "POD_NAMESPACE",
+					ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"}},
+				},
 			},
-		},
+		}
+		if address6 != "" {
+			initContainer.Env = append(initContainer.Env, []corev1.EnvVar{
+				{
+					Name:  "MACVLAN_IP6",
+					Value: address6,
+				},
+				{
+					Name:  "MACVLAN_SUBNET6",
+					Value: subnet6,
+				},
+			}...)
+		}
+		initContainers = append(initContainers, initContainer)
+
+		prefixes := []string{}
+		if idx == 0 {
+			prefixes = append(prefixes, "VLAN_")
+		}
+		if len(attachments) > 1 {
+			prefixes = append(prefixes, "VLAN_"+envName(a.NetworkName)+"_")
+		}
+		for _, prefix := range prefixes {
+			appEnv = append(appEnv, []corev1.EnvVar{
+				{
+					Name:  prefix + "IP",
+					Value: address,
+				},
+				{
+					Name:  prefix + "SUBNET",
+					Value: subnet,
+				},
+			}...)
+			if address6 != "" {
+				appEnv = append(appEnv, []corev1.EnvVar{
+					{
+						Name:  prefix + "IP6",
+						Value: address6,
+					},
+					{
+						Name:  prefix + "SUBNET6",
+						Value: subnet6,
+					},
+				}...)
+			}
+		}
+
+		if a.Network.Spec.ManagerAffinity != nil {
+			pod.Spec.Affinity = mergeAffinity(pod.Spec.Affinity, a.Network.Spec.ManagerAffinity)
+		}
 	}
+
 	// we want vlan to run ideally first since other init containers might
 	// want to use the vlan connection. But the order in which mutating webhooks
 	// are called is non deterministic so this is the best we can do ;(
-	pod.Spec.InitContainers = append([]corev1.Container{initContainer}, pod.Spec.InitContainers...)
+	pod.Spec.InitContainers = append(initContainers, pod.Spec.InitContainers...)
 
 	// env
 	for idx := range pod.Spec.Containers {
-		pod.Spec.Containers[idx].Env = append(pod.Spec.Containers[idx].Env, []corev1.EnvVar{
-			{
-				Name:  "VLAN_IP",
-				Value: address,
-			},
-			{
-				Name:  "VLAN_SUBNET",
-				Value: subnet,
-			},
-		}...)
+		pod.Spec.Containers[idx].Env = append(pod.Spec.Containers[idx].Env, appEnv...)
+	}
+}
 
+// interfaceName returns the name of the network's macvlan interface in worker pods
+func interfaceName(network *vlanmanv1.VlanNetwork) string {
+	if network.Spec.InterfaceName != "" {
+		return network.Spec.InterfaceName
 	}
+	return u.DefaultInterfaceName(network.Spec.VlanID)
+}
+
+// envName turns a network name into a part of an environment variable name
+func envName(network string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, network)
+}
 
-	if network.Spec.ManagerAffinity != nil {
-		pod.Spec.Affinity = mergeAffinity(pod.Spec.Affinity, network.Spec.ManagerAffinity)
+// splitAddress splits an address into the IP and prefix length,
+// defaulting to a host prefix for the address family
+func splitAddress(IP string) (string, string) {
+	address, subnet, found := strings.Cut(IP, "/")
+	if !found {
+		subnet = "32"
+		if u.IsIPv6(address) {
+			subnet = "128"
+		}
 	}
+	return address, subnet
 }
 
 func mergeAffinity(base, override *corev1.Affinity) *corev1.Affinity {
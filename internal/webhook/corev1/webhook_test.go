@@ -0,0 +1,323 @@
+package corev1
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	vlanmanv1 "dialo.ai/vlanman/api/v1"
+	errs "dialo.ai/vlanman/pkg/errors"
+	"dialo.ai/vlanman/pkg/ipam"
+)
+
+func statefulSetPod(name string, annotations map[string]string) *corev1.Pod {
+	controller := true
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Annotations: annotations,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1",
+				Kind:       "StatefulSet",
+				Name:       "db",
+				Controller: &controller,
+			}},
+		},
+	}
+}
+
+func TestStickyClaim(t *testing.T) {
+	sticky := vlanmanv1.VlanNetworkPool{Name: "primary", Sticky: true}
+	plain := vlanmanv1.VlanNetworkPool{Name: "primary"}
+
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		pool     vlanmanv1.VlanNetworkPool
+		expected string
+		sticky   bool
+		owned    bool
+	}{
+		{name: "statefulset pod in sticky pool", pod: statefulSetPod("db-2", nil), pool: sticky, expected: "net-db-2", sticky: true, owned: true},
+		{name: "statefulset pod in plain pool", pod: statefulSetPod("db-2", nil), pool: plain},
+		{
+			name:     "statefulset pod with sticky annotation",
+			pod:      statefulSetPod("db-0", map[string]string{vlanmanv1.PodVlanmanStickyAnnotation: "true"}),
+			pool:     plain,
+			expected: "net-db-0",
+			sticky:   true,
+			owned:    true,
+		},
+		{
+			name:     "claim annotation",
+			pod:      &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{vlanmanv1.PodVlanmanClaimAnnotation: "frontend"}}},
+			pool:     plain,
+			expected: "net-claim-frontend",
+			sticky:   true,
+		},
+		{name: "bare pod in sticky pool", pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web"}}, pool: sticky},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, _, owners, ok := stickyClaim(tt.pod, "net", tt.pool)
+			assert.Equal(t, tt.sticky, ok)
+			assert.Equal(t, tt.expected, name)
+			assert.Equal(t, tt.owned, len(owners) == 1)
+		})
+	}
+}
+
+func TestStickyClaimPodIndexLabel(t *testing.T) {
+	pod := statefulSetPod("db-7", nil)
+	pod.Labels = map[string]string{appsv1.PodIndexLabel: "7"}
+	name, labels, _, ok := stickyClaim(pod, "net", vlanmanv1.VlanNetworkPool{Sticky: true})
+	assert.True(t, ok)
+	assert.Equal(t, "net-db-7", name)
+	assert.Equal(t, "7", labels[vlanmanv1.AllocationOrdinalLabelKey])
+	assert.Equal(t, "db", labels[vlanmanv1.AllocationStatefulSetLabelKey])
+}
+
+func TestRequestedAddresses(t *testing.T) {
+	pool, err := ipam.NewPool(vlanmanv1.VlanNetworkPool{
+		Name:      "primary",
+		Addresses: []string{"10.0.10.0/24", "fd00::/120"},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		annotation    string
+		multi         bool
+		expected      []string
+		expectedError error
+	}{
+		{name: "no annotation", expected: nil},
+		{name: "single address", annotation: "10.0.10.7", expected: []string{"10.0.10.7/24"}},
+		{name: "prefix is taken from the pool", annotation: "10.0.10.7/32", expected: []string{"10.0.10.7/24"}},
+		{name: "dual-stack", annotation: "fd00::7, 10.0.10.7", expected: []string{"10.0.10.7/24", "fd00::7/120"}},
+		{name: "outside of pool", annotation: "10.0.11.7", expectedError: errs.ErrAddressNotInPool},
+		{name: "network address", annotation: "10.0.10.0", expectedError: errs.ErrAddressNotInPool},
+		{name: "same family twice", annotation: "10.0.10.7,10.0.10.8", expectedError: errs.ErrParsing},
+		{name: "invalid", annotation: "10.0.10", expectedError: errs.ErrParsing},
+		{name: "prefixed with network", annotation: "other=10.0.11.7,net=10.0.10.7", multi: true, expected: []string{"10.0.10.7/24"}},
+		{name: "unprefixed with multiple networks", annotation: "10.0.10.7", multi: true, expected: nil},
+		{name: "other network only", annotation: "other=10.0.11.7", expected: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Annotations: map[string]string{}}}
+			if tt.annotation != "" {
+				pod.Annotations[vlanmanv1.PodVlanmanIPAnnotation] = tt.annotation
+			}
+			requested, err := requestedAddresses(pod, "net", pool, !tt.multi)
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			if tt.expected == nil {
+				assert.Nil(t, requested)
+				return
+			}
+			actual := []string{}
+			for _, p := range requested {
+				actual = append(actual, p.String())
+			}
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestParseAttachments(t *testing.T) {
+	tests := []struct {
+		name          string
+		annotations   map[string]string
+		expected      []attachment
+		expectedError error
+	}{
+		{name: "not a worker", annotations: map[string]string{}},
+		{
+			name:        "single network",
+			annotations: map[string]string{vlanmanv1.PodVlanmanNetworkAnnotation: "net-a", vlanmanv1.PodVlanmanIPPoolAnnotation: "pool1"},
+			expected:    []attachment{{NetworkName: "net-a", PoolName: "pool1"}},
+		},
+		{
+			name:        "list of networks",
+			annotations: map[string]string{vlanmanv1.PodVlanmanNetworkAnnotation: "net-a/pool1, net-b/pool2"},
+			expected:    []attachment{{NetworkName: "net-a", PoolName: "pool1"}, {NetworkName: "net-b", PoolName: "pool2"}},
+		},
+		{
+			name:        "default pool",
+			annotations: map[string]string{vlanmanv1.PodVlanmanNetworkAnnotation: "net-a,net-b/pool2", vlanmanv1.PodVlanmanIPPoolAnnotation: "pool1"},
+			expected:    []attachment{{NetworkName: "net-a", PoolName: "pool1"}, {NetworkName: "net-b", PoolName: "pool2"}},
+		},
+		{
+			name:          "missing pool",
+			annotations:   map[string]string{vlanmanv1.PodVlanmanNetworkAnnotation: "net-a,net-b/pool2"},
+			expectedError: errs.ErrMissingAnnotation,
+		},
+		{
+			name:          "missing network",
+			annotations:   map[string]string{vlanmanv1.PodVlanmanIPPoolAnnotation: "pool1"},
+			expectedError: errs.ErrMissingAnnotation,
+		},
+		{
+			name:          "duplicate network",
+			annotations:   map[string]string{vlanmanv1.PodVlanmanNetworkAnnotation: "net-a/pool1,net-a/pool2"},
+			expectedError: errs.ErrParsing,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attachments, err := parseAttachments(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}})
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, attachments)
+		})
+	}
+}
+
+func TestApplyPatchMultipleNetworks(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{
+		InitContainers: []corev1.Container{{Name: "setup"}},
+		Containers:     []corev1.Container{{Name: "app"}},
+	}}
+	applyPatch(pod, []attachment{
+		{NetworkName: "signalling", Network: &vlanmanv1.VlanNetwork{}, IPs: []string{"10.0.0.5/24"}, Routes: "[]"},
+		{NetworkName: "media.v2", Network: &vlanmanv1.VlanNetwork{}, IPs: []string{"10.0.1.5/24", "fd00::5/64"}, Routes: "[]"},
+	}, "worker", "IfNotPresent")
+
+	require.Len(t, pod.Spec.InitContainers, 3)
+	assert.Equal(t, "init-vlan-0", pod.Spec.InitContainers[0].Name)
+	assert.Equal(t, "init-vlan-1", pod.Spec.InitContainers[1].Name)
+	assert.Equal(t, "setup", pod.Spec.InitContainers[2].Name)
+
+	assert.Equal(t, "signalling", pod.Labels[vlanmanv1.WorkerPodLabelKey])
+	assert.Equal(t, "true", pod.Labels[vlanmanv1.WorkerPodNetworkLabelPrefix+"media.v2"])
+
+	env := map[string]string{}
+	for _, e := range pod.Spec.Containers[0].Env {
+		env[e.Name] = e.Value
+	}
+	assert.Equal(t, "10.0.0.5", env["VLAN_IP"])
+	assert.Equal(t, "10.0.0.5", env["VLAN_SIGNALLING_IP"])
+	assert.Equal(t, "10.0.1.5", env["VLAN_MEDIA_V2_IP"])
+	assert.Equal(t, "fd00::5", env["VLAN_MEDIA_V2_IP6"])
+	assert.Equal(t, "64", env["VLAN_MEDIA_V2_SUBNET6"])
+	assert.NotContains(t, env, "VLAN_IP6")
+}
+
+func TestApplyPatchLongNetworkName(t *testing.T) {
+	// still fits into the worker pod label
+	long := strings.Repeat("n", 54) + ".v2"
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}}
+	applyPatch(pod, []attachment{
+		{NetworkName: "signalling", Network: &vlanmanv1.VlanNetwork{}, IPs: []string{"10.0.0.5/24"}, Routes: "[]"},
+		{NetworkName: long, Network: &vlanmanv1.VlanNetwork{}, IPs: []string{"10.0.1.5/24"}, Routes: "[]"},
+	}, "worker", "IfNotPresent")
+
+	require.Len(t, pod.Spec.InitContainers, 2)
+	for _, c := range pod.Spec.InitContainers {
+		assert.Empty(t, validation.IsDNS1123Label(c.Name), c.Name)
+	}
+	assert.Empty(t, validation.IsQualifiedName(vlanmanv1.WorkerPodNetworkLabelPrefix+long))
+}
+
+func TestAllocateReleaseFinalizer(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = vlanmanv1.AddToScheme(scheme)
+
+	for _, delay := range []int{0, 30} {
+		network := &vlanmanv1.VlanNetwork{
+			ObjectMeta: metav1.ObjectMeta{Name: "net1"},
+			Spec: vlanmanv1.VlanNetworkSpec{
+				AddressReleaseDelaySeconds: delay,
+				Pools:                      []vlanmanv1.VlanNetworkPool{{Name: "pool1", Addresses: []string{"10.0.0.1-10.0.0.4/24"}}},
+			},
+		}
+		c := fake.NewClientBuilder().WithScheme(scheme).Build()
+		defaulter := &VlanmanPodCustomDefaulter{Client: c, Reader: c}
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "default"}}
+
+		alloc, err := defaulter.allocate(context.Background(), pod, network, "pool1", false, false)
+		require.NoError(t, err)
+		assert.Equal(t, delay > 0, slices.Contains(alloc.Finalizers, vlanmanv1.AllocationFinalizer))
+	}
+}
+
+func TestAllocateSkipsQuarantined(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = vlanmanv1.AddToScheme(scheme)
+
+	network := &vlanmanv1.VlanNetwork{
+		ObjectMeta: metav1.ObjectMeta{Name: "net1"},
+		Spec: vlanmanv1.VlanNetworkSpec{
+			Pools: []vlanmanv1.VlanNetworkPool{{Name: "pool1", Addresses: []string{"10.0.0.1-10.0.0.2/24"}}},
+		},
+		Status: vlanmanv1.VlanNetworkStatus{QuarantinedAddresses: []vlanmanv1.QuarantinedAddress{
+			{Address: "10.0.0.1", LastDetected: metav1.Now()},
+		}},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+	defaulter := &VlanmanPodCustomDefaulter{Client: c, Reader: c}
+
+	alloc, err := defaulter.allocate(context.Background(), &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "default"}}, network, "pool1", false, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.2/24"}, alloc.Spec.Addresses)
+
+	_, err = defaulter.allocate(context.Background(), &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "worker2", Namespace: "default"}}, network, "pool1", false, false)
+	assert.ErrorIs(t, err, errs.ErrNoIPInPool)
+}
+
+func TestAllocateStickyClaimQuarantined(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = vlanmanv1.AddToScheme(scheme)
+
+	network := &vlanmanv1.VlanNetwork{
+		ObjectMeta: metav1.ObjectMeta{Name: "net1"},
+		Spec: vlanmanv1.VlanNetworkSpec{
+			Pools: []vlanmanv1.VlanNetworkPool{{Name: "pool1", Sticky: true, Addresses: []string{"10.0.0.1-10.0.0.2/24"}}},
+		},
+		Status: vlanmanv1.VlanNetworkStatus{QuarantinedAddresses: []vlanmanv1.QuarantinedAddress{
+			{Address: "10.0.0.1", LastDetected: metav1.Now()},
+		}},
+	}
+	claim := &vlanmanv1.VlanIPAllocation{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "net1-db-0",
+			Namespace: "default",
+			Labels: map[string]string{
+				vlanmanv1.AllocationNetworkLabelKey: "net1",
+				vlanmanv1.AllocationPoolLabelKey:    "pool1",
+			},
+		},
+		Spec: vlanmanv1.VlanIPAllocationSpec{Network: "net1", Pool: "pool1", Addresses: []string{"10.0.0.1/24"}},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(claim).Build()
+	defaulter := &VlanmanPodCustomDefaulter{Client: c, Reader: c}
+
+	alloc, err := defaulter.allocate(context.Background(), statefulSetPod("db-0", nil), network, "pool1", false, false)
+	require.NoError(t, err)
+	assert.Equal(t, "net1-db-0", alloc.Name)
+	assert.Equal(t, []string{"10.0.0.2/24"}, alloc.Spec.Addresses)
+}
@@ -1,10 +1,8 @@
 package v1
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
-	"net/http"
-	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -15,310 +13,115 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	vlanmanv1 "dialo.ai/vlanman/api/v1"
 	"dialo.ai/vlanman/internal/controller"
 )
 
-func TestGetAction(t *testing.T) {
-	tests := []struct {
-		name     string
-		req      *admissionv1.AdmissionRequest
-		expected any
-	}{
-		{
-			name: "creation action",
-			req: &admissionv1.AdmissionRequest{
-				OldObject: runtime.RawExtension{Raw: nil},
-				Object:    runtime.RawExtension{Raw: []byte(`{"test": "data"}`)},
-			},
-			expected: creationAction{},
-		},
-		{
-			name: "deletion action",
-			req: &admissionv1.AdmissionRequest{
-				OldObject: runtime.RawExtension{Raw: []byte(`{"test": "data"}`)},
-				Object:    runtime.RawExtension{Raw: nil},
-			},
-			expected: deletionAction{},
-		},
-		{
-			name: "update action",
-			req: &admissionv1.AdmissionRequest{
-				OldObject: runtime.RawExtension{Raw: []byte(`{"test": "old"}`)},
-				Object:    runtime.RawExtension{Raw: []byte(`{"test": "new"}`)},
-			},
-			expected: updateAction{},
-		},
-		{
-			name: "unknown action",
-			req: &admissionv1.AdmissionRequest{
-				OldObject: runtime.RawExtension{Raw: nil},
-				Object:    runtime.RawExtension{Raw: nil},
-			},
-			expected: unknownAction{},
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result := getAction(tt.req)
-			assert.IsType(t, tt.expected, result)
-		})
-	}
-}
-
-func TestNoPatchResponse(t *testing.T) {
-	uid := types.UID("test-uid")
-	input := &admissionv1.AdmissionReview{
-		Request: &admissionv1.AdmissionRequest{
-			UID: uid,
-		},
-	}
-
-	result := noPatchResponse(input)
-
-	assert.Equal(t, "AdmissionReview", result.Kind)
-	assert.Equal(t, "admission.k8s.io/v1", result.APIVersion)
-	assert.Equal(t, uid, result.Response.UID)
-	assert.True(t, result.Response.Allowed)
-}
-
-func TestDeniedResponse(t *testing.T) {
-	uid := types.UID("test-uid")
-	input := &admissionv1.AdmissionReview{
-		Request: &admissionv1.AdmissionRequest{
-			UID: uid,
-		},
-	}
-
-	t.Run("with reason", func(t *testing.T) {
-		reason := "test reason"
-		result := deniedResponse(input, reason)
-
-		assert.Equal(t, "AdmissionReview", result.Kind)
-		assert.Equal(t, "admission.k8s.io/v1", result.APIVersion)
-		assert.Equal(t, uid, result.Response.UID)
-		assert.False(t, result.Response.Allowed)
-		assert.Equal(t, reason, result.Response.Result.Message)
-	})
-
-	t.Run("without reason", func(t *testing.T) {
-		result := deniedResponse(input)
-
-		assert.Equal(t, "No reason provided.", result.Response.Result.Message)
-	})
-}
-
-func TestValidatingWebhookHandler_ServeHTTP(t *testing.T) {
+func TestValidatingWebhook_Handle(t *testing.T) {
 	scheme := runtime.NewScheme()
 	_ = corev1.AddToScheme(scheme)
 	_ = vlanmanv1.AddToScheme(scheme)
 
-	// Create test nodes
-	nodes := []corev1.Node{
-		{
-			ObjectMeta: metav1.ObjectMeta{Name: "node1"},
-		},
-		{
-			ObjectMeta: metav1.ObjectMeta{Name: "node2"},
-		},
-	}
-
-	// Create test VlanNetworks
-	existingNetworks := []vlanmanv1.VlanNetwork{
-		{
+	objs := []runtime.Object{
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}},
+		&vlanmanv1.VlanNetwork{
 			ObjectMeta: metav1.ObjectMeta{Name: "existing-network"},
-			Spec: vlanmanv1.VlanNetworkSpec{
-				VlanID: 100,
+			Spec:       vlanmanv1.VlanNetworkSpec{VlanID: 100},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "worker",
+				Namespace: "default",
+				Labels:    map[string]string{vlanmanv1.WorkerPodLabelKey: "existing-network"},
 			},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
 		},
 	}
-
-	// Create fake client with test data
-	objs := make([]runtime.Object, 0)
-	for _, node := range nodes {
-		objs = append(objs, &node)
-	}
-	for _, network := range existingNetworks {
-		objs = append(objs, &network)
-	}
-
 	client := fake.NewClientBuilder().
 		WithScheme(scheme).
 		WithRuntimeObjects(objs...).
 		Build()
 
-	handler := &ValidatingWebhookHandler{
+	hook := admission.WithCustomValidator(scheme, &vlanmanv1.VlanNetwork{}, &VlanmanCustomValidator{
 		Client: client,
 		Env:    controller.Envs{},
-	}
-
-	t.Run("valid creation", func(t *testing.T) {
-		// Create a valid VlanNetwork
-		vlanNetwork := &vlanmanv1.VlanNetwork{
-			TypeMeta: metav1.TypeMeta{
-				Kind:       "VlanNetwork",
-				APIVersion: "vlanman.dialo.ai/v1",
-			},
-			ObjectMeta: metav1.ObjectMeta{Name: "test-network"},
-			Spec: vlanmanv1.VlanNetworkSpec{
-				VlanID:         200,
-				LocalGatewayIP: "192.168.1.1",
-				LocalSubnet:    []string{"192.168.1.0/24"},
-				RemoteSubnet:   []string{"192.168.2.0/24"},
-				ManagerAffinity: nil,
-			},
-		}
-
-		objBytes, err := json.Marshal(vlanNetwork)
-		require.NoError(t, err)
-
-		admissionReview := &admissionv1.AdmissionReview{
-			TypeMeta: metav1.TypeMeta{
-				Kind:       "AdmissionReview",
-				APIVersion: "admission.k8s.io/v1",
-			},
-			Request: &admissionv1.AdmissionRequest{
-				UID: types.UID("test-uid"),
-				Object: runtime.RawExtension{
-					Raw: objBytes,
-				},
-			},
-		}
-
-		reqBytes, err := json.Marshal(admissionReview)
-		require.NoError(t, err)
-
-		req := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewBuffer(reqBytes))
-		req.Header.Set("Content-Type", "application/json")
-		w := httptest.NewRecorder()
-
-		handler.ServeHTTP(w, req)
-
-		assert.Equal(t, http.StatusOK, w.Code)
-
-		var response admissionv1.AdmissionReview
-		err = json.Unmarshal(w.Body.Bytes(), &response)
-		require.NoError(t, err)
-		assert.True(t, response.Response.Allowed)
 	})
 
-	t.Run("duplicate VLAN ID", func(t *testing.T) {
-		// Create a VlanNetwork with duplicate VLAN ID
-		vlanNetwork := &vlanmanv1.VlanNetwork{
+	network := func(name string, vlanID int, affinity *corev1.Affinity) runtime.RawExtension {
+		raw, err := json.Marshal(&vlanmanv1.VlanNetwork{
 			TypeMeta: metav1.TypeMeta{
 				Kind:       "VlanNetwork",
 				APIVersion: "vlanman.dialo.ai/v1",
 			},
-			ObjectMeta: metav1.ObjectMeta{Name: "duplicate-network"},
+			ObjectMeta: metav1.ObjectMeta{Name: name},
 			Spec: vlanmanv1.VlanNetworkSpec{
-				VlanID:         100, // Same as existing network
-				LocalGatewayIP: "192.168.1.1",
-				LocalSubnet:    []string{"192.168.1.0/24"},
-				RemoteSubnet:   []string{"192.168.2.0/24"},
-				ManagerAffinity: nil,
-			},
-		}
-
-		objBytes, err := json.Marshal(vlanNetwork)
-		require.NoError(t, err)
-
-		admissionReview := &admissionv1.AdmissionReview{
-			TypeMeta: metav1.TypeMeta{
-				Kind:       "AdmissionReview",
-				APIVersion: "admission.k8s.io/v1",
+				VlanID:          vlanID,
+				ManagerAffinity: affinity,
 			},
-			Request: &admissionv1.AdmissionRequest{
-				UID: types.UID("test-uid"),
-				Object: runtime.RawExtension{
-					Raw: objBytes,
-				},
-			},
-		}
-
-		reqBytes, err := json.Marshal(admissionReview)
-		require.NoError(t, err)
-
-		req := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewBuffer(reqBytes))
-		req.Header.Set("Content-Type", "application/json")
-		w := httptest.NewRecorder()
-
-		handler.ServeHTTP(w, req)
-
-		assert.Equal(t, http.StatusOK, w.Code)
-
-		var response admissionv1.AdmissionReview
-		err = json.Unmarshal(w.Body.Bytes(), &response)
-		require.NoError(t, err)
-		assert.False(t, response.Response.Allowed)
-		assert.Contains(t, response.Response.Result.Message, "There exists a network with that VLAN ID")
-	})
-
-	t.Run("all nodes excluded", func(t *testing.T) {
-		// Create a VlanNetwork that excludes all nodes
-		vlanNetwork := &vlanmanv1.VlanNetwork{
-			TypeMeta: metav1.TypeMeta{
-				Kind:       "VlanNetwork",
-				APIVersion: "vlanman.dialo.ai/v1",
-			},
-			ObjectMeta: metav1.ObjectMeta{Name: "no-nodes-network"},
-			Spec: vlanmanv1.VlanNetworkSpec{
-				VlanID:         300,
-				LocalGatewayIP: "192.168.1.1",
-				LocalSubnet:    []string{"192.168.1.0/24"},
-				RemoteSubnet:   []string{"192.168.2.0/24"},
-				ManagerAffinity: &corev1.Affinity{
-					NodeAffinity: &corev1.NodeAffinity{
-						RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
-							NodeSelectorTerms: []corev1.NodeSelectorTerm{
-								{
-									MatchExpressions: []corev1.NodeSelectorRequirement{
-										{
-											Key:      "kubernetes.io/hostname",
-											Operator: corev1.NodeSelectorOpNotIn,
-											Values:   []string{"node1", "node2"},
-										},
-									},
-								},
-							},
-						},
-					},
-				}, // Exclude all nodes
-			},
-		}
-
-		objBytes, err := json.Marshal(vlanNetwork)
+		})
 		require.NoError(t, err)
-
-		admissionReview := &admissionv1.AdmissionReview{
-			TypeMeta: metav1.TypeMeta{
-				Kind:       "AdmissionReview",
-				APIVersion: "admission.k8s.io/v1",
-			},
-			Request: &admissionv1.AdmissionRequest{
-				UID: types.UID("test-uid"),
-				Object: runtime.RawExtension{
-					Raw: objBytes,
-				},
+		return runtime.RawExtension{Raw: raw}
+	}
+	excludeAll := &corev1.Affinity{
+		NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+					MatchExpressions: []corev1.NodeSelectorRequirement{{
+						Key:      "kubernetes.io/hostname",
+						Operator: corev1.NodeSelectorOpNotIn,
+						Values:   []string{"node1", "node2"},
+					}},
+				}},
 			},
-		}
-
-		reqBytes, err := json.Marshal(admissionReview)
-		require.NoError(t, err)
+		},
+	}
 
-		req := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewBuffer(reqBytes))
-		req.Header.Set("Content-Type", "application/json")
-		w := httptest.NewRecorder()
+	tests := []struct {
+		name          string
+		req           admissionv1.AdmissionRequest
+		errorContains string
+	}{
+		{
+			name: "valid creation",
+			req:  admissionv1.AdmissionRequest{Operation: admissionv1.Create, Object: network("test-network", 200, nil)},
+		},
+		{
+			name:          "duplicate VLAN ID",
+			req:           admissionv1.AdmissionRequest{Operation: admissionv1.Create, Object: network("duplicate-network", 100, nil)},
+			errorContains: "There exists a network with that VLAN ID",
+		},
+		{
+			name:          "all nodes excluded",
+			req:           admissionv1.AdmissionRequest{Operation: admissionv1.Create, Object: network("no-nodes-network", 300, excludeAll)},
+			errorContains: "There are no available nodes",
+		},
+		{
+			name: "deletion of an unused network",
+			req:  admissionv1.AdmissionRequest{Operation: admissionv1.Delete, OldObject: network("test-network", 200, nil)},
+		},
+		{
+			name:          "deletion of a network used by pods",
+			req:           admissionv1.AdmissionRequest{Operation: admissionv1.Delete, OldObject: network("existing-network", 100, nil)},
+			errorContains: "still used by 1 pods",
+		},
+	}
 
-		handler.ServeHTTP(w, req)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.UID = types.UID("test-uid")
 
-		assert.Equal(t, http.StatusOK, w.Code)
+			resp := hook.Handle(context.Background(), admission.Request{AdmissionRequest: tt.req})
 
-		var response admissionv1.AdmissionReview
-		err = json.Unmarshal(w.Body.Bytes(), &response)
-		require.NoError(t, err)
-		assert.False(t, response.Response.Allowed)
-		assert.Contains(t, response.Response.Result.Message, "There are no available nodes")
-	})
+			assert.Equal(t, tt.req.UID, resp.UID)
+			if tt.errorContains != "" {
+				assert.False(t, resp.Allowed)
+				assert.Contains(t, resp.Result.Message, tt.errorContains)
+				return
+			}
+			assert.True(t, resp.Allowed, resp.Result)
+		})
+	}
 }
@@ -5,13 +5,18 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"slices"
 	"strings"
 
 	vlanmanv1 "dialo.ai/vlanman/api/v1"
 	errs "dialo.ai/vlanman/pkg/errors"
+	"dialo.ai/vlanman/pkg/ipam"
+	u "dialo.ai/vlanman/pkg/utils"
 
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -68,9 +73,159 @@ func (v *Validator) validateMinimumNodes(net *vlanmanv1.VlanNetwork) error {
 
 func (v *Validator) validateUnique(net *vlanmanv1.VlanNetwork) error {
 	for _, nw := range v.Networks {
-		if nw.Spec.VlanID == net.Spec.VlanID {
+		if nw.Name == net.Name {
+			continue
+		}
+		if nw.Spec.VlanID == net.Spec.VlanID && nw.Spec.ServiceVlanID == net.Spec.ServiceVlanID {
+			if net.Spec.ServiceVlanID != 0 {
+				return fmt.Errorf("There exists a network with that service and customer VLAN ID pair: %s", nw.Name)
+			}
 			return fmt.Errorf("There exists a network with that VLAN ID: %s", nw.Name)
 		}
+		if outerTagConflicts(nw.Spec, net.Spec) || outerTagConflicts(net.Spec, nw.Spec) {
+			return fmt.Errorf("The outer 802.1Q tag of a QinQ network is the same as the VLAN ID of network: %s", nw.Name)
+		}
+	}
+	return nil
+}
+
+// outerTagConflicts checks whether the outer 802.1Q tag of a QinQ network
+// is the same as the tag of a plain network, both would be created on the parent
+func outerTagConflicts(qinq, plain vlanmanv1.VlanNetworkSpec) bool {
+	return qinq.ServiceVlanID != 0 &&
+		plain.ServiceVlanID == 0 &&
+		qinq.ServiceVlanProtocol.OrDefault() == vlanmanv1.VlanProtocol8021Q &&
+		qinq.ServiceVlanID == plain.VlanID
+}
+
+func validateRoutes(routes []vlanmanv1.Route, where string) error {
+	for _, r := range routes {
+		dst, err := u.ParseAddress(r.Destination)
+		if err != nil {
+			return fmt.Errorf("Invalid route destination '%s' in %s: %w", r.Destination, where, err)
+		}
+		if r.Via == nil {
+			continue
+		}
+		via, err := u.ParseAddress(*r.Via)
+		if err != nil {
+			return fmt.Errorf("Invalid next hop '%s' of route to %s in %s: %w", *r.Via, r.Destination, where, err)
+		}
+		if (via.IP.To4() == nil) != (dst.IP.To4() == nil) {
+			return fmt.Errorf("Next hop '%s' and destination '%s' of route in %s are of different address families", *r.Via, r.Destination, where)
+		}
+	}
+	return nil
+}
+
+func (v *Validator) validateAddresses(net *vlanmanv1.VlanNetwork) error {
+	for _, gw := range net.Spec.Gateways {
+		if !u.IsValidIP(gw.Address) {
+			return fmt.Errorf("Invalid gateway address: '%s'", gw.Address)
+		}
+		err := validateRoutes(gw.Routes, fmt.Sprintf("gateway %s", gw.Address))
+		if err != nil {
+			return err
+		}
+	}
+	pools := []*ipam.Pool{}
+	for _, pool := range net.Spec.Pools {
+		p, err := ipam.NewPool(pool)
+		if err != nil {
+			return fmt.Errorf("Invalid addresses: %w", err)
+		}
+		for _, other := range pools {
+			if other.Name == p.Name {
+				return fmt.Errorf("Pool name %s is not unique", p.Name)
+			}
+			if other.Overlaps(p) {
+				return fmt.Errorf("Pools %s and %s have overlapping addresses", other.Name, p.Name)
+			}
+		}
+		pools = append(pools, p)
+		err = validateRoutes(pool.Routes, fmt.Sprintf("pool %s", pool.Name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateName checks that the name fits into the label marking worker pods as attached to the network,
+// the name part of a label key is limited to 63 characters
+func validateName(net *vlanmanv1.VlanNetwork) error {
+	msgs := validation.IsQualifiedName(u.WorkerNetworkLabelKey(net.Name))
+	if len(msgs) != 0 {
+		return fmt.Errorf("Network name '%s' can't be used in the worker pod label: %s", net.Name, strings.Join(msgs, ", "))
+	}
+	return nil
+}
+
+// reservedInterfaceNames are used by the pod's own interfaces or can't name a link,
+// the manager replaces a link with the interface name when it attaches a worker pod
+var reservedInterfaceNames = []string{"eth0", "lo", ".", ".."}
+
+func validateInterfaceName(net *vlanmanv1.VlanNetwork) error {
+	if slices.Contains(reservedInterfaceNames, net.Spec.InterfaceName) {
+		return fmt.Errorf("Interface name '%s' is reserved", net.Spec.InterfaceName)
+	}
+	return nil
+}
+
+func validateAttachment(net *vlanmanv1.VlanNetwork) error {
+	// a passthru macvlan takes over the parent, so there can't be a gateway link next to the worker's
+	if net.Spec.Attachment == vlanmanv1.AttachmentMacvlanPassthru && len(net.Spec.Gateways) != 0 {
+		return fmt.Errorf("Attachment mode %s allows a single interface per node and can't be used with gateways", net.Spec.Attachment)
+	}
+	return nil
+}
+
+// IPv6 requires links to carry at least 1280 byte packets
+const minIPv6MTU = 1280
+
+// mappingTarget describes the nodes a mapping applies to
+func mappingTarget(m vlanmanv1.IPMapping) string {
+	if m.NodeName != "" {
+		return "node " + m.NodeName
+	}
+	return "nodes matching " + metav1.FormatLabelSelector(m.NodeSelector)
+}
+
+func validateMappings(net *vlanmanv1.VlanNetwork) error {
+	for i, m := range net.Spec.Mappings {
+		if (m.NodeName == "") == (m.NodeSelector == nil) {
+			return fmt.Errorf("Mapping %d has to set exactly one of nodeName and nodeSelector", i)
+		}
+		if m.NodeSelector == nil {
+			continue
+		}
+		_, err := metav1.LabelSelectorAsSelector(m.NodeSelector)
+		if err != nil {
+			return fmt.Errorf("Invalid node selector of mapping %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func validateMTU(net *vlanmanv1.VlanNetwork) error {
+	hasIPv6 := false
+	for _, pool := range net.Spec.Pools {
+		p, err := ipam.NewPool(pool)
+		if err != nil {
+			return fmt.Errorf("Invalid addresses: %w", err)
+		}
+		hasIPv6 = hasIPv6 || slices.ContainsFunc(p.Ranges, func(r ipam.Range) bool { return r.From.Is6() })
+	}
+	if !hasIPv6 {
+		return nil
+	}
+	if net.Spec.MTU != 0 && net.Spec.MTU < minIPv6MTU {
+		return fmt.Errorf("MTU %d is too small for IPv6 pools, it has to be at least %d", net.Spec.MTU, minIPv6MTU)
+	}
+	for _, m := range net.Spec.Mappings {
+		if m.MTU != 0 && m.MTU < minIPv6MTU {
+			return fmt.Errorf("MTU %d of %s is too small for IPv6 pools, it has to be at least %d", m.MTU, mappingTarget(m), minIPv6MTU)
+		}
 	}
 	return nil
 }
@@ -112,10 +267,34 @@ func NewCreationValidator(k8s client.Client, ctx context.Context, network *vlanm
 }
 
 func (cv *CreationValidator) Validate() error {
-	err := cv.validateMinimumNodes(cv.NewNetwork)
+	err := validateName(cv.NewNetwork)
+	if err != nil {
+		return err
+	}
+	err = validateInterfaceName(cv.NewNetwork)
+	if err != nil {
+		return err
+	}
+	err = cv.validateMinimumNodes(cv.NewNetwork)
 	if err != nil {
 		return fmt.Errorf("Couldn't validate minimum node requirement: %w", err)
 	}
+	err = cv.validateAddresses(cv.NewNetwork)
+	if err != nil {
+		return err
+	}
+	err = validateAttachment(cv.NewNetwork)
+	if err != nil {
+		return err
+	}
+	err = validateMappings(cv.NewNetwork)
+	if err != nil {
+		return err
+	}
+	err = validateMTU(cv.NewNetwork)
+	if err != nil {
+		return err
+	}
 	return cv.validateUnique(cv.NewNetwork)
 }
 
@@ -159,42 +338,97 @@ func (uv *UpdateValidator) Validate() error {
 	if err != nil {
 		return fmt.Errorf("Couldn't validate minimum node requirement: %w", err)
 	}
+	err = uv.validateAddresses(uv.NewNetwork)
+	if err != nil {
+		return err
+	}
+	err = validateAttachment(uv.NewNetwork)
+	if err != nil {
+		return err
+	}
+	err = validateMappings(uv.NewNetwork)
+	if err != nil {
+		return err
+	}
+	err = validateMTU(uv.NewNetwork)
+	if err != nil {
+		return err
+	}
+
+	err = uv.validateUnique(uv.NewNetwork)
+	if err != nil {
+		return err
+	}
 
-	uv.NewNetwork.Spec.Pools = nil
-	uv.OldNetwork.Spec.Pools = nil
-	uv.NewNetwork.Spec.ManagerAffinity = nil
-	uv.OldNetwork.Spec.ManagerAffinity = nil
-	uv.NewNetwork.Spec.Mappings = nil
-	uv.OldNetwork.Spec.Mappings = nil
-	uv.NewNetwork.Spec.Gateways = nil
-	uv.OldNetwork.Spec.Gateways = nil
-	uv.NewNetwork.Spec.VlanID = 0
-	uv.OldNetwork.Spec.VlanID = 0
-	if !reflect.DeepEqual(uv.NewNetwork.Spec, uv.OldNetwork.Spec) {
-		return fmt.Errorf("The only field in spec that supports update is 'pools'.")
+	// these fields are rolled out by the controller, it recreates the manager
+	// pods one at a time and runs the interface jobs again
+	newSpec := uv.NewNetwork.DeepCopy().Spec
+	oldSpec := uv.OldNetwork.DeepCopy().Spec
+	for _, spec := range []*vlanmanv1.VlanNetworkSpec{&newSpec, &oldSpec} {
+		spec.Pools = nil
+		spec.ManagerAffinity = nil
+		spec.Mappings = nil
+		spec.Gateways = nil
+		spec.VlanID = 0
+		// only read when allocations are released
+		spec.AddressReleaseDelaySeconds = 0
+	}
+	if !reflect.DeepEqual(newSpec, oldSpec) {
+		return fmt.Errorf("The only fields in spec that support update are 'pools', 'gateways', 'mappings', 'managerAffinity', 'vlanId' and 'addressReleaseDelaySeconds'.")
 	}
 	return nil
 }
 
+// Warnings returns the disruptions caused by the update, worker pods lose their interfaces
+// when the manager pod of their node is recreated on a different vlan
+func (uv *UpdateValidator) Warnings() []string {
+	if uv.NewNetwork.Spec.VlanID == uv.OldNetwork.Spec.VlanID {
+		return nil
+	}
+	workers := []string{}
+	for _, pod := range uv.Pods {
+		if pod.Labels[vlanmanv1.WorkerPodLabelKey] == uv.NewNetwork.Name || pod.Labels[u.WorkerNetworkLabelKey(uv.NewNetwork.Name)] == "true" {
+			workers = append(workers, pod.Namespace+"/"+pod.Name)
+		}
+	}
+	if len(workers) == 0 {
+		return nil
+	}
+	slices.Sort(workers)
+	return []string{fmt.Sprintf("Changing the VLAN ID recreates the manager pods, worker pods have to be restarted to use VLAN %d: %s", uv.NewNetwork.Spec.VlanID, strings.Join(workers, ", "))}
+}
+
 type DeletionValidator struct {
 	*Validator
 	DeletedNetwork *vlanmanv1.VlanNetwork
 }
 
 func NewDeletionValidator(k8s client.Client, ctx context.Context, network *vlanmanv1.VlanNetwork) (*DeletionValidator, error) {
-	relevantPods := &corev1.PodList{}
-	req, err := labels.NewRequirement(vlanmanv1.WorkerPodLabelKey, "==", []string{network.Name})
-	if err != nil || req == nil {
-		return nil, &errs.InternalError{Context: "Couldn't create a label requirement to list pods in  NewDeletionValidator"}
-	}
-	err = k8s.List(ctx, relevantPods, &client.ListOptions{
-		LabelSelector: labels.NewSelector().Add(*req),
-	})
-	if err != nil {
-		return nil, fmt.Errorf("Error listing pods: %w", err)
+	// pods attached to more than one network only point to the first one with WorkerPodLabelKey
+	pods := []corev1.Pod{}
+	for _, requirement := range [][2]string{
+		{vlanmanv1.WorkerPodLabelKey, network.Name},
+		{u.WorkerNetworkLabelKey(network.Name), "true"},
+	} {
+		relevantPods := &corev1.PodList{}
+		req, err := labels.NewRequirement(requirement[0], "==", []string{requirement[1]})
+		if err != nil || req == nil {
+			return nil, &errs.InternalError{Context: "Couldn't create a label requirement to list pods in  NewDeletionValidator"}
+		}
+		err = k8s.List(ctx, relevantPods, &client.ListOptions{
+			LabelSelector: labels.NewSelector().Add(*req),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Error listing pods: %w", err)
+		}
+		for _, pod := range relevantPods.Items {
+			if !slices.ContainsFunc(pods, func(p corev1.Pod) bool { return p.UID == pod.UID }) {
+				pods = append(pods, pod)
+			}
+		}
 	}
 	validator := &Validator{
-		Pods: relevantPods.Items,
+		Pods: pods,
 	}
 	return &DeletionValidator{
 		Validator:      validator,
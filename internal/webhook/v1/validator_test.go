@@ -2,7 +2,7 @@ package v1
 
 import (
 	"context"
-	"encoding/json"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -193,6 +193,14 @@ func TestValidator_validateUnique(t *testing.T) {
 			expectedError: true,
 			errorContains: "There exists a network with that VLAN ID: network1",
 		},
+		{
+			name: "valid - network keeps its own VLAN ID",
+			newNetwork: &vlanmanv1.VlanNetwork{
+				ObjectMeta: metav1.ObjectMeta{Name: "network1"},
+				Spec:       vlanmanv1.VlanNetworkSpec{VlanID: 100},
+			},
+			expectedError: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -256,16 +264,12 @@ func TestNewCreationValidator(t *testing.T) {
 		vlanNetwork := &vlanmanv1.VlanNetwork{
 			ObjectMeta: metav1.ObjectMeta{Name: "test-network"},
 			Spec: vlanmanv1.VlanNetworkSpec{
-				VlanID:         200,
-				LocalGatewayIP: "192.168.1.1",
-				LocalSubnet:    []string{"192.168.1.0/24"},
+				VlanID:   200,
+				Gateways: []vlanmanv1.Gateway{{Address: "192.168.1.1"}},
 			},
 		}
 
-		objBytes, err := json.Marshal(vlanNetwork)
-		require.NoError(t, err)
-
-		validator, err := NewCreationValidator(client, context.Background(), objBytes)
+		validator, err := NewCreationValidator(client, context.Background(), vlanNetwork)
 
 		require.NoError(t, err)
 		assert.NotNil(t, validator)
@@ -275,16 +279,6 @@ func TestNewCreationValidator(t *testing.T) {
 		assert.Equal(t, "test-network", validator.NewNetwork.Name)
 		assert.Equal(t, 200, validator.NewNetwork.Spec.VlanID)
 	})
-
-	t.Run("invalid JSON", func(t *testing.T) {
-		invalidJSON := []byte(`{"invalid": json}`)
-
-		validator, err := NewCreationValidator(client, context.Background(), invalidJSON)
-
-		assert.Error(t, err)
-		assert.Nil(t, validator)
-		assert.Contains(t, err.Error(), "Couldn't unmarshal vlan network for creation")
-	})
 }
 
 func TestCreationValidator_Validate(t *testing.T) {
@@ -370,6 +364,66 @@ func TestCreationValidator_Validate(t *testing.T) {
 			expectedError: true,
 			errorContains: "There exists a network with that VLAN ID",
 		},
+		{
+			name: "invalid - name too long for the worker label",
+			nodes: []corev1.Node{
+				{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+			},
+			newNetwork: &vlanmanv1.VlanNetwork{
+				ObjectMeta: metav1.ObjectMeta{Name: strings.Repeat("n", 64)},
+				Spec:       vlanmanv1.VlanNetworkSpec{VlanID: 200},
+			},
+			expectedError: true,
+			errorContains: "can't be used in the worker pod label",
+		},
+		{
+			name: "invalid - reserved interface name eth0",
+			nodes: []corev1.Node{
+				{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+			},
+			newNetwork: &vlanmanv1.VlanNetwork{
+				ObjectMeta: metav1.ObjectMeta{Name: "new"},
+				Spec:       vlanmanv1.VlanNetworkSpec{VlanID: 200, InterfaceName: "eth0"},
+			},
+			expectedError: true,
+			errorContains: "is reserved",
+		},
+		{
+			name: "invalid - reserved interface name lo",
+			nodes: []corev1.Node{
+				{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+			},
+			newNetwork: &vlanmanv1.VlanNetwork{
+				ObjectMeta: metav1.ObjectMeta{Name: "new"},
+				Spec:       vlanmanv1.VlanNetworkSpec{VlanID: 200, InterfaceName: "lo"},
+			},
+			expectedError: true,
+			errorContains: "is reserved",
+		},
+		{
+			name: "invalid - reserved interface name .",
+			nodes: []corev1.Node{
+				{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+			},
+			newNetwork: &vlanmanv1.VlanNetwork{
+				ObjectMeta: metav1.ObjectMeta{Name: "new"},
+				Spec:       vlanmanv1.VlanNetworkSpec{VlanID: 200, InterfaceName: "."},
+			},
+			expectedError: true,
+			errorContains: "is reserved",
+		},
+		{
+			name: "invalid - reserved interface name ..",
+			nodes: []corev1.Node{
+				{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+			},
+			newNetwork: &vlanmanv1.VlanNetwork{
+				ObjectMeta: metav1.ObjectMeta{Name: "new"},
+				Spec:       vlanmanv1.VlanNetworkSpec{VlanID: 200, InterfaceName: ".."},
+			},
+			expectedError: true,
+			errorContains: "is reserved",
+		},
 	}
 
 	for _, tt := range tests {
@@ -395,3 +449,102 @@ func TestCreationValidator_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateValidator_Validate(t *testing.T) {
+	network := func(mutate func(*vlanmanv1.VlanNetwork)) *vlanmanv1.VlanNetwork {
+		net := &vlanmanv1.VlanNetwork{
+			ObjectMeta: metav1.ObjectMeta{Name: "net1"},
+			Spec: vlanmanv1.VlanNetworkSpec{
+				VlanID:   100,
+				Gateways: []vlanmanv1.Gateway{{Address: "192.168.1.1"}},
+				Mappings: []vlanmanv1.IPMapping{{NodeName: "node1", Interface: "eth0"}},
+			},
+		}
+		if mutate != nil {
+			mutate(net)
+		}
+		return net
+	}
+	existing := []vlanmanv1.VlanNetwork{*network(nil), {
+		ObjectMeta: metav1.ObjectMeta{Name: "net2"},
+		Spec:       vlanmanv1.VlanNetworkSpec{VlanID: 200},
+	}}
+	worker := corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      "worker",
+		Namespace: "default",
+		Labels:    map[string]string{vlanmanv1.WorkerPodLabelKey: "net1"},
+	}}
+
+	tests := []struct {
+		name           string
+		newNetwork     *vlanmanv1.VlanNetwork
+		pods           []corev1.Pod
+		errorContains  string
+		expectWarnings int
+	}{
+		{
+			name:       "unchanged",
+			newNetwork: network(nil),
+		},
+		{
+			name: "gateways, mappings and affinity change",
+			newNetwork: network(func(n *vlanmanv1.VlanNetwork) {
+				n.Spec.Gateways = append(n.Spec.Gateways, vlanmanv1.Gateway{Address: "fd00::1"})
+				n.Spec.Mappings[0].Interface = "eth1"
+				n.Spec.ManagerAffinity = &corev1.Affinity{}
+			}),
+		},
+		{
+			name:       "release delay changes",
+			newNetwork: network(func(n *vlanmanv1.VlanNetwork) { n.Spec.AddressReleaseDelaySeconds = 60 }),
+		},
+		{
+			name:       "vlan id changes without workers",
+			newNetwork: network(func(n *vlanmanv1.VlanNetwork) { n.Spec.VlanID = 101 }),
+		},
+		{
+			name:           "vlan id changes with workers",
+			newNetwork:     network(func(n *vlanmanv1.VlanNetwork) { n.Spec.VlanID = 101 }),
+			pods:           []corev1.Pod{worker},
+			expectWarnings: 1,
+		},
+		{
+			name:          "vlan id taken by another network",
+			newNetwork:    network(func(n *vlanmanv1.VlanNetwork) { n.Spec.VlanID = 200 }),
+			errorContains: "There exists a network with that VLAN ID: net2",
+		},
+		{
+			name:          "attachment changes",
+			newNetwork:    network(func(n *vlanmanv1.VlanNetwork) { n.Spec.Attachment = vlanmanv1.AttachmentIPvlanL2 }),
+			errorContains: "The only fields in spec that support update",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := network(nil)
+			newNetwork := tt.newNetwork.DeepCopy()
+			validator := &UpdateValidator{
+				Validator: &Validator{
+					Nodes:    []corev1.Node{{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}},
+					Pods:     tt.pods,
+					Networks: existing,
+				},
+				OldNetwork: old,
+				NewNetwork: tt.newNetwork,
+			}
+
+			err := validator.Validate()
+
+			if tt.errorContains != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorContains)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, validator.Warnings(), tt.expectWarnings)
+			assert.Equal(t, newNetwork, tt.newNetwork, "the validated objects must not be modified")
+			assert.Equal(t, network(nil), old, "the validated objects must not be modified")
+		})
+	}
+}
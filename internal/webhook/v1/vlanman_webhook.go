@@ -2,6 +2,7 @@ package v1
 
 import (
 	"context"
+	"reflect"
 
 	vlanmanv1 "dialo.ai/vlanman/api/v1"
 	"dialo.ai/vlanman/internal/controller"
@@ -41,7 +42,7 @@ func (v *VlanmanCustomValidator) ValidateCreate(ctx context.Context, obj runtime
 	if !ok {
 		return nil, errs.NewTypeMismatchError("Validating creation", obj)
 	}
-	validator, err := NewDeletionValidator(v.Client, ctx, network)
+	validator, err := NewCreationValidator(v.Client, ctx, network)
 	if err != nil {
 		return nil, err
 	}
@@ -77,6 +78,11 @@ func (v *VlanmanCustomValidator) ValidateUpdate(ctx context.Context, objOld, obj
 	if !ok {
 		return nil, errs.NewTypeMismatchError("Validating update", objNew)
 	}
+	// the finalizer is added and removed with metadata-only updates, they don't change
+	// what was validated before, neither do updates of a network that's being deleted
+	if networkNew.DeletionTimestamp != nil || reflect.DeepEqual(networkOld.Spec, networkNew.Spec) {
+		return nil, nil
+	}
 
 	validator, err := NewUpdateValidator(v.Client, ctx, networkNew, networkOld)
 	if err != nil {
@@ -86,5 +92,5 @@ func (v *VlanmanCustomValidator) ValidateUpdate(ctx context.Context, objOld, obj
 	if err != nil {
 		return nil, err
 	}
-	return nil, nil
+	return validator.Warnings(), nil
 }
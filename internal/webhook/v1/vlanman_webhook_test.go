@@ -0,0 +1,110 @@
+package v1
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	vlanmanv1 "dialo.ai/vlanman/api/v1"
+)
+
+func TestVlanmanCustomValidator_ValidateCreate(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = vlanmanv1.AddToScheme(scheme)
+
+	tests := []struct {
+		name          string
+		vlanID        int
+		errorContains string
+	}{
+		{name: "valid network", vlanID: 200},
+		{name: "duplicate VLAN ID", vlanID: 100, errorContains: "There exists a network with that VLAN ID"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+				&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+				&vlanmanv1.VlanNetwork{ObjectMeta: metav1.ObjectMeta{Name: "existing"}, Spec: vlanmanv1.VlanNetworkSpec{VlanID: 100}},
+			).Build()
+			v := &VlanmanCustomValidator{Client: c}
+
+			_, err := v.ValidateCreate(context.Background(), &vlanmanv1.VlanNetwork{
+				ObjectMeta: metav1.ObjectMeta{Name: "new"},
+				Spec:       vlanmanv1.VlanNetworkSpec{VlanID: tt.vlanID},
+			})
+
+			if tt.errorContains != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorContains)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestVlanmanCustomValidator_ValidateUpdate(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = vlanmanv1.AddToScheme(scheme)
+
+	network := func(mutate func(*vlanmanv1.VlanNetwork)) *vlanmanv1.VlanNetwork {
+		net := &vlanmanv1.VlanNetwork{
+			ObjectMeta: metav1.ObjectMeta{Name: "net1"},
+			Spec:       vlanmanv1.VlanNetworkSpec{VlanID: 100},
+		}
+		if mutate != nil {
+			mutate(net)
+		}
+		return net
+	}
+
+	// without nodes every spec validation fails
+	tests := []struct {
+		name          string
+		newNetwork    *vlanmanv1.VlanNetwork
+		errorContains string
+	}{
+		{
+			name: "finalizer added",
+			newNetwork: network(func(n *vlanmanv1.VlanNetwork) {
+				n.Finalizers = []string{vlanmanv1.NetworkFinalizer}
+			}),
+		},
+		{
+			name: "finalizer removed from a deleted network",
+			newNetwork: network(func(n *vlanmanv1.VlanNetwork) {
+				n.DeletionTimestamp = &metav1.Time{}
+				n.Spec.VlanID = 101
+			}),
+		},
+		{
+			name:          "spec changed",
+			newNetwork:    network(func(n *vlanmanv1.VlanNetwork) { n.Spec.VlanID = 101 }),
+			errorContains: "Couldn't validate minimum node requirement",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &VlanmanCustomValidator{Client: fake.NewClientBuilder().WithScheme(scheme).Build()}
+
+			_, err := v.ValidateUpdate(context.Background(), network(nil), tt.newNetwork)
+
+			if tt.errorContains != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorContains)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
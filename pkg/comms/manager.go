@@ -13,8 +13,21 @@ type PIDResponse struct {
 
 type MacvlanRequest struct {
 	NsID int64 `json:"ns_id"`
+	// Name of the interface in the pod, the manager picks a default if empty
+	Name string `json:"name,omitempty"`
 }
 
 type MacvlanResponse struct {
-	Id int `json:"vlan_id"`
+	Id   int    `json:"vlan_id"`
+	Name string `json:"name"`
+	MTU  int    `json:"mtu,omitempty"`
+}
+
+// AddressConflictRequest reports an address of a worker pod that's used by another host on the vlan
+type AddressConflictRequest struct {
+	Address string `json:"address"`
+	// MAC address of the host using the address, empty if it isn't known
+	MAC       string `json:"mac,omitempty"`
+	Pod       string `json:"pod"`
+	Namespace string `json:"namespace"`
 }
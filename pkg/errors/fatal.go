@@ -20,3 +20,23 @@ func (e *UnrecoverableError) Error() string {
 func (e *UnrecoverableError) Unwrap() error {
 	return e.Err
 }
+
+var ErrAddressConflict = errors.New("The address is used by another host on the vlan")
+
+type AddressConflictError struct {
+	Address string
+	// empty if the host isn't known
+	MAC string
+}
+
+func (e *AddressConflictError) Error() string {
+	holder := "another host"
+	if e.MAC != "" {
+		holder = "host " + e.MAC
+	}
+	return fmt.Sprintf("Address %s is already used on the vlan by %s, it's quarantined in the status of the network. The pod keeps the address and its init container fails with this error on every restart, delete the pod so its replacement gets another address", e.Address, holder)
+}
+
+func (e *AddressConflictError) Unwrap() error {
+	return ErrAddressConflict
+}
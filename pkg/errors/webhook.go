@@ -3,6 +3,7 @@ package errors
 import (
 	"errors"
 	"fmt"
+	"strings"
 )
 
 // Validating
@@ -58,6 +59,85 @@ func (e *NoIPInPoolError) Unwrap() error {
 	return ErrNoIPInPool
 }
 
+var ErrAddressNotInPool = errors.New("The address requested by this pod is not in its pool")
+
+type AddressNotInPoolError struct {
+	Resource string
+	Address  string
+	Pool     string
+}
+
+func (e *AddressNotInPoolError) Error() string {
+	return fmt.Sprintf("Pod %s requests address %s which is not in pool %s", e.Resource, e.Address, e.Pool)
+}
+
+func (e *AddressNotInPoolError) Unwrap() error {
+	return ErrAddressNotInPool
+}
+
+var ErrAddressInUse = errors.New("The address requested by this pod is already allocated")
+
+type AddressInUseError struct {
+	Resource string
+	Address  string
+}
+
+func (e *AddressInUseError) Error() string {
+	return fmt.Sprintf("Pod %s requests address %s which is already allocated", e.Resource, e.Address)
+}
+
+func (e *AddressInUseError) Unwrap() error {
+	return ErrAddressInUse
+}
+
+var ErrInterfaceNameConflict = errors.New("Networks of this pod use the same interface name")
+
+type InterfaceNameConflictError struct {
+	Resource string
+	Name     string
+	Networks []string
+}
+
+func (e *InterfaceNameConflictError) Error() string {
+	return fmt.Sprintf("Pod %s is attached to networks %s which use the same interface name %s", e.Resource, strings.Join(e.Networks, ", "), e.Name)
+}
+
+func (e *InterfaceNameConflictError) Unwrap() error {
+	return ErrInterfaceNameConflict
+}
+
+var ErrUnknownPool = errors.New("This pod's pool doesn't exist in the network")
+
+type UnknownPoolError struct {
+	Resource string
+	Network  string
+	Pool     string
+}
+
+func (e *UnknownPoolError) Error() string {
+	return fmt.Sprintf("Pod %s requests a pool (%s) which doesn't exist in network %s", e.Resource, e.Pool, e.Network)
+}
+
+func (e *UnknownPoolError) Unwrap() error {
+	return ErrUnknownPool
+}
+
+var ErrClaimInUse = errors.New("The claim of this pod is held by another pod")
+
+type ClaimInUseError struct {
+	Resource string
+	Claim    string
+	Holder   string
+}
+
+func (e *ClaimInUseError) Error() string {
+	return fmt.Sprintf("Pod %s uses claim %s which is already held by %s", e.Resource, e.Claim, e.Holder)
+}
+
+func (e *ClaimInUseError) Unwrap() error {
+	return ErrClaimInUse
+}
+
 var ErrNoManagerPods = errors.New("This network doesn't have any manager pods")
 
 type NoManagerPodsError struct {
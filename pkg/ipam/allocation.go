@@ -0,0 +1,72 @@
+package ipam
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"time"
+
+	vlanmanv1 "dialo.ai/vlanman/api/v1"
+	errs "dialo.ai/vlanman/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ListAllocations lists allocations of a network in all namespaces,
+// if pool is not empty only allocations from that pool are returned
+func ListAllocations(ctx context.Context, c client.Reader, network, pool string) ([]vlanmanv1.VlanIPAllocation, error) {
+	selector := client.MatchingLabels{vlanmanv1.AllocationNetworkLabelKey: network}
+	if pool != "" {
+		selector[vlanmanv1.AllocationPoolLabelKey] = pool
+	}
+	allocs := vlanmanv1.VlanIPAllocationList{}
+	err := c.List(ctx, &allocs, selector)
+	if err != nil {
+		return nil, errs.NewClientRequestError("List VlanIPAllocations", err)
+	}
+	return allocs.Items, nil
+}
+
+// Taken returns the set of addresses held by allocations
+func Taken(allocs []vlanmanv1.VlanIPAllocation) map[netip.Addr]bool {
+	taken := map[netip.Addr]bool{}
+	for _, a := range allocs {
+		for addr := range ParseAddrs(a.Spec.Addresses...) {
+			taken[addr] = true
+		}
+	}
+	return taken
+}
+
+// InQuarantine checks whether the quarantine of the address didn't expire yet
+func InQuarantine(q vlanmanv1.QuarantinedAddress) bool {
+	return time.Since(q.LastDetected.Time) < time.Second*vlanmanv1.AddressQuarantineSeconds
+}
+
+// Quarantined returns the set of addresses in quarantine, they're never picked for a pod
+func Quarantined(quarantined []vlanmanv1.QuarantinedAddress) map[netip.Addr]bool {
+	set := map[netip.Addr]bool{}
+	for _, q := range quarantined {
+		if !InQuarantine(q) {
+			continue
+		}
+		for addr := range ParseAddrs(q.Address) {
+			set[addr] = true
+		}
+	}
+	return set
+}
+
+// IsBound checks whether the allocation is owned by a pod
+func IsBound(a vlanmanv1.VlanIPAllocation) bool {
+	return slices.ContainsFunc(a.OwnerReferences, func(o metav1.OwnerReference) bool {
+		return o.Kind == "Pod"
+	})
+}
+
+// IsSticky checks whether the allocation should outlive its pod
+func IsSticky(a vlanmanv1.VlanIPAllocation) bool {
+	_, sts := a.Labels[vlanmanv1.AllocationStatefulSetLabelKey]
+	_, claim := a.Labels[vlanmanv1.AllocationClaimLabelKey]
+	return sts || claim
+}
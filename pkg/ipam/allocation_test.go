@@ -0,0 +1,89 @@
+package ipam
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	vlanmanv1 "dialo.ai/vlanman/api/v1"
+)
+
+func allocation(name, namespace, network, pool string, addresses ...string) *vlanmanv1.VlanIPAllocation {
+	return &vlanmanv1.VlanIPAllocation{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				vlanmanv1.AllocationNetworkLabelKey: network,
+				vlanmanv1.AllocationPoolLabelKey:    pool,
+			},
+		},
+		Spec: vlanmanv1.VlanIPAllocationSpec{
+			Network:   network,
+			Pool:      pool,
+			Addresses: addresses,
+		},
+	}
+}
+
+func TestListAllocations(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, vlanmanv1.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		allocation("a", "ns1", "net1", "primary", "10.0.0.1/24", "fd00::1/64"),
+		allocation("b", "ns2", "net1", "secondary", "10.0.1.1/24"),
+		allocation("c", "ns1", "net2", "primary", "10.0.0.1/24"),
+	).Build()
+
+	allocs, err := ListAllocations(context.Background(), c, "net1", "")
+	require.NoError(t, err)
+	assert.Len(t, allocs, 2)
+
+	taken := Taken(allocs)
+	assert.Len(t, taken, 3)
+	assert.True(t, taken[netip.MustParseAddr("fd00::1")])
+	assert.True(t, taken[netip.MustParseAddr("10.0.1.1")])
+
+	allocs, err = ListAllocations(context.Background(), c, "net1", "secondary")
+	require.NoError(t, err)
+	require.Len(t, allocs, 1)
+	assert.Equal(t, "b", allocs[0].Name)
+	assert.False(t, IsBound(allocs[0]))
+}
+
+func TestIsBoundAndSticky(t *testing.T) {
+	a := allocation("a", "ns1", "net1", "primary", "10.0.0.1/24")
+	assert.False(t, IsBound(*a))
+	assert.False(t, IsSticky(*a))
+
+	a.OwnerReferences = []metav1.OwnerReference{{Kind: "StatefulSet", Name: "db"}}
+	a.Labels[vlanmanv1.AllocationStatefulSetLabelKey] = "db"
+	assert.False(t, IsBound(*a))
+	assert.True(t, IsSticky(*a))
+
+	b := allocation("b", "ns1", "net1", "primary", "10.0.0.2/24")
+	b.OwnerReferences = []metav1.OwnerReference{{Kind: "Pod", Name: "web"}}
+	assert.True(t, IsBound(*b))
+	assert.False(t, IsSticky(*b))
+}
+
+func TestQuarantined(t *testing.T) {
+	quarantined := []vlanmanv1.QuarantinedAddress{
+		{Address: "10.0.0.5", LastDetected: metav1.Now()},
+		{Address: "fd00::5", LastDetected: metav1.Now()},
+		{Address: "10.0.0.6", LastDetected: metav1.NewTime(time.Now().Add(-2 * time.Second * vlanmanv1.AddressQuarantineSeconds))},
+	}
+
+	set := Quarantined(quarantined)
+	assert.Equal(t, map[netip.Addr]bool{
+		netip.MustParseAddr("10.0.0.5"): true,
+		netip.MustParseAddr("fd00::5"):  true,
+	}, set)
+}
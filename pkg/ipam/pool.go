@@ -0,0 +1,262 @@
+package ipam
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	vlanmanv1 "dialo.ai/vlanman/api/v1"
+)
+
+// Range is an inclusive range of addresses, every address
+// handed out from it gets the same prefix length
+type Range struct {
+	From netip.Addr
+	To   netip.Addr
+	Bits int
+}
+
+// ParseRange parses a single entry of VlanNetworkPool.Addresses or VlanNetworkPool.Exclude.
+// Supported formats are:
+//   - "10.0.0.1" or "10.0.0.1/24": a single address, with an optional prefix length
+//   - "10.0.0.0/26": a subnet (host bits are zero), expands to all usable addresses in it
+//   - "10.0.0.20-10.0.0.80" or "10.0.0.20-10.0.0.80/24": a range of addresses, with an optional prefix length
+func ParseRange(s string) (Range, error) {
+	s = strings.TrimSpace(s)
+	body, prefix, hasPrefix := strings.Cut(s, "/")
+	fromStr, toStr, isRange := strings.Cut(body, "-")
+
+	from, err := netip.ParseAddr(strings.TrimSpace(fromStr))
+	if err != nil {
+		return Range{}, fmt.Errorf("invalid address in '%s': %w", s, err)
+	}
+	from = from.Unmap()
+	bits := from.BitLen()
+	if hasPrefix {
+		bits, err = strconv.Atoi(prefix)
+		if err != nil {
+			return Range{}, fmt.Errorf("invalid prefix length in '%s': %w", s, err)
+		}
+		if bits < 0 || bits > from.BitLen() {
+			return Range{}, fmt.Errorf("prefix length %d out of range in '%s'", bits, s)
+		}
+	}
+
+	if isRange {
+		to, err := netip.ParseAddr(strings.TrimSpace(toStr))
+		if err != nil {
+			return Range{}, fmt.Errorf("invalid address in '%s': %w", s, err)
+		}
+		to = to.Unmap()
+		if from.Is4() != to.Is4() {
+			return Range{}, fmt.Errorf("range '%s' mixes address families", s)
+		}
+		if to.Less(from) {
+			return Range{}, fmt.Errorf("range '%s' ends before it starts", s)
+		}
+		return Range{From: from, To: to, Bits: bits}, nil
+	}
+
+	network := netip.PrefixFrom(from, bits).Masked()
+	if !hasPrefix || network.Addr() != from || bits == from.BitLen() {
+		return Range{From: from, To: from, Bits: bits}, nil
+	}
+
+	// subnet, skip the network address (IPv4 and IPv6 subnet-router anycast)
+	// and the IPv4 broadcast address if the subnet is large enough to have them
+	first := network.Addr()
+	last := lastAddr(network)
+	if bits < from.BitLen()-1 {
+		first = first.Next()
+		if from.Is4() {
+			last = last.Prev()
+		}
+	}
+	return Range{From: first, To: last, Bits: bits}, nil
+}
+
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+func (r Range) Contains(a netip.Addr) bool {
+	a = a.Unmap()
+	return a.Is4() == r.From.Is4() && r.From.Compare(a) <= 0 && a.Compare(r.To) <= 0
+}
+
+func (r Range) Overlaps(o Range) bool {
+	return r.From.Is4() == o.From.Is4() && r.From.Compare(o.To) <= 0 && o.From.Compare(r.To) <= 0
+}
+
+// Size returns the number of addresses in the range
+func (r Range) Size() *big.Int {
+	from := new(big.Int).SetBytes(r.From.AsSlice())
+	to := new(big.Int).SetBytes(r.To.AsSlice())
+	size := new(big.Int).Sub(to, from)
+	return size.Add(size, big.NewInt(1))
+}
+
+// subtract returns the parts of r not covered by ex
+func (r Range) subtract(ex Range) []Range {
+	if !r.Overlaps(ex) {
+		return []Range{r}
+	}
+	parts := []Range{}
+	if r.From.Less(ex.From) {
+		parts = append(parts, Range{From: r.From, To: ex.From.Prev(), Bits: r.Bits})
+	}
+	if ex.To.Less(r.To) {
+		parts = append(parts, Range{From: ex.To.Next(), To: r.To, Bits: r.Bits})
+	}
+	return parts
+}
+
+// Pool is the set of addresses of a VlanNetworkPool,
+// with the excluded addresses already removed
+type Pool struct {
+	Name   string
+	Ranges []Range
+}
+
+func NewPool(p vlanmanv1.VlanNetworkPool) (*Pool, error) {
+	ranges := []Range{}
+	for _, a := range p.Addresses {
+		r, err := ParseRange(a)
+		if err != nil {
+			return nil, fmt.Errorf("pool %s: %w", p.Name, err)
+		}
+		for _, existing := range ranges {
+			if existing.Overlaps(r) {
+				return nil, fmt.Errorf("pool %s: address '%s' overlaps with another entry", p.Name, a)
+			}
+		}
+		ranges = append(ranges, r)
+	}
+
+	for _, e := range p.Exclude {
+		ex, err := ParseRange(e)
+		if err != nil {
+			return nil, fmt.Errorf("pool %s: exclude: %w", p.Name, err)
+		}
+		// exclusions work on addresses, a subnet excludes the network and broadcast address too
+		if network, err := netip.ParsePrefix(strings.TrimSpace(e)); err == nil && network.Masked() == network {
+			ex = Range{From: network.Addr(), To: lastAddr(network), Bits: ex.Bits}
+		}
+		remaining := []Range{}
+		for _, r := range ranges {
+			remaining = append(remaining, r.subtract(ex)...)
+		}
+		ranges = remaining
+	}
+
+	slices.SortFunc(ranges, func(a, b Range) int {
+		return a.From.Compare(b.From)
+	})
+	return &Pool{Name: p.Name, Ranges: ranges}, nil
+}
+
+func (p *Pool) Contains(a netip.Addr) bool {
+	return slices.ContainsFunc(p.Ranges, func(r Range) bool {
+		return r.Contains(a)
+	})
+}
+
+func (p *Pool) Overlaps(o *Pool) bool {
+	for _, r := range p.Ranges {
+		for _, or := range o.Ranges {
+			if r.Overlaps(or) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Prefix returns the address with the prefix length of the range it belongs to
+func (p *Pool) Prefix(a netip.Addr) (netip.Prefix, bool) {
+	a = a.Unmap()
+	for _, r := range p.Ranges {
+		if r.Contains(a) {
+			return netip.PrefixFrom(a, r.Bits), true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// Size returns the number of addresses in the pool, saturating at math.MaxInt64
+func (p *Pool) Size() int64 {
+	total := new(big.Int)
+	for _, r := range p.Ranges {
+		total.Add(total, r.Size())
+	}
+	if !total.IsInt64() {
+		return math.MaxInt64
+	}
+	return total.Int64()
+}
+
+// Free returns the number of addresses in the pool which are not taken
+func (p *Pool) Free(taken map[netip.Addr]bool) int64 {
+	size := p.Size()
+	if size == math.MaxInt64 {
+		return size
+	}
+	for a := range taken {
+		if p.Contains(a) {
+			size--
+		}
+	}
+	return size
+}
+
+// FirstFree returns the lowest address of the given family that's not taken
+func (p *Pool) FirstFree(v6 bool, taken map[netip.Addr]bool) (netip.Prefix, bool) {
+	for _, r := range p.Ranges {
+		if r.From.Is6() != v6 {
+			continue
+		}
+		for a := r.From; a.IsValid() && a.Compare(r.To) <= 0; a = a.Next() {
+			if !taken[a] {
+				return netip.PrefixFrom(a, r.Bits), true
+			}
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// Pick returns the first free address of every address family present
+// in the pool, so pods in a dual-stack pool get an IPv4 and IPv6 pair.
+// The IPv4 address, if any, always comes first.
+func (p *Pool) Pick(taken map[netip.Addr]bool) []netip.Prefix {
+	picked := []netip.Prefix{}
+	for _, v6 := range []bool{false, true} {
+		if addr, ok := p.FirstFree(v6, taken); ok {
+			picked = append(picked, addr)
+		}
+	}
+	return picked
+}
+
+// ParseAddrs parses addresses with optional prefix lengths into a set,
+// entries that fail to parse are skipped
+func ParseAddrs(addrs ...string) map[netip.Addr]bool {
+	set := map[netip.Addr]bool{}
+	for _, a := range addrs {
+		bare, _, _ := strings.Cut(a, "/")
+		addr, err := netip.ParseAddr(strings.TrimSpace(bare))
+		if err != nil {
+			continue
+		}
+		set[addr.Unmap()] = true
+	}
+	return set
+}
@@ -0,0 +1,111 @@
+package ipam
+
+import (
+	"math"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	vlanmanv1 "dialo.ai/vlanman/api/v1"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		from          string
+		to            string
+		bits          int
+		expectedError bool
+	}{
+		{name: "single address", input: "10.0.0.1", from: "10.0.0.1", to: "10.0.0.1", bits: 32},
+		{name: "single address with prefix", input: "10.0.0.1/24", from: "10.0.0.1", to: "10.0.0.1", bits: 24},
+		{name: "subnet", input: "10.0.10.0/26", from: "10.0.10.1", to: "10.0.10.62", bits: 26},
+		{name: "point to point subnet", input: "10.0.10.0/31", from: "10.0.10.0", to: "10.0.10.1", bits: 31},
+		{name: "range", input: "10.0.10.20-10.0.10.80", from: "10.0.10.20", to: "10.0.10.80", bits: 32},
+		{name: "range with prefix", input: "10.0.10.20-10.0.10.80/24", from: "10.0.10.20", to: "10.0.10.80", bits: 24},
+		{name: "ipv6 subnet", input: "fd00::/120", from: "fd00::1", to: "fd00::ff", bits: 120},
+		{name: "ipv6 single", input: "fd00::5/64", from: "fd00::5", to: "fd00::5", bits: 64},
+		{name: "reversed range", input: "10.0.10.80-10.0.10.20", expectedError: true},
+		{name: "mixed families", input: "10.0.0.1-fd00::1", expectedError: true},
+		{name: "invalid prefix", input: "10.0.0.0/40", expectedError: true},
+		{name: "invalid address", input: "10.0.0", expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := ParseRange(tt.input)
+			if tt.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, netip.MustParseAddr(tt.from), r.From)
+			assert.Equal(t, netip.MustParseAddr(tt.to), r.To)
+			assert.Equal(t, tt.bits, r.Bits)
+		})
+	}
+}
+
+func TestPool(t *testing.T) {
+	pool, err := NewPool(vlanmanv1.VlanNetworkPool{
+		Name:      "primary",
+		Addresses: []string{"10.0.10.0/28", "10.0.20.1/24"},
+		Exclude:   []string{"10.0.10.1", "10.0.10.5-10.0.10.10"},
+	})
+	require.NoError(t, err)
+
+	// 14 usable addresses in the /28, minus 7 excluded, plus a single one
+	assert.Equal(t, int64(8), pool.Size())
+	assert.True(t, pool.Contains(netip.MustParseAddr("10.0.10.2")))
+	assert.False(t, pool.Contains(netip.MustParseAddr("10.0.10.7")))
+	assert.False(t, pool.Contains(netip.MustParseAddr("10.0.10.15")))
+
+	taken := ParseAddrs("10.0.10.2", "10.0.10.3/28")
+	assert.Equal(t, int64(6), pool.Free(taken))
+
+	picked := pool.Pick(taken)
+	require.Len(t, picked, 1)
+	assert.Equal(t, "10.0.10.4/28", picked[0].String())
+
+	prefix, ok := pool.Prefix(netip.MustParseAddr("10.0.20.1"))
+	assert.True(t, ok)
+	assert.Equal(t, "10.0.20.1/24", prefix.String())
+}
+
+func TestPoolDualStack(t *testing.T) {
+	pool, err := NewPool(vlanmanv1.VlanNetworkPool{
+		Name:      "dual",
+		Addresses: []string{"fd00::/64", "10.0.0.1/24"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(math.MaxInt64), pool.Size())
+
+	picked := pool.Pick(map[netip.Addr]bool{})
+	require.Len(t, picked, 2)
+	assert.Equal(t, "10.0.0.1/24", picked[0].String())
+	assert.Equal(t, "fd00::1/64", picked[1].String())
+
+	picked = pool.Pick(ParseAddrs("10.0.0.1", "fd00::1"))
+	require.Len(t, picked, 1)
+	assert.Equal(t, "fd00::2/64", picked[0].String())
+}
+
+func TestPoolOverlaps(t *testing.T) {
+	_, err := NewPool(vlanmanv1.VlanNetworkPool{
+		Name:      "overlapping",
+		Addresses: []string{"10.0.0.0/24", "10.0.0.5"},
+	})
+	assert.Error(t, err)
+
+	a, err := NewPool(vlanmanv1.VlanNetworkPool{Name: "a", Addresses: []string{"10.0.0.0/24"}})
+	require.NoError(t, err)
+	b, err := NewPool(vlanmanv1.VlanNetworkPool{Name: "b", Addresses: []string{"10.0.0.100-10.0.1.10"}})
+	require.NoError(t, err)
+	c, err := NewPool(vlanmanv1.VlanNetworkPool{Name: "c", Addresses: []string{"10.0.1.0/24"}, Exclude: []string{"10.0.1.0/28"}})
+	require.NoError(t, err)
+	assert.True(t, a.Overlaps(b))
+	assert.False(t, a.Overlaps(c))
+}
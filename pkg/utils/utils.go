@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
-	"regexp"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
@@ -15,6 +17,7 @@ import (
 	vlanmanv1 "dialo.ai/vlanman/api/v1"
 	errs "dialo.ai/vlanman/pkg/errors"
 
+	"golang.org/x/sys/unix"
 	admissionv1 "k8s.io/api/admission/v1"
 )
 
@@ -29,11 +32,79 @@ func Fatal(err error, l *slog.Logger, message string, args ...any) {
 	}
 }
 
-// https://ihateregex.io/expr/ip/
-// IsValidIPv4 checks wheter a given IP address string is a valid IPv4 address
-func IsValidIPv4(ip string) bool {
-	regex := regexp.MustCompile(`(\b25[0-5]|\b2[0-4][0-9]|\b[01]?[0-9][0-9]?)(\.(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)){3}`)
-	return regex.MatchString(ip)
+// WorkerNetworkLabelKey returns the label marking a worker pod as attached to the network.
+// Unlike WorkerPodLabelKey it works for pods attached to more than one network.
+func WorkerNetworkLabelKey(network string) string {
+	return vlanmanv1.WorkerPodNetworkLabelPrefix + network
+}
+
+// DefaultInterfaceName is the name of the macvlan interface in worker pods
+// of networks that don't set VlanNetworkSpec.InterfaceName
+func DefaultInterfaceName(vlanID int) string {
+	return "macvlan" + strconv.Itoa(vlanID)
+}
+
+// NetNsInode returns the inode identifying the network namespace of a process,
+// pid can be "self" for the calling process
+func NetNsInode(pid string) (uint64, error) {
+	st := unix.Stat_t{}
+	err := unix.Stat(filepath.Join("/proc", pid, "ns", "net"), &st)
+	if err != nil {
+		return 0, fmt.Errorf("Couldn't stat network namespace of process %s: %w", pid, err)
+	}
+	return st.Ino, nil
+}
+
+// IsValidIP checks wheter a given string is a valid IPv4 or IPv6 address,
+// optionally followed by a prefix length
+func IsValidIP(ip string) bool {
+	_, err := ParseAddress(ip)
+	return err == nil
+}
+
+// IsIPv6 checks whether a given address (with an optional prefix length) is an IPv6 address
+func IsIPv6(ip string) bool {
+	addr, err := ParseAddress(ip)
+	return err == nil && addr.IP.To4() == nil
+}
+
+// ParseAddress parses an IP address with an optional prefix length.
+// If the prefix length is omitted a host prefix is chosen
+// based on the address family (/32 for IPv4, /128 for IPv6).
+// The returned IP is not masked.
+func ParseAddress(s string) (*net.IPNet, error) {
+	addr, prefix, found := strings.Cut(strings.TrimSpace(s), "/")
+	parsed, err := netip.ParseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	parsed = parsed.Unmap()
+	bits := parsed.BitLen()
+	ones := bits
+	if found {
+		ones, err = strconv.Atoi(prefix)
+		if err != nil {
+			return nil, err
+		}
+		if ones < 0 || ones > bits {
+			return nil, fmt.Errorf("prefix length %d out of range for %s", ones, addr)
+		}
+	}
+	return &net.IPNet{
+		IP:   net.IP(parsed.AsSlice()),
+		Mask: net.CIDRMask(ones, bits),
+	}, nil
+}
+
+// ParseNetwork works like ParseAddress but masks the IP,
+// which makes the result usable as a route destination
+func ParseNetwork(s string) (*net.IPNet, error) {
+	ipnet, err := ParseAddress(s)
+	if err != nil {
+		return nil, err
+	}
+	ipnet.IP = ipnet.IP.Mask(ipnet.Mask)
+	return ipnet, nil
 }
 
 // https://github.com/slackhq/simple-kubernetes-webhook/blob/main/main.go
@@ -115,29 +186,24 @@ func GetCallerInfo() CallerInfo {
 }
 
 func PopulateStatus(status vlanmanv1.VlanNetworkStatus, poolNames ...string) vlanmanv1.VlanNetworkStatus {
-	if status.PendingIPs == nil {
-		status.PendingIPs = map[string]map[string]string{}
+	if status.FreeIPCount == nil {
+		status.FreeIPCount = map[string]int64{}
 	}
-	if status.FreeIPs == nil {
-		status.FreeIPs = map[string][]string{}
+	if status.State == nil {
+		status.State = map[string]vlanmanv1.ConnectionState{}
 	}
 	for _, poolName := range poolNames {
-		if _, ok := status.FreeIPs[poolName]; !ok {
-			status.FreeIPs[poolName] = []string{}
+		if _, ok := status.FreeIPCount[poolName]; !ok {
+			status.FreeIPCount[poolName] = 0
 		}
 	}
 	return status
 }
 
 func IpToByteArray(ip string) ([]byte, error) {
-	octets := strings.Split(ip, ".")
-	octet_bytes := []byte{}
-	for _, o := range octets {
-		i, err := strconv.ParseUint(o, 10, 8)
-		if err != nil {
-			return nil, err
-		}
-		octet_bytes = append(octet_bytes, byte(i))
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, err
 	}
-	return octet_bytes, nil
+	return addr.Unmap().AsSlice(), nil
 }
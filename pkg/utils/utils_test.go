@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expected      string
+		expectedError bool
+	}{
+		{name: "ipv4 without prefix", input: "10.0.0.1", expected: "10.0.0.1/32"},
+		{name: "ipv4 with prefix", input: "10.0.0.1/24", expected: "10.0.0.1/24"},
+		{name: "ipv6 without prefix", input: "fd00::1", expected: "fd00::1/128"},
+		{name: "ipv6 with prefix", input: "fd00::1/64", expected: "fd00::1/64"},
+		{name: "ipv4 mapped ipv6", input: "::ffff:10.0.0.1", expected: "10.0.0.1/32"},
+		{name: "prefix too long for ipv4", input: "10.0.0.1/33", expectedError: true},
+		{name: "invalid prefix", input: "10.0.0.1/abc", expectedError: true},
+		{name: "not an address", input: "10.0.0", expectedError: true},
+		{name: "empty", input: "", expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ipnet, err := ParseAddress(tt.input)
+			if tt.expectedError {
+				assert.Error(t, err)
+				assert.False(t, IsValidIP(tt.input))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, ipnet.String())
+			assert.True(t, IsValidIP(tt.input))
+		})
+	}
+}
+
+func TestParseNetwork(t *testing.T) {
+	ipnet, err := ParseNetwork("10.10.10.10/24")
+	require.NoError(t, err)
+	assert.Equal(t, "10.10.10.0/24", ipnet.String())
+
+	ipnet, err = ParseNetwork("fd00:10::5/64")
+	require.NoError(t, err)
+	assert.Equal(t, "fd00:10::/64", ipnet.String())
+}
+
+func TestIsIPv6(t *testing.T) {
+	assert.True(t, IsIPv6("fd00::1"))
+	assert.True(t, IsIPv6("fd00::1/64"))
+	assert.False(t, IsIPv6("10.0.0.1"))
+	assert.False(t, IsIPv6("10.0.0.1/24"))
+	assert.False(t, IsIPv6("garbage"))
+}
+
+func TestIpToByteArray(t *testing.T) {
+	b, err := IpToByteArray("10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{10, 0, 0, 1}, b)
+
+	b, err = IpToByteArray("fd00::1")
+	require.NoError(t, err)
+	assert.Len(t, b, 16)
+
+	_, err = IpToByteArray("10.0.0.256")
+	assert.Error(t, err)
+}